@@ -0,0 +1,287 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GetMeAPI serves GET /api/me: the signed-in user's account.
+func GetMeAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("GetMeAPI(+)")
+
+	lUser, lErr := getUserWithoutPassword(CurrentUser(r).ID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("GetMeAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Account retrieved successfully",
+		Data:    lUser,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("GetMeAPI(-)")
+}
+
+// UpdateMeAPI serves PATCH /api/me, which changes the username and/or email.
+func UpdateMeAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("UpdateMeAPI(+)")
+
+	var lReq UpdateProfileRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("UpdateMeAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr := UpdateProfile(CurrentUser(r), lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("UpdateMeAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Account updated",
+		Data:    lUser,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("UpdateMeAPI(-)")
+}
+
+// ChangePasswordAPI serves POST /api/me/password. The current session stays
+// logged in; every other session is revoked.
+func ChangePasswordAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ChangePasswordAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	var lReq ChangePasswordRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ChangePasswordAPI(-) error:", lErr)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, lUser, lReq.CurrentPassword) {
+		log.Println("ChangePasswordAPI(-) error: current password rejected")
+		return
+	}
+
+	lRevoked, lErr := ChangePassword(lUser, lReq.NewPassword, CurrentSessionID(r))
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ChangePasswordAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Password changed",
+		Data:    map[string]int{"revoked": lRevoked},
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ChangePasswordAPI(-)")
+}
+
+// DeleteMeAPI serves DELETE /api/me. The body must confirm the password.
+func DeleteMeAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeleteMeAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	var lReq DeleteAccountRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DeleteMeAPI(-) error:", lErr)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, lUser, lReq.Password) {
+		log.Println("DeleteMeAPI(-) error: password rejected")
+		return
+	}
+
+	lErr = DeleteAccount(lUser.ID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DeleteMeAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Account deleted",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("DeleteMeAPI(-)")
+}
+
+// checkCurrentPassword verifies pPassword for pUser under the login rate
+// limiter, so that a stolen session cannot be used to guess the password.
+// When the password is refused, the error response has already been sent.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, pUser *User, pPassword string) bool {
+	lIP := ClientIP(r)
+
+	lThrottleKey := UserThrottleKey(pUser.ID)
+	lRetryAfter, lErr := LoginRetryAfter(lThrottleKey, lIP)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		return false
+	}
+	if lRetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lRetryAfter.Seconds()))))
+		SendErrorResponse(w, ErrTooManyLoginAttempts)
+		return false
+	}
+
+	lErr = VerifyPassword(pUser.ID, pPassword)
+	if lErr == ErrIncorrectPassword {
+		lRecordErr := RecordLoginFailure(lThrottleKey, pUser.Username, lIP)
+		if lRecordErr != nil {
+			log.Println("checkCurrentPassword record failure error:", lRecordErr)
+		}
+	}
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		return false
+	}
+
+	lErr = ClearLoginFailures(lThrottleKey)
+	if lErr != nil {
+		log.Println("checkCurrentPassword clear failures error:", lErr)
+	}
+	return true
+}
+
+// VerifyPassword checks pPassword against pUserID's password hash and returns
+// ErrIncorrectPassword when it does not match.
+func VerifyPassword(pUserID int, pPassword string) error {
+	log.Println("VerifyPassword(+)")
+
+	lUser, lErr := GetStore().Users.GetUserByID(pUserID)
+	if lErr != nil {
+		log.Println("VerifyPassword(-) error:", lErr)
+		return lErr
+	}
+
+	lErr = bcrypt.CompareHashAndPassword([]byte(lUser.Password), []byte(pPassword))
+	if lErr == bcrypt.ErrMismatchedHashAndPassword {
+		log.Println("VerifyPassword(-) error:", lErr)
+		return ErrIncorrectPassword
+	}
+	if lErr != nil {
+		log.Println("VerifyPassword(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("VerifyPassword(-)")
+	return nil
+}
+
+// UpdateProfile applies pReq to pUser after validating the fields it sets. A
+// new email address must be verified again, so a verification link is sent
+// to it.
+func UpdateProfile(pUser *User, pReq UpdateProfileRequest) (*User, error) {
+	log.Println("UpdateProfile(+)")
+
+	lUsername := pUser.Username
+	lEmail := pUser.Email
+	lFieldsMap := map[string]string{}
+
+	if pReq.Username != nil {
+		lUsername = *pReq.Username
+		if lMessage := validateUsername(lUsername); lMessage != "" {
+			lFieldsMap["username"] = lMessage
+		}
+	}
+	if pReq.Email != nil {
+		lEmail = *pReq.Email
+		if lMessage := validateEmail(lEmail); lMessage != "" {
+			lFieldsMap["email"] = lMessage
+		}
+	}
+
+	if len(lFieldsMap) > 0 {
+		log.Println("UpdateProfile(-) error: invalid fields")
+		return nil, NewValidationError(lFieldsMap)
+	}
+
+	lUpdated, lErr := GetStore().Users.UpdateUserProfile(pUser.ID, lUsername, lEmail)
+	if lErr != nil {
+		log.Println("UpdateProfile(-) error:", lErr)
+		return nil, lErr
+	}
+
+	if !strings.EqualFold(pUser.Email, lEmail) {
+		sendMailInBackground("UpdateProfile verification email", func() error {
+			return SendVerificationEmail(lUpdated)
+		})
+	}
+
+	log.Println("UpdateProfile(-)")
+	return lUpdated, nil
+}
+
+// ChangePassword sets pUser's password to pNewPassword, which must satisfy
+// the password policy, and revokes every session but pKeepSessionID,
+// returning how many were revoked.
+func ChangePassword(pUser *User, pNewPassword string, pKeepSessionID int) (int, error) {
+	log.Println("ChangePassword(+)")
+
+	if lMessage := ValidatePassword(pNewPassword, pUser.Username); lMessage != "" {
+		log.Println("ChangePassword(-) error:", lMessage)
+		return 0, NewValidationError(map[string]string{"new_password": lMessage})
+	}
+
+	lHashedPassword, lErr := bcrypt.GenerateFromPassword([]byte(pNewPassword), bcrypt.DefaultCost)
+	if lErr != nil {
+		log.Println("ChangePassword(-) error:", lErr)
+		return 0, lErr
+	}
+
+	lErr = GetStore().Users.UpdatePassword(pUser.ID, string(lHashedPassword))
+	if lErr != nil {
+		log.Println("ChangePassword(-) error:", lErr)
+		return 0, lErr
+	}
+
+	lRevoked, lErr := GetStore().Sessions.DeleteOtherSessions(pUser.ID, pKeepSessionID)
+	if lErr != nil {
+		log.Println("ChangePassword(-) error:", lErr)
+		return 0, lErr
+	}
+
+	log.Println("ChangePassword(-)")
+	return lRevoked, nil
+}
+
+// DeleteAccount deletes pUserID and, through the store, all of its data.
+func DeleteAccount(pUserID int) error {
+	log.Println("DeleteAccount(+)")
+
+	lErr := GetStore().Users.DeleteUser(pUserID)
+	if lErr != nil {
+		log.Println("DeleteAccount(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("DeleteAccount(-)")
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUpdateProfileUniqueness(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	signupTestUser(t, lServer, "bob")
+
+	lBobName, lBobEmail := "BOB", "Bob@example.com"
+	lCasesArr := []struct {
+		req  UpdateProfileRequest
+		code string
+	}{
+		{UpdateProfileRequest{Username: &lBobName}, CodeUsernameTaken},
+		{UpdateProfileRequest{Email: &lBobEmail}, CodeEmailTaken},
+	}
+	for _, lCase := range lCasesArr {
+		lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPatch, "/api/me", lAlice.Token, nil, lCase.req, nil)
+		if lResponse.StatusCode != http.StatusConflict || lAPIResponse.Code != lCase.code {
+			t.Errorf("take bob's %s: %d %s, want 409", lCase.code, lResponse.StatusCode, lAPIResponse.Code)
+		}
+	}
+
+	// Changing only the case of one's own name is not a conflict.
+	lNewName := "Alice"
+	var lUpdated User
+	lResponse, _ := callAPI(t, lServer, http.MethodPatch, "/api/me", lAlice.Token, nil, UpdateProfileRequest{Username: &lNewName}, &lUpdated)
+	if lResponse.StatusCode != http.StatusOK || lUpdated.Username != "Alice" || lUpdated.Email != "alice@example.com" {
+		t.Errorf("rename to Alice: %d %+v", lResponse.StatusCode, lUpdated)
+	}
+}
+
+func TestWrongCurrentPasswordRefused(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/me/password", lAlice.Token, nil, ChangePasswordRequest{CurrentPassword: "Wrong-horse-77", NewPassword: "Quiet-otter-42"}, nil)
+	if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeIncorrectPassword {
+		t.Errorf("change with wrong password: %d %s, want 403 %s", lResponse.StatusCode, lAPIResponse.Code, CodeIncorrectPassword)
+	}
+	lResponse, lAPIResponse = callAPI(t, lServer, http.MethodDelete, "/api/me", lAlice.Token, nil, DeleteAccountRequest{Password: "Wrong-horse-77"}, nil)
+	if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeIncorrectPassword {
+		t.Errorf("delete with wrong password: %d %s, want 403 %s", lResponse.StatusCode, lAPIResponse.Code, CodeIncorrectPassword)
+	}
+
+	// Neither went through.
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "alice", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("login with unchanged password: %d, want 200", lResponse.StatusCode)
+	}
+}
+
+func TestDeleteAccountCascades(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	lBob := signupTestUser(t, lServer, "bob")
+	_, lKey := createTestAPIKey(t, lServer, lAlice.Token, []string{ScopeTodosWrite})
+	callAPI(t, lServer, http.MethodPost, "/api/todos", lAlice.Token, nil, CreateTodoRequest{Title: "Buy milk"}, nil)
+	callAPI(t, lServer, http.MethodPost, "/api/todos", lBob.Token, nil, CreateTodoRequest{Title: "Walk the dog"}, nil)
+
+	lResponse, _ := callAPI(t, lServer, http.MethodDelete, "/api/me", lAlice.Token, nil, DeleteAccountRequest{Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("delete account: %d", lResponse.StatusCode)
+	}
+
+	for _, lToken := range []string{lAlice.Token, lKey} {
+		lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/todos", lToken, nil, nil, nil)
+		if lResponse.StatusCode != http.StatusUnauthorized {
+			t.Errorf("credential of deleted account: %d, want 401", lResponse.StatusCode)
+		}
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lAlice.RefreshToken}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh of deleted account: %d, want 401", lResponse.StatusCode)
+	}
+
+	lTodosArr, lErr := GetStore().Todos.ListTodos(lAlice.User.ID, TodoQuery{Limit: DefaultTodoLimit})
+	if lErr != nil || len(lTodosArr) != 0 {
+		t.Errorf("todos left = %+v, %v", lTodosArr, lErr)
+	}
+	lKeysArr, lErr := GetStore().APIKeys.ListAPIKeys(lAlice.User.ID)
+	if lErr != nil || len(lKeysArr) != 0 {
+		t.Errorf("API keys left = %+v, %v", lKeysArr, lErr)
+	}
+
+	// Other users keep their data, and the name is free again.
+	lTodosArr, lErr = GetStore().Todos.ListTodos(lBob.User.ID, TodoQuery{Limit: DefaultTodoLimit})
+	if lErr != nil || len(lTodosArr) != 1 {
+		t.Errorf("bob's todos = %+v, %v", lTodosArr, lErr)
+	}
+	signupTestUser(t, lServer, "alice")
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+
+	DefaultUserLimit = 50
+	MaxUserLimit     = 200
+)
+
+// ListUsersAPI serves GET /api/admin/users:
+//
+//	q=<text>               username or email substring, case-insensitive
+//	limit=<1..200>         page size (default 50)
+//	cursor=<next_cursor>   continue after a previous page
+func ListUsersAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ListUsersAPI(+)")
+
+	lSearch, lAfterID, lLimit, lErr := parseUserQuery(r.URL.Query())
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ListUsersAPI(-) error:", lErr)
+		return
+	}
+
+	lPage, lErr := ListUsers(lSearch, lAfterID, lLimit)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ListUsersAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Users retrieved successfully",
+		Data:    lPage,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ListUsersAPI(-)")
+}
+
+// DisableUserAPI serves POST /api/admin/users/{id}/disable. The user is
+// logged out everywhere and cannot log in again until re-enabled.
+func DisableUserAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("DisableUserAPI(+)")
+
+	lUserID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidUserID)
+		log.Println("DisableUserAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr := DisableUser(CurrentUser(r).ID, lUserID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DisableUserAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "User disabled",
+		Data:    lUser,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("DisableUserAPI(-)")
+}
+
+// EnableUserAPI serves POST /api/admin/users/{id}/enable.
+func EnableUserAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("EnableUserAPI(+)")
+
+	lUserID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidUserID)
+		log.Println("EnableUserAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr := EnableUser(lUserID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("EnableUserAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "User enabled",
+		Data:    lUser,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("EnableUserAPI(-)")
+}
+
+// ForcePasswordResetAPI serves POST /api/admin/users/{id}/password-reset.
+func ForcePasswordResetAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ForcePasswordResetAPI(+)")
+
+	lUserID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidUserID)
+		log.Println("ForcePasswordResetAPI(-) error:", lErr)
+		return
+	}
+
+	lErr = ForcePasswordReset(lUserID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ForcePasswordResetAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Password reset; the user has been emailed a link to choose a new one",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ForcePasswordResetAPI(-)")
+}
+
+// RevokeUserSessionsAPI serves DELETE /api/admin/users/{id}/sessions, which
+// logs the user out on every device.
+func RevokeUserSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("RevokeUserSessionsAPI(+)")
+
+	lUserID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidUserID)
+		log.Println("RevokeUserSessionsAPI(-) error:", lErr)
+		return
+	}
+
+	lRevoked, lErr := RevokeUserSessions(lUserID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("RevokeUserSessionsAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Sessions revoked",
+		Data:    map[string]int{"revoked": lRevoked},
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("RevokeUserSessionsAPI(-)")
+}
+
+func parseUserQuery(pValues url.Values) (string, int, int, error) {
+	lSearch := strings.TrimSpace(pValues.Get("q"))
+
+	lLimit := DefaultUserLimit
+	if lRaw := pValues.Get("limit"); lRaw != "" {
+		lParsed, lErr := strconv.Atoi(lRaw)
+		if lErr != nil || lParsed < 1 || lParsed > MaxUserLimit {
+			return "", 0, 0, invalidQueryParam("limit")
+		}
+		lLimit = lParsed
+	}
+
+	lAfterID := 0
+	if lRaw := pValues.Get("cursor"); lRaw != "" {
+		lParsed, lErr := strconv.Atoi(lRaw)
+		if lErr != nil || lParsed < 0 {
+			return "", 0, 0, invalidQueryParam("cursor")
+		}
+		lAfterID = lParsed
+	}
+
+	return lSearch, lAfterID, lLimit, nil
+}
+
+// ListUsers returns one page of users matching pSearch. NextCursor is only
+// set when the page is full, so more users may follow.
+func ListUsers(pSearch string, pAfterID int, pLimit int) (*UserPage, error) {
+	log.Println("ListUsers(+)")
+
+	lUsersArr, lErr := GetStore().Users.ListUsers(pSearch, pAfterID, pLimit)
+	if lErr != nil {
+		log.Println("ListUsers(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lPage := &UserPage{Users: lUsersArr}
+	if len(lUsersArr) == pLimit {
+		lPage.NextCursor = strconv.Itoa(lUsersArr[len(lUsersArr)-1].ID)
+	}
+
+	log.Println("ListUsers(-)")
+	return lPage, nil
+}
+
+// DisableUser disables pUserID and ends all of its sessions. Admins cannot
+// disable themselves, so that at least one admin can always log in.
+func DisableUser(pAdminID int, pUserID int) (*User, error) {
+	log.Println("DisableUser(+)")
+
+	if pAdminID == pUserID {
+		log.Println("DisableUser(-) error:", ErrCannotDisableSelf)
+		return nil, ErrCannotDisableSelf
+	}
+
+	lNow := time.Now()
+	lErr := GetStore().Users.SetUserDisabled(pUserID, &lNow)
+	if lErr != nil {
+		log.Println("DisableUser(-) error:", lErr)
+		return nil, adminUserError(lErr)
+	}
+
+	_, lErr = GetStore().Sessions.DeleteOtherSessions(pUserID, 0)
+	if lErr != nil {
+		log.Println("DisableUser(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lUser, lErr := getUserWithoutPassword(pUserID)
+	if lErr != nil {
+		log.Println("DisableUser(-) error:", lErr)
+		return nil, adminUserError(lErr)
+	}
+
+	log.Println("DisableUser(-)")
+	return lUser, nil
+}
+
+func EnableUser(pUserID int) (*User, error) {
+	log.Println("EnableUser(+)")
+
+	lErr := GetStore().Users.SetUserDisabled(pUserID, nil)
+	if lErr != nil {
+		log.Println("EnableUser(-) error:", lErr)
+		return nil, adminUserError(lErr)
+	}
+
+	lUser, lErr := getUserWithoutPassword(pUserID)
+	if lErr != nil {
+		log.Println("EnableUser(-) error:", lErr)
+		return nil, adminUserError(lErr)
+	}
+
+	log.Println("EnableUser(-)")
+	return lUser, nil
+}
+
+// ForcePasswordReset replaces pUserID's password with one nobody knows, ends
+// all of its sessions and mails the user a password reset link.
+func ForcePasswordReset(pUserID int) error {
+	log.Println("ForcePasswordReset(+)")
+
+	lUser, lErr := getUserWithoutPassword(pUserID)
+	if lErr != nil {
+		log.Println("ForcePasswordReset(-) error:", lErr)
+		return adminUserError(lErr)
+	}
+
+	lHashedPassword, lErr := RandomPasswordHash()
+	if lErr != nil {
+		log.Println("ForcePasswordReset(-) error:", lErr)
+		return lErr
+	}
+
+	lErr = GetStore().Users.UpdatePassword(pUserID, lHashedPassword)
+	if lErr != nil {
+		log.Println("ForcePasswordReset(-) error:", lErr)
+		return adminUserError(lErr)
+	}
+
+	_, lErr = GetStore().Sessions.DeleteOtherSessions(pUserID, 0)
+	if lErr != nil {
+		log.Println("ForcePasswordReset(-) error:", lErr)
+		return lErr
+	}
+
+	lErr = SendPasswordResetEmail(lUser)
+	if lErr != nil {
+		log.Println("ForcePasswordReset(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("ForcePasswordReset(-)")
+	return nil
+}
+
+func RevokeUserSessions(pUserID int) (int, error) {
+	log.Println("RevokeUserSessions(+)")
+
+	_, lErr := GetStore().Users.GetUserByID(pUserID)
+	if lErr != nil {
+		log.Println("RevokeUserSessions(-) error:", lErr)
+		return 0, adminUserError(lErr)
+	}
+
+	lRevoked, lErr := GetStore().Sessions.DeleteOtherSessions(pUserID, 0)
+	if lErr != nil {
+		log.Println("RevokeUserSessions(-) error:", lErr)
+		return 0, lErr
+	}
+
+	log.Println("RevokeUserSessions(-)")
+	return lRevoked, nil
+}
+
+// adminUserError reports a missing user as a 404 to the admin asking.
+func adminUserError(pErr error) error {
+	if pErr == ErrUserNotFound {
+		return ErrAdminUserNotFound
+	}
+	return pErr
+}
+
+// RunRoleCommand implements `backend promote <username|email>` and
+// `backend demote <username|email>`, which make a user an admin or a regular
+// user again. It is the only way to create the first admin.
+func RunRoleCommand(pCommand string, pArgsArr []string) error {
+	if len(pArgsArr) != 1 {
+		return fmt.Errorf("usage: %s <username|email>", pCommand)
+	}
+
+	lRole := RoleAdmin
+	if pCommand == "demote" {
+		lRole = RoleUser
+	}
+
+	var lUser *User
+	var lErr error
+	if strings.Contains(pArgsArr[0], "@") {
+		lUser, lErr = GetStore().Users.GetUserByEmail(pArgsArr[0])
+	} else {
+		lUser, lErr = GetStore().Users.GetUserByUsername(pArgsArr[0])
+	}
+	if lErr != nil {
+		return fmt.Errorf("%s %s: %w", pCommand, pArgsArr[0], lErr)
+	}
+
+	lErr = GetStore().Users.SetUserRole(lUser.ID, lRole)
+	if lErr != nil {
+		return fmt.Errorf("%s %s: %w", pCommand, pArgsArr[0], lErr)
+	}
+
+	fmt.Printf("%s is now %s\n", lUser.Username, lRole)
+	return nil
+}
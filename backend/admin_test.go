@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDisabledUserSessionRefused(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+
+	// Disable in the store only, so that the session itself is still there.
+	lNow := time.Now()
+	lErr := GetStore().Users.SetUserDisabled(lAlice.User.ID, &lNow)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodGet, "/api/todos", lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeAccountDisabled {
+		t.Errorf("session of disabled user: %d %s, want 403 %s", lResponse.StatusCode, lAPIResponse.Code, CodeAccountDisabled)
+	}
+	lResponse, lAPIResponse = callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lAlice.RefreshToken}, nil)
+	if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeAccountDisabled {
+		t.Errorf("refresh of disabled user: %d %s, want 403 %s", lResponse.StatusCode, lAPIResponse.Code, CodeAccountDisabled)
+	}
+
+	// Only the right password learns that the account is disabled.
+	lResponse, lAPIResponse = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "alice", Password: "Wrong-horse-77"}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized || lAPIResponse.Code != CodeInvalidCredentials {
+		t.Errorf("wrong password of disabled user: %d %s, want 401 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInvalidCredentials)
+	}
+	lResponse, lAPIResponse = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "alice", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeAccountDisabled {
+		t.Errorf("login of disabled user: %d %s, want 403 %s", lResponse.StatusCode, lAPIResponse.Code, CodeAccountDisabled)
+	}
+}
+
+func TestAdminDisableAndEnable(t *testing.T) {
+	lServer := newTestServer(t)
+	lAdmin := signupTestUser(t, lServer, "alice")
+	lBob := signupTestUser(t, lServer, "bob")
+	lErr := GetStore().Users.SetUserRole(lAdmin.User.ID, RoleAdmin)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	lBobPath := "/api/admin/users/" + strconv.Itoa(lBob.User.ID)
+
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, lBobPath+"/disable", lAdmin.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("disable bob: %d", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/todos", lBob.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("session of disabled bob: %d, want 401", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "bob", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusForbidden {
+		t.Errorf("login of disabled bob: %d, want 403", lResponse.StatusCode)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, lBobPath+"/enable", lAdmin.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("enable bob: %d", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "bob", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("login of re-enabled bob: %d, want 200", lResponse.StatusCode)
+	}
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/admin/users/"+strconv.Itoa(lAdmin.User.ID)+"/disable", lAdmin.Token, nil, nil, nil)
+	if lResponse.StatusCode == http.StatusOK {
+		t.Errorf("admin disabled themselves: %d %s", lResponse.StatusCode, lAPIResponse.Code)
+	}
+}
+
+func TestAdminRequired(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	lBob := signupTestUser(t, lServer, "bob")
+
+	lRequestsArr := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/admin/users"},
+		{http.MethodPost, "/api/admin/users/" + strconv.Itoa(lBob.User.ID) + "/disable"},
+		{http.MethodDelete, "/api/admin/users/" + strconv.Itoa(lBob.User.ID) + "/sessions"},
+	}
+	for _, lRequest := range lRequestsArr {
+		lResponse, lAPIResponse := callAPI(t, lServer, lRequest.method, lRequest.path, lAlice.Token, nil, nil, nil)
+		if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeAdminRequired {
+			t.Errorf("%s %s as non-admin: %d %s, want 403 %s", lRequest.method, lRequest.path, lResponse.StatusCode, lAPIResponse.Code, CodeAdminRequired)
+		}
+	}
+
+	// Nothing happened to bob.
+	lResponse, _ := callAPI(t, lServer, http.MethodGet, "/api/todos", lBob.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("bob after refused admin calls: %d, want 200", lResponse.StatusCode)
+	}
+}
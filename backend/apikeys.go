@@ -0,0 +1,220 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// APIKeyPrefix starts every API key, which tells them apart from session
+	// tokens and makes leaked keys easy to search for.
+	APIKeyPrefix = "tdk_"
+	// apiKeyDisplayLength is how much of a key is kept in APIKey.Prefix.
+	apiKeyDisplayLength = len(APIKeyPrefix) + 8
+
+	MaxAPIKeyNameLength = 100
+
+	ScopeTodosRead  = "todos:read"
+	ScopeTodosWrite = "todos:write"
+)
+
+var apiKeyScopesArr = []string{ScopeTodosRead, ScopeTodosWrite}
+
+// HasScope reports whether the key was granted pScope.
+func (pKey *APIKey) HasScope(pScope string) bool {
+	for _, lScope := range pKey.ScopesArr {
+		if lScope == pScope {
+			return true
+		}
+	}
+	return false
+}
+
+// ListAPIKeysAPI serves GET /api/auth/api-keys.
+func ListAPIKeysAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ListAPIKeysAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lKeysArr, lErr := GetStore().APIKeys.ListAPIKeys(lUser.ID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ListAPIKeysAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "API keys retrieved successfully",
+		Data:    lKeysArr,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ListAPIKeysAPI(-)")
+}
+
+// CreateAPIKeyAPI serves POST /api/auth/api-keys. The response is the only
+// time the key itself is shown.
+func CreateAPIKeyAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("CreateAPIKeyAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	var lReq CreateAPIKeyRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("CreateAPIKeyAPI(-) error:", lErr)
+		return
+	}
+
+	lKey, lSecret, lErr := CreateAPIKey(lUser.ID, lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("CreateAPIKeyAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "API key created; copy it now, it will not be shown again",
+		Data: map[string]interface{}{
+			"api_key": lKey,
+			"key":     lSecret,
+		},
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusCreated)
+	log.Println("CreateAPIKeyAPI(-)")
+}
+
+// DeleteAPIKeyAPI serves DELETE /api/auth/api-keys/{id}. The key stops
+// working immediately.
+func DeleteAPIKeyAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeleteAPIKeyAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lKeyID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidAPIKeyID)
+		log.Println("DeleteAPIKeyAPI(-) error:", lErr)
+		return
+	}
+
+	lErr = GetStore().APIKeys.DeleteAPIKey(lUser.ID, lKeyID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DeleteAPIKeyAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "API key deleted",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("DeleteAPIKeyAPI(-)")
+}
+
+// CreateAPIKey validates pReq and stores a new key, returning it together with
+// the secret key string.
+func CreateAPIKey(pUserID int, pReq CreateAPIKeyRequest) (*APIKey, string, error) {
+	log.Println("CreateAPIKey(+)")
+
+	lKey := APIKey{Name: strings.TrimSpace(pReq.Name), ExpiresAt: pReq.ExpiresAt}
+	lFieldsMap := map[string]string{}
+
+	switch {
+	case lKey.Name == "":
+		lFieldsMap["name"] = "Name is required"
+	case utf8.RuneCountInString(lKey.Name) > MaxAPIKeyNameLength:
+		lFieldsMap["name"] = "Name must be at most 100 characters"
+	}
+
+	lScopesArr, lMessage := normalizeAPIKeyScopes(pReq.ScopesArr)
+	if lMessage != "" {
+		lFieldsMap["scopes"] = lMessage
+	}
+	lKey.ScopesArr = lScopesArr
+
+	if lKey.ExpiresAt != nil && !lKey.ExpiresAt.After(time.Now()) {
+		lFieldsMap["expires_at"] = "Expiry must be in the future"
+	}
+
+	if len(lFieldsMap) > 0 {
+		log.Println("CreateAPIKey(-) error: invalid fields")
+		return nil, "", NewValidationError(lFieldsMap)
+	}
+
+	lToken, lErr := NewToken()
+	if lErr != nil {
+		log.Println("CreateAPIKey(-) error:", lErr)
+		return nil, "", lErr
+	}
+	lSecret := APIKeyPrefix + lToken
+	lKey.Prefix = lSecret[:apiKeyDisplayLength]
+
+	lCreated, lErr := GetStore().APIKeys.CreateAPIKey(pUserID, lKey, HashToken(lSecret))
+	if lErr != nil {
+		log.Println("CreateAPIKey(-) error:", lErr)
+		return nil, "", lErr
+	}
+
+	log.Println("CreateAPIKey(-)")
+	return lCreated, lSecret, nil
+}
+
+// normalizeAPIKeyScopes drops duplicates and checks that every scope is
+// known. todos:write implies todos:read, since writing is no use without
+// reading back what was written.
+func normalizeAPIKeyScopes(pScopesArr []string) ([]string, string) {
+	if len(pScopesArr) == 0 {
+		return nil, "At least one scope is required"
+	}
+
+	lRequestedMap := map[string]bool{}
+	for _, lScope := range pScopesArr {
+		lRequestedMap[lScope] = true
+	}
+	if lRequestedMap[ScopeTodosWrite] {
+		lRequestedMap[ScopeTodosRead] = true
+	}
+
+	lScopesArr := []string{}
+	for _, lScope := range apiKeyScopesArr {
+		if lRequestedMap[lScope] {
+			lScopesArr = append(lScopesArr, lScope)
+			delete(lRequestedMap, lScope)
+		}
+	}
+	for lScope := range lRequestedMap {
+		return nil, "Unknown scope " + lScope + "; use " + strings.Join(apiKeyScopesArr, " or ")
+	}
+	return lScopesArr, ""
+}
+
+// VerifyAPIKey returns the owner of an unexpired API key along with the key,
+// and records the use. Keys of disabled users are refused with
+// ErrAccountDisabled.
+func VerifyAPIKey(pKey string) (*User, *APIKey, error) {
+	log.Println("VerifyAPIKey(+)")
+
+	lUser, lKey, lErr := GetStore().APIKeys.TouchAPIKey(HashToken(pKey), time.Now())
+	if lErr != nil {
+		log.Println("VerifyAPIKey(-) error:", lErr)
+		return nil, nil, lErr
+	}
+	if lUser.DisabledAt != nil {
+		log.Println("VerifyAPIKey(-) error:", ErrAccountDisabled)
+		return nil, nil, ErrAccountDisabled
+	}
+
+	log.Println("VerifyAPIKey(-)")
+	return lUser, lKey, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// createTestAPIKey creates a key with pScopesArr for pToken's user and returns
+// it with its secret.
+func createTestAPIKey(t *testing.T, pServer *httptest.Server, pToken string, pScopesArr []string) (APIKey, string) {
+	t.Helper()
+
+	var lCreated struct {
+		APIKey APIKey `json:"api_key"`
+		Key    string `json:"key"`
+	}
+	lResponse, lAPIResponse := callAPI(t, pServer, http.MethodPost, "/api/auth/api-keys", pToken, nil, CreateAPIKeyRequest{Name: "script", ScopesArr: pScopesArr}, &lCreated)
+	if lResponse.StatusCode != http.StatusCreated || lCreated.Key == "" {
+		t.Fatalf("create API key: %d %s", lResponse.StatusCode, lAPIResponse.Message)
+	}
+	return lCreated.APIKey, lCreated.Key
+}
+
+func TestReadOnlyAPIKey(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	_, lKey := createTestAPIKey(t, lServer, lAlice.Token, []string{ScopeTodosRead})
+
+	lResponse, _ := callAPI(t, lServer, http.MethodGet, "/api/todos", lKey, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("list with read key: %d, want 200", lResponse.StatusCode)
+	}
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/todos", lKey, nil, CreateTodoRequest{Title: "Buy milk"}, nil)
+	if lResponse.StatusCode != http.StatusForbidden || lAPIResponse.Code != CodeInsufficientScope {
+		t.Errorf("create with read key: %d %s, want 403 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInsufficientScope)
+	}
+
+	// Keys only reach the todo API, not account management.
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/api-keys", lKey, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("list keys with a key: %d, want 401", lResponse.StatusCode)
+	}
+}
+
+func TestWriteAPIKeyUntilDeleted(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	lAPIKey, lKey := createTestAPIKey(t, lServer, lAlice.Token, []string{ScopeTodosWrite})
+
+	// Write implies read.
+	if !lAPIKey.HasScope(ScopeTodosRead) {
+		t.Errorf("scopes = %v, want read as well", lAPIKey.ScopesArr)
+	}
+
+	var lTodo Todo
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/todos", lKey, nil, CreateTodoRequest{Title: "Buy milk"}, &lTodo)
+	if lResponse.StatusCode != http.StatusOK || lTodo.UserID != lAlice.User.ID {
+		t.Errorf("create with write key: %d, owner %d", lResponse.StatusCode, lTodo.UserID)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodDelete, "/api/auth/api-keys/"+strconv.Itoa(lAPIKey.ID), lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("delete key: %d", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/todos", lKey, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("deleted key: %d, want 401", lResponse.StatusCode)
+	}
+}
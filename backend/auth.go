@@ -2,10 +2,13 @@ package main
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -14,30 +17,31 @@ import (
 func SignupAPI(w http.ResponseWriter, r *http.Request) {
 	log.Println("SignupAPI(+)")
 	
-	if r.Method != http.MethodPost {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("SignupAPI(-)")
+	var lReq SignupRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("SignupAPI(-) error:", lErr)
 		return
 	}
 	
-	var lReq SignupRequest
-	lErr := json.Unmarshal([]byte(ReadBody(r)), &lReq)
+	lErr = ValidateSignup(lReq)
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("SignupAPI(-) error:", lErr)
 		return
 	}
 	
 	lUser, lErr := Signup(lReq.Username, lReq.Email, lReq.Password)
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("SignupAPI(-) error:", lErr)
 		return
 	}
 	
-	lToken, lErr := CreateSession(lUser.ID)
+	lTokens, lErr := CreateSession(lUser.ID, RequestClient(r))
 	if lErr != nil {
-		SendErrorResponse(w, "Failed to create session", http.StatusInternalServerError)
+		SendErrorResponse(w, lErr)
 		log.Println("SignupAPI(-) error:", lErr)
 		return
 	}
@@ -45,10 +49,7 @@ func SignupAPI(w http.ResponseWriter, r *http.Request) {
 	lResponse := APIResponse{
 		Status:  "s",
 		Message: "Signup successful",
-		Data: map[string]interface{}{
-			"user":  lUser,
-			"token": lToken,
-		},
+		Data:    authData(lUser, lTokens),
 	}
 	
 	SendJSONResponse(w, lResponse, http.StatusOK)
@@ -58,30 +59,77 @@ func SignupAPI(w http.ResponseWriter, r *http.Request) {
 func LoginAPI(w http.ResponseWriter, r *http.Request) {
 	log.Println("LoginAPI(+)")
 	
-	if r.Method != http.MethodPost {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("LoginAPI(-)")
+	var lReq LoginRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginAPI(-) error:", lErr)
 		return
 	}
 	
-	var lReq LoginRequest
-	lErr := json.Unmarshal([]byte(ReadBody(r)), &lReq)
+	lIdentifier := lReq.LoginIdentifier()
+	lIP := ClientIP(r)
+	lThrottleKey, lErr := LoginThrottleKey(lIdentifier)
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("LoginAPI(-) error:", lErr)
 		return
 	}
+	lRetryAfter, lErr := LoginRetryAfter(lThrottleKey, lIP)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginAPI(-) error:", lErr)
+		return
+	}
+	if lRetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lRetryAfter.Seconds()))))
+		SendErrorResponse(w, ErrTooManyLoginAttempts)
+		log.Println("LoginAPI(-) error:", ErrTooManyLoginAttempts)
+		return
+	}
 	
-	lUser, lErr := Login(lReq.Username, lReq.Password)
+	lUser, lErr := Login(lIdentifier, lReq.Password)
+	if lErr == ErrInvalidCredentials {
+		lRecordErr := RecordLoginFailure(lThrottleKey, lIdentifier, lIP)
+		if lRecordErr != nil {
+			log.Println("LoginAPI record failure error:", lRecordErr)
+		}
+	}
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusUnauthorized)
+		SendErrorResponse(w, lErr)
 		log.Println("LoginAPI(-) error:", lErr)
 		return
 	}
 	
-	lToken, lErr := CreateSession(lUser.ID)
+	// With 2FA on, the password alone only earns a challenge. Failures are
+	// kept until the code is right as well, so guessing codes stays throttled.
+	if lUser.TwoFactorEnabledAt != nil {
+		lChallenge, lErr := StartLoginChallenge(lUser.ID)
+		if lErr != nil {
+			SendErrorResponse(w, lErr)
+			log.Println("LoginAPI(-) error:", lErr)
+			return
+		}
+	
+		lResponse := APIResponse{
+			Status:  "s",
+			Message: "Two-factor authentication code required",
+			Data:    lChallenge,
+		}
+	
+		SendJSONResponse(w, lResponse, http.StatusOK)
+		log.Println("LoginAPI(-) two-factor challenge issued")
+		return
+	}
+	
+	lErr = ClearLoginFailures(lThrottleKey)
+	if lErr != nil {
+		log.Println("LoginAPI clear failures error:", lErr)
+	}
+	
+	lTokens, lErr := CreateSession(lUser.ID, RequestClient(r))
 	if lErr != nil {
-		SendErrorResponse(w, "Failed to create session", http.StatusInternalServerError)
+		SendErrorResponse(w, lErr)
 		log.Println("LoginAPI(-) error:", lErr)
 		return
 	}
@@ -89,35 +137,54 @@ func LoginAPI(w http.ResponseWriter, r *http.Request) {
 	lResponse := APIResponse{
 		Status:  "s",
 		Message: "Login successful",
-		Data: map[string]interface{}{
-			"user":  lUser,
-			"token": lToken,
-		},
+		Data:    authData(lUser, lTokens),
 	}
 	
 	SendJSONResponse(w, lResponse, http.StatusOK)
 	log.Println("LoginAPI(-)")
 }
 
-func LogoutAPI(w http.ResponseWriter, r *http.Request) {
-	log.Println("LogoutAPI(+)")
+func RefreshAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("RefreshAPI(+)")
 	
-	if r.Method != http.MethodPost {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("LogoutAPI(-)")
+	var lReq RefreshRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("RefreshAPI(-) error:", lErr)
+		return
+	}
+	
+	lUser, lTokens, lErr := RefreshTokens(lReq.RefreshToken, RequestClient(r))
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("RefreshAPI(-) error:", lErr)
 		return
 	}
 	
-	lToken := r.Header.Get("Authorization")
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Token refreshed",
+		Data:    authData(lUser, lTokens),
+	}
+	
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("RefreshAPI(-)")
+}
+
+func LogoutAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("LogoutAPI(+)")
+	
+	lToken := ExtractToken(r)
 	if lToken == "" {
-		SendErrorResponse(w, "Missing authorization token", http.StatusUnauthorized)
+		SendErrorResponse(w, ErrMissingToken)
 		log.Println("LogoutAPI(-)")
 		return
 	}
 	
 	lErr := Logout(lToken)
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("LogoutAPI(-) error:", lErr)
 		return
 	}
@@ -135,25 +202,7 @@ func LogoutAPI(w http.ResponseWriter, r *http.Request) {
 func VerifyTokenAPI(w http.ResponseWriter, r *http.Request) {
 	log.Println("VerifyTokenAPI(+)")
 	
-	if r.Method != http.MethodGet {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("VerifyTokenAPI(-)")
-		return
-	}
-	
-	lToken := r.Header.Get("Authorization")
-	if lToken == "" {
-		SendErrorResponse(w, "Missing authorization token", http.StatusUnauthorized)
-		log.Println("VerifyTokenAPI(-)")
-		return
-	}
-	
-	lUser, lErr := VerifyToken(lToken)
-	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusUnauthorized)
-		log.Println("VerifyTokenAPI(-) error:", lErr)
-		return
-	}
+	lUser := CurrentUser(r)
 	
 	lResponse := APIResponse{
 		Status:  "s",
@@ -167,120 +216,250 @@ func VerifyTokenAPI(w http.ResponseWriter, r *http.Request) {
 
 func Signup(pUsername string, pEmail string, pPassword string) (*User, error) {
 	log.Println("Signup(+)")
-	
+
 	lHashedPassword, lErr := bcrypt.GenerateFromPassword([]byte(pPassword), bcrypt.DefaultCost)
 	if lErr != nil {
 		log.Println("Signup(-) error:", lErr)
 		return nil, lErr
 	}
-	
-	lQuery := "INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING id, username, email"
-	lDB := GetDB()
-	
-	var lUser User
-	lErr = lDB.QueryRow(lQuery, pUsername, pEmail, string(lHashedPassword)).Scan(&lUser.ID, &lUser.Username, &lUser.Email)
+
+	lUser, lErr := GetStore().Users.CreateUser(pUsername, pEmail, string(lHashedPassword))
 	if lErr != nil {
 		log.Println("Signup(-) error:", lErr)
 		return nil, lErr
 	}
-	
+
+	// The account works without verification, so the link is not waited for.
+	sendMailInBackground("Signup verification email", func() error {
+		return SendVerificationEmail(lUser)
+	})
+
 	log.Println("Signup(-)")
-	return &lUser, nil
+	return lUser, nil
 }
 
-func Login(pUsername string, pPassword string) (*User, error) {
+// Login checks a password for the account named by pIdentifier, which is
+// its email when it contains "@" (usernames cannot) and its username
+// otherwise; both match case-insensitively. Unknown accounts and wrong
+// passwords both return ErrInvalidCredentials, and both pay for a bcrypt
+// comparison, so neither the response nor its timing tells them apart.
+func Login(pIdentifier string, pPassword string) (*User, error) {
 	log.Println("Login(+)")
-	
-	lQuery := "SELECT id, username, email, password FROM users WHERE username = $1"
-	lDB := GetDB()
-	
-	var lUser User
-	lErr := lDB.QueryRow(lQuery, pUsername).Scan(&lUser.ID, &lUser.Username, &lUser.Email, &lUser.Password)
+
+	lUser, lErr := findLoginUser(pIdentifier)
+	if lErr == ErrUserNotFound {
+		bcrypt.CompareHashAndPassword(lDummyHash, []byte(pPassword))
+		log.Println("Login(-) error:", lErr)
+		return nil, ErrInvalidCredentials
+	}
 	if lErr != nil {
 		log.Println("Login(-) error:", lErr)
 		return nil, lErr
 	}
-	
+
 	lErr = bcrypt.CompareHashAndPassword([]byte(lUser.Password), []byte(pPassword))
+	if lErr == bcrypt.ErrMismatchedHashAndPassword {
+		log.Println("Login(-) error:", lErr)
+		return nil, ErrInvalidCredentials
+	}
 	if lErr != nil {
 		log.Println("Login(-) error:", lErr)
 		return nil, lErr
 	}
-	
+
+	// Only checked once the password is right, so that the response does not
+	// reveal which accounts are disabled.
+	if lUser.DisabledAt != nil {
+		log.Println("Login(-) error:", ErrAccountDisabled)
+		return nil, ErrAccountDisabled
+	}
+
 	lUser.Password = ""
 	log.Println("Login(-)")
-	return &lUser, nil
+	return lUser, nil
 }
 
-func CreateSession(pUserID int) (string, error) {
+// findLoginUser looks up the account a login identifier names, by email when
+// it contains "@" and by username otherwise.
+func findLoginUser(pIdentifier string) (*User, error) {
+	if strings.Contains(pIdentifier, "@") {
+		return GetStore().Users.GetUserByEmail(pIdentifier)
+	}
+	return GetStore().Users.GetUserByUsername(pIdentifier)
+}
+
+// CreateSession starts a new login for pUserID: a short-lived access token
+// (ACCESS_TOKEN_TTL, default 15m) and a refresh token (REFRESH_TOKEN_TTL,
+// default 30 days) that begin a new token family.
+func CreateSession(pUserID int, pClient SessionClient) (*AuthTokens, error) {
 	log.Println("CreateSession(+)")
-	
-	lTokenBytes := make([]byte, 32)
-	_, lErr := rand.Read(lTokenBytes)
+
+	lFamilyID, lErr := NewToken()
 	if lErr != nil {
 		log.Println("CreateSession(-) error:", lErr)
-		return "", lErr
+		return nil, lErr
 	}
-	
-	lToken := hex.EncodeToString(lTokenBytes)
-	lExpiresAt := time.Now().Add(24 * time.Hour)
-	
-	lQuery := "INSERT INTO sessions (user_id, token, expires_at) VALUES ($1, $2, $3)"
-	lDB := GetDB()
-	
-	_, lErr = lDB.Exec(lQuery, pUserID, lToken, lExpiresAt)
+
+	lRefreshToken, lErr := NewToken()
 	if lErr != nil {
 		log.Println("CreateSession(-) error:", lErr)
-		return "", lErr
+		return nil, lErr
 	}
-	
+
+	lRefreshExpiresAt := time.Now().Add(GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour))
+	lErr = GetStore().Sessions.CreateRefreshToken(pUserID, lFamilyID, HashToken(lRefreshToken), lRefreshExpiresAt)
+	if lErr != nil {
+		log.Println("CreateSession(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lTokens, lErr := createAccessToken(pUserID, lFamilyID, pClient)
+	if lErr != nil {
+		log.Println("CreateSession(-) error:", lErr)
+		return nil, lErr
+	}
+	lTokens.RefreshToken = lRefreshToken
+	lTokens.RefreshExpiresAt = lRefreshExpiresAt
+
 	log.Println("CreateSession(-)")
-	return lToken, nil
+	return lTokens, nil
+}
+
+// RefreshTokens exchanges a refresh token for a new access token and a new
+// refresh token in the same family. Each refresh token works once, and every
+// rotation pushes the refresh expiry out again, so an active user stays
+// logged in while an idle one is eventually logged out.
+func RefreshTokens(pRefreshToken string, pClient SessionClient) (*User, *AuthTokens, error) {
+	log.Println("RefreshTokens(+)")
+
+	if pRefreshToken == "" {
+		log.Println("RefreshTokens(-) error:", ErrInvalidRefreshToken)
+		return nil, nil, ErrInvalidRefreshToken
+	}
+
+	lNewRefreshToken, lErr := NewToken()
+	if lErr != nil {
+		log.Println("RefreshTokens(-) error:", lErr)
+		return nil, nil, lErr
+	}
+
+	lRefreshExpiresAt := time.Now().Add(GetEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour))
+	lOld, lErr := GetStore().Sessions.RotateRefreshToken(HashToken(pRefreshToken), HashToken(lNewRefreshToken), lRefreshExpiresAt)
+	if lErr != nil {
+		log.Println("RefreshTokens(-) error:", lErr)
+		switch lErr {
+		case ErrRefreshTokenNotFound:
+			return nil, nil, ErrInvalidRefreshToken
+		case ErrRefreshTokenReused:
+			log.Println("RefreshTokens: reuse detected, token family revoked")
+			return nil, nil, ErrRefreshFamilyRevoked
+		}
+		return nil, nil, lErr
+	}
+
+	lUser, lErr := GetStore().Users.GetUserByID(lOld.UserID)
+	if lErr != nil {
+		log.Println("RefreshTokens(-) error:", lErr)
+		if lErr == ErrUserNotFound {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		return nil, nil, lErr
+	}
+	lUser.Password = ""
+	if lUser.DisabledAt != nil {
+		log.Println("RefreshTokens(-) error:", ErrAccountDisabled)
+		return nil, nil, ErrAccountDisabled
+	}
+
+	lTokens, lErr := createAccessToken(lOld.UserID, lOld.FamilyID, pClient)
+	if lErr != nil {
+		log.Println("RefreshTokens(-) error:", lErr)
+		return nil, nil, lErr
+	}
+	lTokens.RefreshToken = lNewRefreshToken
+	lTokens.RefreshExpiresAt = lRefreshExpiresAt
+
+	log.Println("RefreshTokens(-)")
+	return lUser, lTokens, nil
+}
+
+func createAccessToken(pUserID int, pFamilyID string, pClient SessionClient) (*AuthTokens, error) {
+	lToken, lErr := NewToken()
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lExpiresAt := time.Now().Add(GetEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute))
+	lErr = GetStore().Sessions.CreateSession(pUserID, pFamilyID, HashToken(lToken), lExpiresAt, pClient)
+	if lErr != nil {
+		return nil, lErr
+	}
+	return &AuthTokens{Token: lToken, ExpiresAt: lExpiresAt}, nil
+}
+
+// authData is the Data of a successful signup, login or refresh response.
+func authData(pUser *User, pTokens *AuthTokens) map[string]interface{} {
+	return map[string]interface{}{
+		"user":               pUser,
+		"token":              pTokens.Token,
+		"expires_at":         pTokens.ExpiresAt.UTC(),
+		"refresh_token":      pTokens.RefreshToken,
+		"refresh_expires_at": pTokens.RefreshExpiresAt.UTC(),
+	}
+}
+
+// lDummyHash is compared against when a login names no existing user. It is
+// generated at startup, at the same cost as real hashes, so that even the
+// first such comparison takes as long as a real one.
+var lDummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// NewToken returns 32 random bytes, hex encoded.
+func NewToken() (string, error) {
+	lTokenBytes := make([]byte, 32)
+	_, lErr := rand.Read(lTokenBytes)
+	if lErr != nil {
+		return "", lErr
+	}
+	return hex.EncodeToString(lTokenBytes), nil
 }
 
-func VerifyToken(pToken string) (*User, error) {
+// HashToken returns the hex SHA-256 digest under which a token is stored, so
+// that a leaked sessions or refresh_tokens table holds no usable credentials.
+// A plain digest is enough because tokens are 256 random bits, not passwords.
+func HashToken(pToken string) string {
+	lSum := sha256.Sum256([]byte(pToken))
+	return hex.EncodeToString(lSum[:])
+}
+
+// VerifyToken resolves an access token to its user and session ID, recording
+// pClient as the session's latest use. Sessions of disabled users are refused
+// with ErrAccountDisabled.
+func VerifyToken(pToken string, pClient SessionClient) (*User, int, error) {
 	log.Println("VerifyToken(+)")
-	
-	lQuery := "SELECT s.user_id, u.id, u.username, u.email FROM sessions s JOIN users u ON s.user_id = u.id WHERE s.token = $1 AND s.expires_at > NOW()"
-	lDB := GetDB()
-	
-	var lUser User
-	lErr := lDB.QueryRow(lQuery, pToken).Scan(&lUser.ID, &lUser.ID, &lUser.Username, &lUser.Email)
+
+	lUser, lSessionID, lErr := GetStore().Sessions.TouchSession(HashToken(pToken), pClient)
 	if lErr != nil {
 		log.Println("VerifyToken(-) error:", lErr)
-		return nil, lErr
+		return nil, 0, lErr
 	}
-	
+	if lUser.DisabledAt != nil {
+		log.Println("VerifyToken(-) error:", ErrAccountDisabled)
+		return nil, 0, ErrAccountDisabled
+	}
+
 	log.Println("VerifyToken(-)")
-	return &lUser, nil
+	return lUser, lSessionID, nil
 }
 
 func Logout(pToken string) error {
 	log.Println("Logout(+)")
-	
-	lQuery := "DELETE FROM sessions WHERE token = $1"
-	lDB := GetDB()
-	
-	_, lErr := lDB.Exec(lQuery, pToken)
+
+	lErr := GetStore().Sessions.DeleteSession(HashToken(pToken))
 	if lErr != nil {
 		log.Println("Logout(-) error:", lErr)
 		return lErr
 	}
-	
+
 	log.Println("Logout(-)")
 	return nil
 }
-
-func GetUserFromToken(pToken string) (*User, error) {
-	log.Println("GetUserFromToken(+)")
-	
-	lUser, lErr := VerifyToken(pToken)
-	if lErr != nil {
-		log.Println("GetUserFromToken(-) error:", lErr)
-		return nil, lErr
-	}
-	
-	log.Println("GetUserFromToken(-)")
-	return lUser, nil
-}
-
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	lServer := newTestServer(t)
+	lAuth := signupTestUser(t, lServer, "alice")
+
+	var lRotated testAuth
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lAuth.RefreshToken}, &lRotated)
+	if lResponse.StatusCode != http.StatusOK || lRotated.RefreshToken == "" || lRotated.RefreshToken == lAuth.RefreshToken {
+		t.Fatalf("refresh: %d, new refresh token %q", lResponse.StatusCode, lRotated.RefreshToken)
+	}
+
+	// Presenting the spent token again means it leaked: the whole family goes.
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lAuth.RefreshToken}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized || lAPIResponse.Code != CodeRefreshTokenReused {
+		t.Errorf("reused refresh: %d %s, want 401 %s", lResponse.StatusCode, lAPIResponse.Code, CodeRefreshTokenReused)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lRotated.RefreshToken}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh with the rotated token after reuse: %d, want 401", lResponse.StatusCode)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/verify", lRotated.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("access token of the revoked family: %d, want 401", lResponse.StatusCode)
+	}
+}
+
+func TestRefreshTokenReuseKeepsOtherSessions(t *testing.T) {
+	lServer := newTestServer(t)
+	lFirst := signupTestUser(t, lServer, "bob")
+
+	var lSecond testAuth
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Username: "bob", Password: testPassword}, &lSecond)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("login: %d", lResponse.StatusCode)
+	}
+
+	callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lFirst.RefreshToken}, nil)
+	callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lFirst.RefreshToken}, nil)
+
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/verify", lSecond.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("session from another login: %d, want 200", lResponse.StatusCode)
+	}
+}
+
+func TestSignupDuplicates(t *testing.T) {
+	lServer := newTestServer(t)
+	signupTestUser(t, lServer, "alice")
+
+	lCasesArr := []struct {
+		req  SignupRequest
+		code string
+	}{
+		{SignupRequest{Username: "alice", Email: "other@example.com", Password: testPassword}, CodeUsernameTaken},
+		{SignupRequest{Username: "other", Email: "alice@example.com", Password: testPassword}, CodeEmailTaken},
+	}
+
+	for _, lCase := range lCasesArr {
+		lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/signup", "", nil, lCase.req, nil)
+		if lResponse.StatusCode != http.StatusConflict || lAPIResponse.Code != lCase.code {
+			t.Errorf("signup %s/%s: %d %s, want 409 %s", lCase.req.Username, lCase.req.Email, lResponse.StatusCode, lAPIResponse.Code, lCase.code)
+		}
+	}
+}
+
+func TestLoginFailuresLookAlike(t *testing.T) {
+	lServer := newTestServer(t)
+	signupTestUser(t, lServer, "alice")
+
+	lWrongPassword, lWrongPasswordResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Username: "alice", Password: "Wrong-horse-77"}, nil)
+	lUnknownUser, lUnknownUserResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Username: "mallory", Password: "Wrong-horse-77"}, nil)
+
+	if lWrongPassword.StatusCode != http.StatusUnauthorized || lWrongPasswordResponse.Code != CodeInvalidCredentials {
+		t.Errorf("wrong password: %d %s, want 401 %s", lWrongPassword.StatusCode, lWrongPasswordResponse.Code, CodeInvalidCredentials)
+	}
+	if lUnknownUser.StatusCode != lWrongPassword.StatusCode || !reflect.DeepEqual(lUnknownUserResponse, lWrongPasswordResponse) {
+		t.Errorf("unknown user got %d %+v, wrong password %d %+v", lUnknownUser.StatusCode, lUnknownUserResponse, lWrongPassword.StatusCode, lWrongPasswordResponse)
+	}
+}
+
+func TestLoginIdentifierIgnoresCase(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+
+	for _, lIdentifier := range []string{"alice", "ALICE", "alice@example.com", "Alice@Example.COM"} {
+		var lAuth testAuth
+		lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: lIdentifier, Password: testPassword}, &lAuth)
+		if lResponse.StatusCode != http.StatusOK || lAuth.User.ID != lAlice.User.ID {
+			t.Errorf("login as %q: %d, user %d", lIdentifier, lResponse.StatusCode, lAuth.User.ID)
+		}
+	}
+
+	// Older clients still send username.
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Username: "Alice", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("login with username field: %d, want 200", lResponse.StatusCode)
+	}
+}
+
+func TestSignupDuplicatesIgnoreCase(t *testing.T) {
+	lServer := newTestServer(t)
+	signupTestUser(t, lServer, "alice")
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/signup", "", nil, SignupRequest{Username: "Alice", Email: "alice2@example.com", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusConflict || lAPIResponse.Code != CodeUsernameTaken {
+		t.Errorf("signup Alice: %d %s, want 409 %s", lResponse.StatusCode, lAPIResponse.Code, CodeUsernameTaken)
+	}
+	lResponse, lAPIResponse = callAPI(t, lServer, http.MethodPost, "/api/auth/signup", "", nil, SignupRequest{Username: "alice2", Email: "ALICE@example.com", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusConflict || lAPIResponse.Code != CodeEmailTaken {
+		t.Errorf("signup ALICE@example.com: %d %s, want 409 %s", lResponse.StatusCode, lAPIResponse.Code, CodeEmailTaken)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvDuration reads a Go duration such as "30s" or "15m" from pName,
+// falling back to pDefault when it is unset or invalid.
+func GetEnvDuration(pName string, pDefault time.Duration) time.Duration {
+	lRaw := os.Getenv(pName)
+	if lRaw == "" {
+		return pDefault
+	}
+
+	lValue, lErr := time.ParseDuration(lRaw)
+	if lErr != nil || lValue <= 0 {
+		log.Printf("GetEnvDuration: invalid %s=%q, using %s", pName, lRaw, pDefault)
+		return pDefault
+	}
+	return lValue
+}
+
+// GetEnvInt reads a positive integer from pName, falling back to pDefault when
+// it is unset or invalid.
+func GetEnvInt(pName string, pDefault int) int {
+	lRaw := os.Getenv(pName)
+	if lRaw == "" {
+		return pDefault
+	}
+
+	lValue, lErr := strconv.Atoi(lRaw)
+	if lErr != nil || lValue <= 0 {
+		log.Printf("GetEnvInt: invalid %s=%q, using %d", pName, lRaw, pDefault)
+		return pDefault
+	}
+	return lValue
+}
+
+// GetEnvString reads pName, falling back to pDefault when it is unset.
+func GetEnvString(pName string, pDefault string) string {
+	lValue := os.Getenv(pName)
+	if lValue == "" {
+		return pDefault
+	}
+	return lValue
+}
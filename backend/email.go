@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// UserTokenEmailVerification is the user_tokens purpose of email
+// verification links.
+const UserTokenEmailVerification = "email_verification"
+
+// VerifyEmailAPI serves POST /api/auth/verify-email with the token from a
+// verification link. It needs no session, since the link may be opened on
+// another device.
+func VerifyEmailAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("VerifyEmailAPI(+)")
+
+	var lReq VerifyEmailRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("VerifyEmailAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr := VerifyEmail(lReq.Token)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("VerifyEmailAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Email verified",
+		Data:    lUser,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("VerifyEmailAPI(-)")
+}
+
+// ResendVerificationEmailAPI serves POST /api/auth/verify-email/resend for the
+// signed-in user. Earlier links stop working.
+func ResendVerificationEmailAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ResendVerificationEmailAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lErr := ResendVerificationEmail(lUser.ID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ResendVerificationEmailAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Verification email sent",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ResendVerificationEmailAPI(-)")
+}
+
+// SendVerificationEmail mails pUser a single-use link to APP_URL's
+// /verify-email page, valid for EMAIL_VERIFICATION_TTL (default 48h).
+func SendVerificationEmail(pUser *User) error {
+	log.Println("SendVerificationEmail(+)")
+
+	lToken, lErr := NewToken()
+	if lErr != nil {
+		log.Println("SendVerificationEmail(-) error:", lErr)
+		return lErr
+	}
+
+	lTTL := GetEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	lErr = GetStore().UserTokens.CreateUserToken(pUser.ID, UserTokenEmailVerification, HashToken(lToken), time.Now().Add(lTTL))
+	if lErr != nil {
+		log.Println("SendVerificationEmail(-) error:", lErr)
+		return lErr
+	}
+
+	lErr = GetMailer().SendMail(MailMessage{
+		To:      pUser.Email,
+		Subject: "Verify your email address",
+		Body: "Hi " + pUser.Username + ",\n\n" +
+			"Please confirm your email address by opening this link:\n\n" +
+			AppURL("/verify-email", lToken) + "\n\n" +
+			"The link expires in " + lTTL.String() + ". If you did not sign up, you can ignore this email.\n",
+	})
+	if lErr != nil {
+		log.Println("SendVerificationEmail(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("SendVerificationEmail(-)")
+	return nil
+}
+
+// VerifyEmail consumes a verification token and marks its user's email as
+// verified.
+func VerifyEmail(pToken string) (*User, error) {
+	log.Println("VerifyEmail(+)")
+
+	lUserID, lErr := GetStore().UserTokens.ConsumeUserToken(UserTokenEmailVerification, HashToken(pToken), time.Now())
+	if lErr == ErrUserTokenNotFound {
+		log.Println("VerifyEmail(-) error:", lErr)
+		return nil, ErrInvalidVerificationToken
+	}
+	if lErr != nil {
+		log.Println("VerifyEmail(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lErr = GetStore().Users.MarkEmailVerified(lUserID, time.Now())
+	if lErr != nil {
+		log.Println("VerifyEmail(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lUser, lErr := GetStore().Users.GetUserByID(lUserID)
+	if lErr != nil {
+		log.Println("VerifyEmail(-) error:", lErr)
+		return nil, lErr
+	}
+	lUser.Password = ""
+
+	log.Println("VerifyEmail(-)")
+	return lUser, nil
+}
+
+func ResendVerificationEmail(pUserID int) error {
+	log.Println("ResendVerificationEmail(+)")
+
+	lUser, lErr := GetStore().Users.GetUserByID(pUserID)
+	if lErr != nil {
+		log.Println("ResendVerificationEmail(-) error:", lErr)
+		return lErr
+	}
+	if lUser.EmailVerifiedAt != nil {
+		log.Println("ResendVerificationEmail(-) error:", ErrEmailAlreadyVerified)
+		return ErrEmailAlreadyVerified
+	}
+
+	lErr = SendVerificationEmail(lUser)
+	if lErr != nil {
+		log.Println("ResendVerificationEmail(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("ResendVerificationEmail(-)")
+	return nil
+}
+
+// AppURL links to pPath on the frontend at APP_URL, passing pToken as the
+// token query parameter.
+func AppURL(pPath string, pToken string) string {
+	lBase := GetEnvString("APP_URL", "http://localhost:8081")
+	return lBase + pPath + "?token=" + url.QueryEscape(pToken)
+}
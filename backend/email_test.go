@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// verifyTestEmail posts pToken to the verification endpoint.
+func verifyTestEmail(t *testing.T, pServer *httptest.Server, pToken string) (*http.Response, APIResponse, User) {
+	t.Helper()
+
+	var lUser User
+	lResponse, lAPIResponse := callAPI(t, pServer, http.MethodPost, "/api/auth/verify-email", "", nil, VerifyEmailRequest{Token: pToken}, &lUser)
+	return lResponse, lAPIResponse, lUser
+}
+
+func TestVerifyEmailTokenIsSingleUse(t *testing.T) {
+	lServer := newTestServer(t)
+	signupTestUser(t, lServer, "alice")
+	lToken := nextTestMail(t, "alice@example.com")
+
+	lResponse, _, lUser := verifyTestEmail(t, lServer, lToken)
+	if lResponse.StatusCode != http.StatusOK || lUser.EmailVerifiedAt == nil {
+		t.Fatalf("verify: %d, verified at %v", lResponse.StatusCode, lUser.EmailVerifiedAt)
+	}
+
+	lResponse, lAPIResponse, _ := verifyTestEmail(t, lServer, lToken)
+	if lResponse.StatusCode != http.StatusBadRequest || lAPIResponse.Code != CodeInvalidVerification {
+		t.Errorf("second use: %d %s, want 400 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInvalidVerification)
+	}
+}
+
+func TestVerifyEmailResendReplacesToken(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	lFirst := nextTestMail(t, "alice@example.com")
+
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/verify-email/resend", lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("resend: %d", lResponse.StatusCode)
+	}
+	lSecond := nextTestMail(t, "alice@example.com")
+
+	lResponse, _, _ = verifyTestEmail(t, lServer, lFirst)
+	if lResponse.StatusCode != http.StatusBadRequest {
+		t.Errorf("earlier link: %d, want 400", lResponse.StatusCode)
+	}
+	lResponse, _, _ = verifyTestEmail(t, lServer, lSecond)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("latest link: %d, want 200", lResponse.StatusCode)
+	}
+}
+
+func TestVerifyEmailTokenExpires(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	nextTestMail(t, "alice@example.com")
+
+	lErr := GetStore().UserTokens.CreateUserToken(lAlice.User.ID, UserTokenEmailVerification, HashToken("expired"), time.Now().Add(-time.Minute))
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	lResponse, lAPIResponse, _ := verifyTestEmail(t, lServer, "expired")
+	if lResponse.StatusCode != http.StatusBadRequest || lAPIResponse.Code != CodeInvalidVerification {
+		t.Errorf("expired token: %d %s, want 400 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInvalidVerification)
+	}
+}
+
+func TestNewMailerFromEnv(t *testing.T) {
+	lCasesArr := []struct {
+		env   map[string]string
+		valid bool
+	}{
+		// Unset falls back to the log.
+		{map[string]string{"MAILER": ""}, true},
+		{map[string]string{"MAILER": "log"}, true},
+		{map[string]string{"MAILER": "file"}, true},
+		{map[string]string{"MAILER": "smtp", "SMTP_HOST": "mail.example.com"}, true},
+		{map[string]string{"MAILER": "smtp", "SMTP_HOST": ""}, false},
+		{map[string]string{"MAILER": "sendgrid"}, false},
+	}
+
+	for _, lCase := range lCasesArr {
+		for lName, lValue := range lCase.env {
+			t.Setenv(lName, lValue)
+		}
+		lMailer, lErr := NewMailerFromEnv()
+		if lCase.valid && (lErr != nil || lMailer == nil) || !lCase.valid && lErr == nil {
+			t.Errorf("NewMailerFromEnv with %v = %T, %v", lCase.env, lMailer, lErr)
+		}
+	}
+
+	t.Setenv("MAILER", "")
+	if lMailer, _ := NewMailerFromEnv(); lMailer != (LogMailer{}) {
+		t.Errorf("unset MAILER gives %T, want LogMailer", lMailer)
+	}
+}
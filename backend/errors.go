@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Machine-readable error codes sent in APIResponse.Code. Clients should branch
+// on these rather than on the human-readable Message.
+const (
+	CodeBadRequest              = "bad_request"
+	CodeInvalidJSON             = "invalid_json"
+	CodeEmptyBody               = "empty_body"
+	CodeUnknownField            = "unknown_field"
+	CodeBodyTooLarge            = "body_too_large"
+	CodeUnsupportedMediaType    = "unsupported_media_type"
+	CodeNotFound                = "not_found"
+	CodeMethodNotAllowed        = "method_not_allowed"
+	CodeMissingToken            = "missing_token"
+	CodeInvalidToken            = "invalid_token"
+	CodeUsernameTaken           = "username_taken"
+	CodeEmailTaken              = "email_taken"
+	CodeValidationFailed        = "validation_failed"
+	CodeInvalidCredentials      = "invalid_credentials"
+	CodeInvalidVerification     = "invalid_verification_token"
+	CodeEmailAlreadyVerified    = "email_already_verified"
+	CodeInvalidResetToken       = "invalid_reset_token"
+	CodeInvalidChallenge        = "invalid_challenge_token"
+	CodeInvalidTwoFactorCode    = "invalid_two_factor_code"
+	CodeTwoFactorAlreadyEnabled = "two_factor_already_enabled"
+	CodeTwoFactorNotPending     = "two_factor_not_pending"
+	CodeTwoFactorNotEnabled     = "two_factor_not_enabled"
+	CodeOIDCProviderNotFound    = "oidc_provider_not_found"
+	CodeOIDCProviderUnavailable = "oidc_provider_unavailable"
+	CodeInvalidOIDCState        = "invalid_oidc_state"
+	CodeOIDCLoginFailed         = "oidc_login_failed"
+	CodeOIDCEmailRequired       = "oidc_email_required"
+	CodeTooManyAttempts         = "too_many_attempts"
+	CodeInsufficientScope       = "insufficient_scope"
+	CodeAccountDisabled         = "account_disabled"
+	CodeAdminRequired           = "admin_required"
+	CodeInvalidUserID           = "invalid_user_id"
+	CodeUserNotFound            = "user_not_found"
+	CodeCannotDisableSelf       = "cannot_disable_self"
+	CodeIncorrectPassword       = "incorrect_password"
+	CodeInvalidAPIKeyID         = "invalid_api_key_id"
+	CodeAPIKeyNotFound          = "api_key_not_found"
+	CodeInvalidRefreshToken     = "invalid_refresh_token"
+	CodeRefreshTokenReused      = "refresh_token_reused"
+	CodeInvalidSessionID        = "invalid_session_id"
+	CodeSessionNotFound         = "session_not_found"
+	CodeInvalidQuery            = "invalid_query"
+	CodeMissingSearchQuery      = "missing_search_query"
+	CodeInvalidTodoID           = "invalid_todo_id"
+	CodeTodoNotFound            = "todo_not_found"
+	CodeInvalidPrecondition     = "invalid_precondition"
+	CodePreconditionFailed      = "precondition_failed"
+	CodeInternal                = "internal_error"
+)
+
+// APIError is an error that knows how it should be reported to the client:
+// the HTTP status, the machine-readable code and the message. Validation
+// errors also carry a message per offending request field in Fields.
+type APIError struct {
+	HTTPStatus int
+	Code       string
+	Message    string
+	Fields     map[string]string
+}
+
+func (pErr *APIError) Error() string {
+	return pErr.Message
+}
+
+func NewAPIError(pHTTPStatus int, pCode string, pMessage string) *APIError {
+	return &APIError{HTTPStatus: pHTTPStatus, Code: pCode, Message: pMessage}
+}
+
+// NewValidationError reports the request fields in pFieldsMap, keyed by their
+// JSON name, as invalid.
+func NewValidationError(pFieldsMap map[string]string) *APIError {
+	return &APIError{
+		HTTPStatus: http.StatusUnprocessableEntity,
+		Code:       CodeValidationFailed,
+		Message:    "Some fields are invalid",
+		Fields:     pFieldsMap,
+	}
+}
+
+// NewAPIErrorf is NewAPIError with a formatted message.
+func NewAPIErrorf(pHTTPStatus int, pCode string, pFormat string, pArgs ...interface{}) *APIError {
+	return NewAPIError(pHTTPStatus, pCode, fmt.Sprintf(pFormat, pArgs...))
+}
+
+var (
+	ErrNotFound         = NewAPIError(http.StatusNotFound, CodeNotFound, "Not found")
+	ErrMethodNotAllowed = NewAPIError(http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+	ErrEmptyBody        = NewAPIError(http.StatusBadRequest, CodeEmptyBody, "Request body must not be empty")
+	ErrBodyTooLarge     = NewAPIError(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, "Request body is too large")
+	ErrMissingToken     = NewAPIError(http.StatusUnauthorized, CodeMissingToken, "Missing authorization token")
+	ErrInvalidToken     = NewAPIError(http.StatusUnauthorized, CodeInvalidToken, "Invalid token")
+	ErrInvalidTodoID    = NewAPIError(http.StatusBadRequest, CodeInvalidTodoID, "Invalid todo ID")
+	ErrTodoNotFound     = NewAPIError(http.StatusNotFound, CodeTodoNotFound, "Todo not found")
+
+	ErrPreconditionFailed = NewAPIError(http.StatusPreconditionFailed, CodePreconditionFailed, "Todo has been modified since it was last read")
+
+	ErrUsernameTaken            = NewAPIError(http.StatusConflict, CodeUsernameTaken, "Username is already taken")
+	ErrEmailTaken               = NewAPIError(http.StatusConflict, CodeEmailTaken, "Email is already registered")
+	ErrInvalidCredentials       = NewAPIError(http.StatusUnauthorized, CodeInvalidCredentials, "Invalid username, email or password")
+	ErrInvalidVerificationToken = NewAPIError(http.StatusBadRequest, CodeInvalidVerification, "Verification link is invalid or has expired")
+	ErrInvalidResetToken        = NewAPIError(http.StatusBadRequest, CodeInvalidResetToken, "Password reset link is invalid or has expired")
+	ErrEmailAlreadyVerified     = NewAPIError(http.StatusConflict, CodeEmailAlreadyVerified, "Email is already verified")
+	ErrInvalidChallenge         = NewAPIError(http.StatusUnauthorized, CodeInvalidChallenge, "Login challenge is invalid or has expired; please log in again")
+	ErrInvalidTwoFactorCode     = NewAPIError(http.StatusUnauthorized, CodeInvalidTwoFactorCode, "Invalid authentication code")
+	ErrTwoFactorAlreadyEnabled  = NewAPIError(http.StatusConflict, CodeTwoFactorAlreadyEnabled, "Two-factor authentication is already enabled")
+	ErrTwoFactorNotPending      = NewAPIError(http.StatusConflict, CodeTwoFactorNotPending, "Two-factor enrollment has not been started or was restarted")
+	ErrTwoFactorNotEnabled      = NewAPIError(http.StatusConflict, CodeTwoFactorNotEnabled, "Two-factor authentication is not enabled")
+	ErrOIDCProviderNotFound     = NewAPIError(http.StatusNotFound, CodeOIDCProviderNotFound, "Unknown login provider")
+	ErrOIDCProviderUnavailable  = NewAPIError(http.StatusBadGateway, CodeOIDCProviderUnavailable, "Login provider is unavailable; try again later")
+	ErrInvalidOIDCState         = NewAPIError(http.StatusBadRequest, CodeInvalidOIDCState, "Login attempt is invalid or has expired; please start again")
+	ErrOIDCLoginFailed          = NewAPIError(http.StatusUnauthorized, CodeOIDCLoginFailed, "Sign-in with the login provider failed")
+	ErrOIDCEmailRequired        = NewAPIError(http.StatusBadRequest, CodeOIDCEmailRequired, "The login provider did not share a valid email address")
+	ErrTooManyLoginAttempts     = NewAPIError(http.StatusTooManyRequests, CodeTooManyAttempts, "Too many failed login attempts; try again later")
+	ErrTooManyPasswordResets    = NewAPIError(http.StatusTooManyRequests, CodeTooManyAttempts, "Too many password reset requests; try again later")
+
+	ErrInvalidRefreshToken = NewAPIError(http.StatusUnauthorized, CodeInvalidRefreshToken, "Invalid or expired refresh token")
+	// ErrRefreshFamilyRevoked is sent when a refresh token is presented twice;
+	// every token of that login has been revoked and the user must log in again.
+	ErrRefreshFamilyRevoked = NewAPIError(http.StatusUnauthorized, CodeRefreshTokenReused, "Refresh token was already used; please log in again")
+	ErrInvalidSessionID     = NewAPIError(http.StatusBadRequest, CodeInvalidSessionID, "Invalid session ID")
+	ErrSessionNotFound      = NewAPIError(http.StatusNotFound, CodeSessionNotFound, "Session not found")
+	ErrInsufficientScope    = NewAPIError(http.StatusForbidden, CodeInsufficientScope, "API key does not have the scope this request needs")
+	ErrInvalidAPIKeyID      = NewAPIError(http.StatusBadRequest, CodeInvalidAPIKeyID, "Invalid API key ID")
+	ErrAPIKeyNotFound       = NewAPIError(http.StatusNotFound, CodeAPIKeyNotFound, "API key not found")
+	ErrAccountDisabled      = NewAPIError(http.StatusForbidden, CodeAccountDisabled, "This account has been disabled")
+	ErrAdminRequired        = NewAPIError(http.StatusForbidden, CodeAdminRequired, "Only administrators may do this")
+	ErrInvalidUserID        = NewAPIError(http.StatusBadRequest, CodeInvalidUserID, "Invalid user ID")
+	ErrAdminUserNotFound    = NewAPIError(http.StatusNotFound, CodeUserNotFound, "User not found")
+	ErrIncorrectPassword    = NewAPIError(http.StatusForbidden, CodeIncorrectPassword, "Current password is incorrect")
+	ErrCannotDisableSelf    = NewAPIError(http.StatusConflict, CodeCannotDisableSelf, "Administrators cannot disable their own account")
+
+	ErrInternal = NewAPIError(http.StatusInternalServerError, CodeInternal, "Internal server error")
+)
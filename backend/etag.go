@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// TodoETag is the strong entity tag for the current version of pTodo.
+func TodoETag(pTodo *Todo) string {
+	return `"` + strconv.Itoa(pTodo.Version) + `"`
+}
+
+// ParseIfMatch returns the todo versions listed in the If-Match header. It
+// returns nil, meaning "no precondition", when the header is absent or is
+// "*". Weak and malformed tags can never satisfy If-Match's strong comparison
+// and are dropped, so a header made only of those yields an empty, never
+// matching list.
+func ParseIfMatch(r *http.Request) []int {
+	lHeader := strings.TrimSpace(r.Header.Get("If-Match"))
+	if lHeader == "" || lHeader == "*" {
+		return nil
+	}
+
+	lVersionsArr := []int{}
+	for _, lTag := range strings.Split(lHeader, ",") {
+		lTag = strings.TrimSpace(lTag)
+		if len(lTag) < 2 || lTag[0] != '"' || lTag[len(lTag)-1] != '"' {
+			continue
+		}
+		lVersion, lErr := strconv.Atoi(lTag[1 : len(lTag)-1])
+		if lErr != nil {
+			continue
+		}
+		lVersionsArr = append(lVersionsArr, lVersion)
+	}
+	return lVersionsArr
+}
+
+// IfNoneMatchHit reports whether the If-None-Match header already names
+// pETag, in which case a GET can be answered with 304 Not Modified.
+func IfNoneMatchHit(r *http.Request, pETag string) bool {
+	lHeader := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if lHeader == "*" {
+		return true
+	}
+	for _, lTag := range strings.Split(lHeader, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(lTag), "W/") == pETag {
+			return true
+		}
+	}
+	return false
+}
+
+func versionMatches(pVersion int, pIfMatch []int) bool {
+	if pIfMatch == nil {
+		return true
+	}
+	for _, lVersion := range pIfMatch {
+		if lVersion == pVersion {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseIfMatch(t *testing.T) {
+	lCasesArr := []struct {
+		header      string
+		versionsArr []int
+	}{
+		{"", nil},
+		{"*", nil},
+		{` "3" `, []int{3}},
+		{`"3", "5"`, []int{3, 5}},
+		{`W/"3"`, []int{}},
+		{`"3", W/"4", "x", 5`, []int{3}},
+	}
+
+	for _, lCase := range lCasesArr {
+		lRequest := httptest.NewRequest(http.MethodPut, "/", nil)
+		if lCase.header != "" {
+			lRequest.Header.Set("If-Match", lCase.header)
+		}
+
+		lVersionsArr := ParseIfMatch(lRequest)
+		if !reflect.DeepEqual(lVersionsArr, lCase.versionsArr) {
+			t.Errorf("ParseIfMatch(%q) = %#v, want %#v", lCase.header, lVersionsArr, lCase.versionsArr)
+		}
+	}
+}
+
+func TestIfNoneMatchHit(t *testing.T) {
+	lETag := TodoETag(&Todo{Version: 4})
+
+	for lHeader, lWant := range map[string]bool{
+		`"4"`:      true,
+		`W/"4"`:    true,
+		`"3", "4"`: true,
+		`*`:        true,
+		`"3"`:      false,
+		``:         false,
+	} {
+		lRequest := httptest.NewRequest(http.MethodGet, "/", nil)
+		lRequest.Header.Set("If-None-Match", lHeader)
+		if IfNoneMatchHit(lRequest, lETag) != lWant {
+			t.Errorf("IfNoneMatchHit(%q) = %v, want %v", lHeader, !lWant, lWant)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// MaxBodyBytes caps the size of any JSON request body accepted by ReadBody.
+const MaxBodyBytes = 1 << 20
+
+// ReadBody decodes the JSON request body into pDest. The body must be sent as
+// application/json, fit within MaxBodyBytes, hold exactly one JSON value and
+// only use fields that pDest declares. Failures are returned as *APIError so
+// they can be passed straight to SendErrorResponse.
+func ReadBody(w http.ResponseWriter, r *http.Request, pDest interface{}) error {
+	lMediaType, _, lErr := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if lErr != nil || lMediaType != "application/json" {
+		return NewAPIError(http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "Content-Type must be application/json")
+	}
+
+	lDecoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	lDecoder.DisallowUnknownFields()
+
+	lErr = lDecoder.Decode(pDest)
+	if lErr != nil {
+		return decodeError(lErr)
+	}
+
+	lErr = lDecoder.Decode(&struct{}{})
+	if lErr != io.EOF {
+		return NewAPIError(http.StatusBadRequest, CodeInvalidJSON, "Request body must contain a single JSON object")
+	}
+	return nil
+}
+
+func decodeError(pErr error) *APIError {
+	var lSyntaxErr *json.SyntaxError
+	var lTypeErr *json.UnmarshalTypeError
+	var lMaxBytesErr *http.MaxBytesError
+
+	lField, lUnknown := unknownJSONField(pErr)
+	if lUnknown {
+		return NewAPIErrorf(http.StatusBadRequest, CodeUnknownField, "Unknown field %s", lField)
+	}
+
+	switch {
+	case errors.As(pErr, &lSyntaxErr):
+		return NewAPIErrorf(http.StatusBadRequest, CodeInvalidJSON, "Malformed JSON at position %d", lSyntaxErr.Offset)
+	case errors.Is(pErr, io.ErrUnexpectedEOF):
+		return NewAPIError(http.StatusBadRequest, CodeInvalidJSON, "Malformed JSON")
+	case errors.As(pErr, &lTypeErr):
+		return NewAPIErrorf(http.StatusBadRequest, CodeInvalidJSON, "Invalid value for field %q", lTypeErr.Field)
+	case errors.Is(pErr, io.EOF):
+		return ErrEmptyBody
+	case errors.As(pErr, &lMaxBytesErr):
+		return ErrBodyTooLarge
+	default:
+		return NewAPIError(http.StatusBadRequest, CodeInvalidJSON, pErr.Error())
+	}
+}
+
+// unknownFieldPrefix starts the error a json.Decoder returns under
+// DisallowUnknownFields when the input has a field the target lacks.
+// encoding/json has no typed error for this (golang/go#29035), so the message
+// text is the only thing to match on.
+const unknownFieldPrefix = "json: unknown field "
+
+// unknownJSONField reports whether pErr is encoding/json's unknown-field
+// error and, if so, returns the quoted field name it names.
+func unknownJSONField(pErr error) (string, bool) {
+	if !strings.HasPrefix(pErr.Error(), unknownFieldPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(pErr.Error(), unknownFieldPrefix), true
+}
+
+func SendJSONResponse(w http.ResponseWriter, pResponse interface{}, pStatus int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(pStatus)
+
+	lErr := json.NewEncoder(w).Encode(pResponse)
+	if lErr != nil {
+		log.Println("SendJSONResponse error:", lErr)
+	}
+}
+
+// SendErrorResponse reports pErr to the client. An *APIError supplies its own
+// status, code and message; anything else is logged and reported as a generic
+// 500 so that internal details such as SQL errors never reach the client.
+func SendErrorResponse(w http.ResponseWriter, pErr error) {
+	var lAPIErr *APIError
+	if !errors.As(pErr, &lAPIErr) {
+		log.Println("SendErrorResponse unexpected error:", pErr)
+		lAPIErr = ErrInternal
+	}
+
+	lResponse := APIResponse{
+		Status:  "e",
+		Code:    lAPIErr.Code,
+		Message: lAPIErr.Message,
+		Errors:  lAPIErr.Fields,
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, lAPIErr.HTTPStatus)
+}
+
+// maxUserAgentLength caps how much of the User-Agent header is kept with a
+// session.
+const maxUserAgentLength = 512
+
+// ClientIP returns the address the request came from. X-Forwarded-For is only
+// honoured when TRUST_PROXY=true, since any client can send it; behind a
+// proxy its first entry is the original client.
+func ClientIP(r *http.Request) string {
+	if os.Getenv("TRUST_PROXY") == "true" {
+		lForwarded, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		if lIP := net.ParseIP(strings.TrimSpace(lForwarded)); lIP != nil {
+			return lIP.String()
+		}
+	}
+
+	lHost, _, lErr := net.SplitHostPort(r.RemoteAddr)
+	if lErr != nil {
+		return r.RemoteAddr
+	}
+	return lHost
+}
+
+// RequestClient describes the device behind r for session bookkeeping.
+func RequestClient(r *http.Request) SessionClient {
+	lUserAgent := r.UserAgent()
+	if len(lUserAgent) > maxUserAgentLength {
+		lUserAgent = lUserAgent[:maxUserAgentLength]
+	}
+	return SessionClient{IP: ClientIP(r), UserAgent: lUserAgent}
+}
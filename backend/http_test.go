@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnknownJSONField(t *testing.T) {
+	lRecorder := httptest.NewRecorder()
+	lRequest := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"title":"a","colour":"red"}`))
+	lRequest.Header.Set("Content-Type", "application/json")
+
+	var lDest struct {
+		Title string `json:"title"`
+	}
+	lErr := ReadBody(lRecorder, lRequest, &lDest)
+
+	var lAPIErr *APIError
+	if !errors.As(lErr, &lAPIErr) {
+		t.Fatalf("ReadBody error = %v, want *APIError", lErr)
+	}
+	if lAPIErr.Code != CodeUnknownField || lAPIErr.Message != `Unknown field "colour"` {
+		t.Errorf("ReadBody error = %s %q, want %s %q", lAPIErr.Code, lAPIErr.Message, CodeUnknownField, `Unknown field "colour"`)
+	}
+
+	_, lUnknown := unknownJSONField(errors.New("json: cannot unmarshal string into Go value"))
+	if lUnknown {
+		t.Error("unknownJSONField matched an unrelated error")
+	}
+}
+
+func TestReadBodyErrors(t *testing.T) {
+	lCasesArr := []struct {
+		name        string
+		contentType string
+		body        string
+		code        string
+	}{
+		{"wrong content type", "text/plain", `{}`, CodeUnsupportedMediaType},
+		{"malformed", "application/json", `{"title":`, CodeInvalidJSON},
+		{"wrong type", "application/json", `{"title":1}`, CodeInvalidJSON},
+		{"two values", "application/json", `{}{}`, CodeInvalidJSON},
+		{"empty", "application/json", ``, ErrEmptyBody.Code},
+	}
+
+	for _, lCase := range lCasesArr {
+		t.Run(lCase.name, func(t *testing.T) {
+			lRequest := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(lCase.body))
+			lRequest.Header.Set("Content-Type", lCase.contentType)
+
+			var lDest struct {
+				Title string `json:"title"`
+			}
+			lErr := ReadBody(httptest.NewRecorder(), lRequest, &lDest)
+
+			var lAPIErr *APIError
+			if !errors.As(lErr, &lAPIErr) || lAPIErr.Code != lCase.code {
+				t.Errorf("ReadBody error = %v, want code %s", lErr, lCase.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// idTokenLeeway is how far the clocks of this server and an identity provider
+// may disagree when checking exp and iat.
+const idTokenLeeway = time.Minute
+
+// IDTokenClaims are the OpenID Connect ID token claims used for login.
+type IDTokenClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          jwtAudience `json:"aud"`
+	AuthorizedParty   string      `json:"azp"`
+	ExpiresAt         int64       `json:"exp"`
+	IssuedAt          int64       `json:"iat"`
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	EmailVerified     jwtBool     `json:"email_verified"`
+	PreferredUsername string      `json:"preferred_username"`
+}
+
+// jwtAudience accepts aud as either a single string or an array.
+type jwtAudience []string
+
+func (pAudience *jwtAudience) UnmarshalJSON(pData []byte) error {
+	var lSingle string
+	if json.Unmarshal(pData, &lSingle) == nil {
+		*pAudience = jwtAudience{lSingle}
+		return nil
+	}
+	var lMultipleArr []string
+	lErr := json.Unmarshal(pData, &lMultipleArr)
+	*pAudience = lMultipleArr
+	return lErr
+}
+
+func (pAudience jwtAudience) contains(pValue string) bool {
+	for _, lValue := range pAudience {
+		if lValue == pValue {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtBool accepts true/false as a JSON boolean or string; some providers send
+// email_verified as "true".
+type jwtBool bool
+
+func (pBool *jwtBool) UnmarshalJSON(pData []byte) error {
+	switch strings.Trim(string(pData), `"`) {
+	case "true":
+		*pBool = true
+	case "false", "null":
+		*pBool = false
+	default:
+		return fmt.Errorf("invalid boolean %s", pData)
+	}
+	return nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// jsonWebKey is a public key from a JWKS document (RFC 7517). Only RSA and
+// P-256 EC keys are understood.
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	KeysArr []jsonWebKey `json:"keys"`
+}
+
+// publicKey converts pKey for use with pAlgorithm, refusing keys meant for
+// something else.
+func (pKey jsonWebKey) publicKey(pAlgorithm string) (crypto.PublicKey, error) {
+	if pKey.Use != "" && pKey.Use != "sig" {
+		return nil, errors.New("key is not a signing key")
+	}
+	if pKey.Algorithm != "" && pKey.Algorithm != pAlgorithm {
+		return nil, fmt.Errorf("key is for %s, not %s", pKey.Algorithm, pAlgorithm)
+	}
+
+	switch {
+	case pAlgorithm == "RS256" && pKey.KeyType == "RSA":
+		lN, lErr := base64.RawURLEncoding.DecodeString(pKey.N)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lE, lErr := base64.RawURLEncoding.DecodeString(pKey.E)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lExponent := new(big.Int).SetBytes(lE)
+		if !lExponent.IsInt64() || lExponent.Int64() < 3 || lExponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(lN), E: int(lExponent.Int64())}, nil
+
+	case pAlgorithm == "ES256" && pKey.KeyType == "EC" && pKey.Curve == "P-256":
+		lX, lErr := base64.RawURLEncoding.DecodeString(pKey.X)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lY, lErr := base64.RawURLEncoding.DecodeString(pKey.Y)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(lX), Y: new(big.Int).SetBytes(lY)}
+		if !lKey.Curve.IsOnCurve(lKey.X, lKey.Y) {
+			return nil, errors.New("EC point is not on P-256")
+		}
+		return lKey, nil
+	}
+	return nil, fmt.Errorf("key type %s does not fit %s", pKey.KeyType, pAlgorithm)
+}
+
+// parseJWT splits a compact JWS and decodes its header, without verifying
+// anything.
+func parseJWT(pRaw string) (jwtHeader, []string, error) {
+	var lHeader jwtHeader
+
+	lPartsArr := strings.Split(pRaw, ".")
+	if len(lPartsArr) != 3 {
+		return lHeader, nil, errors.New("malformed JWT")
+	}
+
+	lHeaderJSON, lErr := base64.RawURLEncoding.DecodeString(lPartsArr[0])
+	if lErr != nil {
+		return lHeader, nil, lErr
+	}
+	lErr = json.Unmarshal(lHeaderJSON, &lHeader)
+	return lHeader, lPartsArr, lErr
+}
+
+// verifyJWTSignature checks the signature of a JWS split by parseJWT.
+// Only the asymmetric RS256 and ES256 are accepted; "none" and HMAC
+// algorithms never verify.
+func verifyJWTSignature(pAlgorithm string, pKey crypto.PublicKey, pPartsArr []string) error {
+	lSignature, lErr := base64.RawURLEncoding.DecodeString(pPartsArr[2])
+	if lErr != nil {
+		return lErr
+	}
+	lDigest := sha256.Sum256([]byte(pPartsArr[0] + "." + pPartsArr[1]))
+
+	switch lKey := pKey.(type) {
+	case *rsa.PublicKey:
+		if pAlgorithm != "RS256" {
+			break
+		}
+		return rsa.VerifyPKCS1v15(lKey, crypto.SHA256, lDigest[:], lSignature)
+	case *ecdsa.PublicKey:
+		// JWS carries an ES256 signature as r and s, 32 bytes each.
+		if pAlgorithm != "ES256" || len(lSignature) != 64 {
+			break
+		}
+		lR := new(big.Int).SetBytes(lSignature[:32])
+		lS := new(big.Int).SetBytes(lSignature[32:])
+		if ecdsa.Verify(lKey, lDigest[:], lR, lS) {
+			return nil
+		}
+		return errors.New("ecdsa: verification error")
+	}
+	return fmt.Errorf("unsupported signature algorithm %q", pAlgorithm)
+}
+
+// validateIDTokenClaims applies the OpenID Connect Core 3.1.3.7 checks that
+// remain once the signature is verified.
+func validateIDTokenClaims(pClaims *IDTokenClaims, pIssuer string, pClientID string, pNonce string, pNow time.Time) error {
+	switch {
+	case pClaims.Issuer != pIssuer:
+		return fmt.Errorf("issuer %q is not %q", pClaims.Issuer, pIssuer)
+	case !pClaims.Audience.contains(pClientID):
+		return errors.New("token is not for this client")
+	case len(pClaims.Audience) > 1 && pClaims.AuthorizedParty != pClientID:
+		return errors.New("token was not issued to this client")
+	case pClaims.Subject == "":
+		return errors.New("token has no subject")
+	case pClaims.ExpiresAt == 0 || pNow.After(time.Unix(pClaims.ExpiresAt, 0).Add(idTokenLeeway)):
+		return errors.New("token has expired")
+	case time.Unix(pClaims.IssuedAt, 0).After(pNow.Add(idTokenLeeway)):
+		return errors.New("token was issued in the future")
+	case subtle.ConstantTimeCompare([]byte(pClaims.Nonce), []byte(pNonce)) != 1:
+		return errors.New("nonce does not match")
+	}
+	return nil
+}
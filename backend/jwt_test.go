@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTestJWT builds a compact JWS over pPayload, signing it with pSign.
+func signTestJWT(t *testing.T, pAlgorithm string, pPayload string, pSign func(pDigest []byte) []byte) []string {
+	t.Helper()
+
+	lHeader := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + pAlgorithm + `","kid":"k1"}`))
+	lBody := base64.RawURLEncoding.EncodeToString([]byte(pPayload))
+	lDigest := sha256.Sum256([]byte(lHeader + "." + lBody))
+	lSignature := base64.RawURLEncoding.EncodeToString(pSign(lDigest[:]))
+
+	lParsedHeader, lPartsArr, lErr := parseJWT(lHeader + "." + lBody + "." + lSignature)
+	if lErr != nil {
+		t.Fatalf("parseJWT: %v", lErr)
+	}
+	if lParsedHeader.Algorithm != pAlgorithm || lParsedHeader.KeyID != "k1" {
+		t.Fatalf("parseJWT header = %+v", lParsedHeader)
+	}
+	return lPartsArr
+}
+
+func TestVerifyJWTSignature(t *testing.T) {
+	lRSAKey, lErr := rsa.GenerateKey(rand.Reader, 2048)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	lECKey, lErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	lRSAPartsArr := signTestJWT(t, "RS256", `{"sub":"1"}`, func(pDigest []byte) []byte {
+		lSignature, lErr := rsa.SignPKCS1v15(rand.Reader, lRSAKey, crypto.SHA256, pDigest)
+		if lErr != nil {
+			t.Fatal(lErr)
+		}
+		return lSignature
+	})
+	lECPartsArr := signTestJWT(t, "ES256", `{"sub":"1"}`, func(pDigest []byte) []byte {
+		lR, lS, lErr := ecdsa.Sign(rand.Reader, lECKey, pDigest)
+		if lErr != nil {
+			t.Fatal(lErr)
+		}
+		lSignature := make([]byte, 64)
+		lR.FillBytes(lSignature[:32])
+		lS.FillBytes(lSignature[32:])
+		return lSignature
+	})
+	lHMACPartsArr := signTestJWT(t, "HS256", `{"sub":"1"}`, func(pDigest []byte) []byte {
+		lMac := hmac.New(sha256.New, []byte("secret"))
+		lMac.Write(pDigest)
+		return lMac.Sum(nil)
+	})
+
+	lTamperedArr := append([]string{}, lRSAPartsArr...)
+	lTamperedArr[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"2"}`))
+
+	lCasesArr := []struct {
+		name      string
+		algorithm string
+		key       crypto.PublicKey
+		partsArr  []string
+		ok        bool
+	}{
+		{"RS256", "RS256", &lRSAKey.PublicKey, lRSAPartsArr, true},
+		{"ES256", "ES256", &lECKey.PublicKey, lECPartsArr, true},
+		{"tampered payload", "RS256", &lRSAKey.PublicKey, lTamperedArr, false},
+		{"algorithm does not fit key", "ES256", &lRSAKey.PublicKey, lRSAPartsArr, false},
+		{"HMAC", "HS256", &lRSAKey.PublicKey, lHMACPartsArr, false},
+		{"none", "none", &lRSAKey.PublicKey, []string{lRSAPartsArr[0], lRSAPartsArr[1], ""}, false},
+	}
+
+	for _, lCase := range lCasesArr {
+		t.Run(lCase.name, func(t *testing.T) {
+			lErr := verifyJWTSignature(lCase.algorithm, lCase.key, lCase.partsArr)
+			if (lErr == nil) != lCase.ok {
+				t.Errorf("verifyJWTSignature error = %v, want ok %v", lErr, lCase.ok)
+			}
+		})
+	}
+}
+
+func TestValidateIDTokenClaims(t *testing.T) {
+	lNow := time.Unix(1700000000, 0)
+	lValid := func() *IDTokenClaims {
+		return &IDTokenClaims{
+			Issuer:    "https://idp.example.com",
+			Subject:   "user-1",
+			Audience:  jwtAudience{"client"},
+			ExpiresAt: lNow.Add(5 * time.Minute).Unix(),
+			IssuedAt:  lNow.Unix(),
+			Nonce:     "nonce-1",
+		}
+	}
+
+	lCasesArr := []struct {
+		name   string
+		modify func(pClaims *IDTokenClaims)
+		errStr string
+	}{
+		{"valid", func(pClaims *IDTokenClaims) {}, ""},
+		{"expired within leeway", func(pClaims *IDTokenClaims) { pClaims.ExpiresAt = lNow.Add(-30 * time.Second).Unix() }, ""},
+		{"wrong issuer", func(pClaims *IDTokenClaims) { pClaims.Issuer = "https://evil.example.com" }, "issuer"},
+		{"wrong audience", func(pClaims *IDTokenClaims) { pClaims.Audience = jwtAudience{"other"} }, "not for this client"},
+		{"several audiences without azp", func(pClaims *IDTokenClaims) { pClaims.Audience = jwtAudience{"client", "other"} }, "not issued to this client"},
+		{"several audiences with azp", func(pClaims *IDTokenClaims) {
+			pClaims.Audience = jwtAudience{"client", "other"}
+			pClaims.AuthorizedParty = "client"
+		}, ""},
+		{"no subject", func(pClaims *IDTokenClaims) { pClaims.Subject = "" }, "no subject"},
+		{"expired", func(pClaims *IDTokenClaims) { pClaims.ExpiresAt = lNow.Add(-2 * time.Minute).Unix() }, "expired"},
+		{"no expiry", func(pClaims *IDTokenClaims) { pClaims.ExpiresAt = 0 }, "expired"},
+		{"issued in the future", func(pClaims *IDTokenClaims) { pClaims.IssuedAt = lNow.Add(2 * time.Minute).Unix() }, "future"},
+		{"wrong nonce", func(pClaims *IDTokenClaims) { pClaims.Nonce = "nonce-2" }, "nonce"},
+	}
+
+	for _, lCase := range lCasesArr {
+		t.Run(lCase.name, func(t *testing.T) {
+			lClaims := lValid()
+			lCase.modify(lClaims)
+
+			lErr := validateIDTokenClaims(lClaims, "https://idp.example.com", "client", "nonce-1", lNow)
+			if lCase.errStr == "" && lErr != nil {
+				t.Errorf("validateIDTokenClaims error = %v, want nil", lErr)
+			}
+			if lCase.errStr != "" && (lErr == nil || !strings.Contains(lErr.Error(), lCase.errStr)) {
+				t.Errorf("validateIDTokenClaims error = %v, want one mentioning %q", lErr, lCase.errStr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// MailMessage is one plain-text email.
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional email such as verification links.
+type Mailer interface {
+	SendMail(pMessage MailMessage) error
+}
+
+// LogMailer is the default Mailer. It only writes messages to the log, live
+// links included, so it is meant for local development.
+type LogMailer struct{}
+
+func (LogMailer) SendMail(pMessage MailMessage) error {
+	log.Printf("Mail to %s: %s\n%s", pMessage.To, pMessage.Subject, pMessage.Body)
+	return nil
+}
+
+// FileMailer writes every message to Dir as an .eml file, for local
+// development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (pMailer FileMailer) SendMail(pMessage MailMessage) error {
+	lErr := os.MkdirAll(pMailer.Dir, 0o755)
+	if lErr != nil {
+		return lErr
+	}
+
+	lName := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(pMailer.Dir, lName), formatMail(pMailer.From, pMessage), 0o644)
+}
+
+// SMTPMailer sends through an SMTP server, authenticating with PLAIN auth
+// when Username is set. net/smtp upgrades to TLS when the server offers
+// STARTTLS.
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (pMailer SMTPMailer) SendMail(pMessage MailMessage) error {
+	var lAuth smtp.Auth
+	if pMailer.Username != "" {
+		lAuth = smtp.PlainAuth("", pMailer.Username, pMailer.Password, pMailer.Host)
+	}
+
+	lAddr := net.JoinHostPort(pMailer.Host, pMailer.Port)
+	return smtp.SendMail(lAddr, lAuth, pMailer.From, []string{pMessage.To}, formatMail(pMailer.From, pMessage))
+}
+
+// headerSanitizer keeps user-influenced values from injecting headers.
+var headerSanitizer = strings.NewReplacer("\r", "", "\n", "")
+
+func formatMail(pFrom string, pMessage MailMessage) []byte {
+	var lBuilder strings.Builder
+	lBuilder.WriteString("From: " + headerSanitizer.Replace(pFrom) + "\r\n")
+	lBuilder.WriteString("To: " + headerSanitizer.Replace(pMessage.To) + "\r\n")
+	lBuilder.WriteString("Subject: " + headerSanitizer.Replace(pMessage.Subject) + "\r\n")
+	lBuilder.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	lBuilder.WriteString("MIME-Version: 1.0\r\n")
+	lBuilder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	lBuilder.WriteString(strings.ReplaceAll(pMessage.Body, "\n", "\r\n"))
+	return []byte(lBuilder.String())
+}
+
+// NewMailerFromEnv picks the Mailer named by MAILER:
+//
+//	smtp  SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD
+//	file  one .eml file per message in MAIL_DIR (default "mail")
+//	log   the default; messages, with their links, only go to the log
+//
+// MAIL_FROM sets the sender address. An unset MAILER falls back to the log
+// with a warning; an unknown or incomplete one is an error.
+func NewMailerFromEnv() (Mailer, error) {
+	lFrom := GetEnvString("MAIL_FROM", "no-reply@localhost")
+
+	switch lName := os.Getenv("MAILER"); lName {
+	case "smtp":
+		if os.Getenv("SMTP_HOST") == "" {
+			return nil, fmt.Errorf("MAILER=smtp requires SMTP_HOST")
+		}
+		return SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     GetEnvString("SMTP_PORT", "587"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     lFrom,
+		}, nil
+	case "file":
+		return FileMailer{Dir: GetEnvString("MAIL_DIR", "mail"), From: lFrom}, nil
+	case "log":
+		return LogMailer{}, nil
+	case "":
+		log.Println("NewMailerFromEnv: MAILER is not set; verification and reset links only go to the log, do not run like this in production")
+		return LogMailer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q; use smtp, file or log", lName)
+	}
+}
+
+// sendMailInBackground runs pSend in its own goroutine and logs its failure
+// under pWhat. Mail that a request only sends as a side effect goes out this
+// way, so that a slow or failing mail server neither delays nor fails the
+// request; the user can always ask for another link.
+func sendMailInBackground(pWhat string, pSend func() error) {
+	go func() {
+		lErr := pSend()
+		if lErr != nil {
+			log.Println(pWhat+" error:", lErr)
+		}
+	}()
+}
+
+var lMailer Mailer = LogMailer{}
+
+func SetMailer(pMailer Mailer) {
+	lMailer = pMailer
+}
+
+func GetMailer() Mailer {
+	return lMailer
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 )
 
 // enableCORS is a security gate that allows your Vercel frontend to talk to Railway.
@@ -11,8 +12,9 @@ import (
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Retry-After")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -23,19 +25,57 @@ func enableCORS(next http.Handler) http.Handler {
 }
 
 func main() {
-	// 1. Initialize the Database
-	InitDB()
-
-	// 2. Setup your Routes (Cursor logic)
-	http.HandleFunc("/api/auth/signup", SignupHandler)
-	http.HandleFunc("/api/auth/login", LoginHandler)
-	http.HandleFunc("/api/auth/logout", LogoutHandler)
-	http.HandleFunc("/api/auth/verify", VerifyHandler)
-	http.HandleFunc("/api/todos", TodoHandler)
-    // Add a health check so Railway knows the app is alive
-    http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Backend is running!"))
-	})
+	// 1. Initialize the Database, or keep everything in process memory
+	// with STORE=memory (local development and tests)
+	if os.Getenv("STORE") == "memory" {
+		SetStore(NewMemoryStore())
+	} else {
+		InitDB()
+
+		// `backend migrate up|down|status` manages the schema and exits
+		if len(os.Args) > 1 && os.Args[1] == "migrate" {
+			lErr := RunMigrateCommand(GetDB(), os.Args[2:])
+			if lErr != nil {
+				log.Fatal(lErr)
+			}
+			return
+		}
+
+		// Apply pending migrations unless a separate release step runs them
+		if os.Getenv("MIGRATE_ON_START") != "false" {
+			_, lErr := MigrateUp(GetDB())
+			if lErr != nil {
+				log.Fatal("Failed to migrate database:", lErr)
+			}
+		}
+
+		SetStore(NewPostgresStore(GetDB()))
+
+		// `backend promote|demote <username|email>` changes a user's role and exits
+		if len(os.Args) > 1 && (os.Args[1] == "promote" || os.Args[1] == "demote") {
+			lErr := RunRoleCommand(os.Args[1], os.Args[2:])
+			if lErr != nil {
+				log.Fatal(lErr)
+			}
+			return
+		}
+	}
+
+	lConfiguredMailer, lErr := NewMailerFromEnv()
+	if lErr != nil {
+		log.Fatal("Failed to configure mail:", lErr)
+	}
+	SetMailer(lConfiguredMailer)
+	SetOIDCProviders(LoadOIDCProvidersFromEnv())
+
+	// Deliver todo reminders in the background
+	StartReminderScheduler(LogNotifier{}, GetEnvDuration("REMINDER_INTERVAL", time.Minute), GetEnvInt("REMINDER_BATCH_SIZE", 100))
+
+	// Delete expired sessions and refresh tokens in the background
+	StartSessionReaper(GetEnvDuration("SESSION_REAP_INTERVAL", 10*time.Minute), GetEnvInt("SESSION_REAP_BATCH_SIZE", 1000))
+
+	// 2. Setup your Routes
+	lRouter := NewAPIRouter()
 
 	// 3. Get the Port from Railway
 	port := os.Getenv("PORT")
@@ -46,8 +86,56 @@ func main() {
 	log.Printf("Server starting on :%s", port)
 
 	// 4. Start Server with CORS enabled
-	err := http.ListenAndServe(":"+port, enableCORS(http.DefaultServeMux))
+	err := http.ListenAndServe(":"+port, enableCORS(lRouter))
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// NewAPIRouter registers every API route, so tests can serve the same API.
+func NewAPIRouter() *Router {
+	lRouter := NewRouter()
+	lRouter.Handle(http.MethodPost, "/api/auth/signup", SignupAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/login", LoginAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/refresh", RefreshAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/logout", LogoutAPI)
+	lRouter.Handle(http.MethodGet, "/api/auth/verify", RequireAuth(VerifyTokenAPI))
+	lRouter.Handle(http.MethodPost, "/api/auth/verify-email", VerifyEmailAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/verify-email/resend", RequireAuth(ResendVerificationEmailAPI))
+	lRouter.Handle(http.MethodPost, "/api/auth/forgot-password", ForgotPasswordAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/reset-password", ResetPasswordAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/login/2fa", LoginTwoFactorAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/2fa/enroll", RequireAuth(EnrollTwoFactorAPI))
+	lRouter.Handle(http.MethodPost, "/api/auth/2fa/confirm", RequireAuth(ConfirmTwoFactorAPI))
+	lRouter.Handle(http.MethodPost, "/api/auth/2fa/disable", RequireAuth(DisableTwoFactorAPI))
+	lRouter.Handle(http.MethodGet, "/api/auth/oidc/providers", ListOIDCProvidersAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/oidc/{provider}/start", StartOIDCLoginAPI)
+	lRouter.Handle(http.MethodPost, "/api/auth/oidc/callback", OIDCCallbackAPI)
+	lRouter.Handle(http.MethodGet, "/api/auth/api-keys", RequireAuth(ListAPIKeysAPI))
+	lRouter.Handle(http.MethodPost, "/api/auth/api-keys", RequireAuth(CreateAPIKeyAPI))
+	lRouter.Handle(http.MethodDelete, "/api/auth/api-keys/{id}", RequireAuth(DeleteAPIKeyAPI))
+	lRouter.Handle(http.MethodGet, "/api/auth/sessions", RequireAuth(ListSessionsAPI))
+	lRouter.Handle(http.MethodDelete, "/api/auth/sessions", RequireAuth(RevokeOtherSessionsAPI))
+	lRouter.Handle(http.MethodDelete, "/api/auth/sessions/{id}", RequireAuth(RevokeSessionAPI))
+	lRouter.Handle(http.MethodGet, "/api/me", RequireAuth(GetMeAPI))
+	lRouter.Handle(http.MethodPatch, "/api/me", RequireAuth(UpdateMeAPI))
+	lRouter.Handle(http.MethodDelete, "/api/me", RequireAuth(DeleteMeAPI))
+	lRouter.Handle(http.MethodPost, "/api/me/password", RequireAuth(ChangePasswordAPI))
+	lRouter.Handle(http.MethodGet, "/api/admin/users", RequireAdmin(ListUsersAPI))
+	lRouter.Handle(http.MethodPost, "/api/admin/users/{id}/disable", RequireAdmin(DisableUserAPI))
+	lRouter.Handle(http.MethodPost, "/api/admin/users/{id}/enable", RequireAdmin(EnableUserAPI))
+	lRouter.Handle(http.MethodPost, "/api/admin/users/{id}/password-reset", RequireAdmin(ForcePasswordResetAPI))
+	lRouter.Handle(http.MethodDelete, "/api/admin/users/{id}/sessions", RequireAdmin(RevokeUserSessionsAPI))
+	lRouter.Handle(http.MethodGet, "/api/todos", RequireScope(ScopeTodosRead, ListTodosAPI))
+	lRouter.Handle(http.MethodPost, "/api/todos", RequireScope(ScopeTodosWrite, CreateTodoAPI))
+	lRouter.Handle(http.MethodGet, "/api/todos/search", RequireScope(ScopeTodosRead, SearchTodosAPI))
+	lRouter.Handle(http.MethodGet, "/api/todos/{id}", RequireScope(ScopeTodosRead, GetTodoAPI))
+	lRouter.Handle(http.MethodPut, "/api/todos/{id}", RequireScope(ScopeTodosWrite, UpdateTodoAPI))
+	lRouter.Handle(http.MethodPatch, "/api/todos/{id}", RequireScope(ScopeTodosWrite, PatchTodoAPI))
+	lRouter.Handle(http.MethodDelete, "/api/todos/{id}", RequireScope(ScopeTodosWrite, DeleteTodoAPI))
+	// Add a health check so Railway knows the app is alive
+	lRouter.Handle(http.MethodGet, "/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Backend is running!"))
+	})
+	return lRouter
+}
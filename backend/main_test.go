@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testPassword is the password of every test account.
+const testPassword = "Zebra-horse-77"
+
+// testAuth is the Data of a signup, login or refresh response.
+type testAuth struct {
+	User         User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// testMailer passes every message sent to the test through Sent.
+type testMailer struct {
+	Sent chan MailMessage
+}
+
+func (pMailer testMailer) SendMail(pMessage MailMessage) error {
+	pMailer.Sent <- pMessage
+	return nil
+}
+
+// testMailTokenPattern finds the token of a link in a mail body.
+var testMailTokenPattern = regexp.MustCompile(`[?&]token=([0-9a-f]+)`)
+
+// newTestServer serves the whole API from a fresh memory store, with mail
+// going to a testMailer. Failed logins back off for a nanosecond so that tests
+// can retry straight away.
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	t.Setenv("LOGIN_BACKOFF_BASE", "1ns")
+	SetStore(NewMemoryStore())
+	SetMailer(testMailer{Sent: make(chan MailMessage, 100)})
+
+	lServer := httptest.NewServer(NewAPIRouter())
+	t.Cleanup(lServer.Close)
+	return lServer
+}
+
+// callAPI sends pBody as JSON, when given, and decodes the APIResponse. pData,
+// when given, receives the response's Data.
+func callAPI(t *testing.T, pServer *httptest.Server, pMethod string, pPath string, pToken string, pHeadersMap map[string]string, pBody interface{}, pData interface{}) (*http.Response, APIResponse) {
+	t.Helper()
+
+	var lBody bytes.Buffer
+	if pBody != nil {
+		lErr := json.NewEncoder(&lBody).Encode(pBody)
+		if lErr != nil {
+			t.Fatal(lErr)
+		}
+	}
+
+	lRequest, lErr := http.NewRequest(pMethod, pServer.URL+pPath, &lBody)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	if pBody != nil {
+		lRequest.Header.Set("Content-Type", "application/json")
+	}
+	if pToken != "" {
+		lRequest.Header.Set("Authorization", "Bearer "+pToken)
+	}
+	for lName, lValue := range pHeadersMap {
+		lRequest.Header.Set(lName, lValue)
+	}
+
+	lResponse, lErr := http.DefaultClient.Do(lRequest)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	defer lResponse.Body.Close()
+
+	var lDecoded struct {
+		APIResponse
+		Data json.RawMessage `json:"data"`
+	}
+	lErr = json.NewDecoder(lResponse.Body).Decode(&lDecoded)
+	if lErr != nil {
+		t.Fatalf("%s %s: decoding response: %v", pMethod, pPath, lErr)
+	}
+	if pData != nil && lDecoded.Status == "s" {
+		lErr = json.Unmarshal(lDecoded.Data, pData)
+		if lErr != nil {
+			t.Fatalf("%s %s: decoding data: %v", pMethod, pPath, lErr)
+		}
+	}
+	return lResponse, lDecoded.APIResponse
+}
+
+// signupTestUser creates pUsername with testPassword and returns its tokens.
+func signupTestUser(t *testing.T, pServer *httptest.Server, pUsername string) testAuth {
+	t.Helper()
+
+	var lAuth testAuth
+	lResponse, lAPIResponse := callAPI(t, pServer, http.MethodPost, "/api/auth/signup", "", nil, SignupRequest{
+		Username: pUsername,
+		Email:    pUsername + "@example.com",
+		Password: testPassword,
+	}, &lAuth)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("signup %s: %d %s", pUsername, lResponse.StatusCode, lAPIResponse.Message)
+	}
+	return lAuth
+}
+
+// nextTestMail waits for the next message sent to pTo and returns the token
+// of its link. Mail is sent in the background, so it may arrive after the
+// response.
+func nextTestMail(t *testing.T, pTo string) string {
+	t.Helper()
+
+	lTimeout := time.After(5 * time.Second)
+	for {
+		select {
+		case lMessage := <-GetMailer().(testMailer).Sent:
+			if lMessage.To != pTo {
+				continue
+			}
+			lMatchArr := testMailTokenPattern.FindStringSubmatch(lMessage.Body)
+			if lMatchArr == nil {
+				t.Fatalf("mail to %s has no link: %q", pTo, lMessage.Body)
+			}
+			return lMatchArr[1]
+		case <-lTimeout:
+			t.Fatalf("no mail to %s", pTo)
+			return ""
+		}
+	}
+}
+
+func TestAPIOnMemoryStore(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	lBob := signupTestUser(t, lServer, "bob")
+
+	var lTodo Todo
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/todos", lAlice.Token, nil, CreateTodoRequest{Title: "Buy milk"}, &lTodo)
+	if lResponse.StatusCode != http.StatusOK || lTodo.ID == 0 || lTodo.UserID != lAlice.User.ID {
+		t.Fatalf("create: %d %+v", lResponse.StatusCode, lTodo)
+	}
+	lPath := "/api/todos/" + strconv.Itoa(lTodo.ID)
+
+	var lFetched Todo
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, lPath, lAlice.Token, nil, nil, &lFetched)
+	if lResponse.StatusCode != http.StatusOK || lFetched.Title != "Buy milk" {
+		t.Errorf("get own todo: %d %q", lResponse.StatusCode, lFetched.Title)
+	}
+
+	// Another user's todos do not exist as far as bob is concerned.
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, lPath, lBob.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusNotFound {
+		t.Errorf("get someone else's todo: %d, want 404", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodDelete, lPath, lBob.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusNotFound {
+		t.Errorf("delete someone else's todo: %d, want 404", lResponse.StatusCode)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/logout", lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("logout: %d", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, lPath, lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("get after logout: %d, want 401", lResponse.StatusCode)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+)
+
+type userContextKey struct{}
+
+type sessionContextKey struct{}
+
+type apiKeyContextKey struct{}
+
+// ExtractToken returns the session token sent in the Authorization header.
+// Both the standard "Bearer <token>" scheme and a bare token are accepted.
+func ExtractToken(r *http.Request) string {
+	lHeader := strings.TrimSpace(r.Header.Get("Authorization"))
+
+	lScheme, lToken, lFound := strings.Cut(lHeader, " ")
+	if lFound && strings.EqualFold(lScheme, "Bearer") {
+		return strings.TrimSpace(lToken)
+	}
+	return lHeader
+}
+
+// RequireAuth resolves the session behind the request's token once and makes
+// the user and session available to pNext through CurrentUser and
+// CurrentSessionID. Requests without a valid session are rejected with 401
+// before pNext runs. API keys are not accepted here; routes that take them
+// use RequireScope.
+func RequireAuth(pNext http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lToken := ExtractToken(r)
+		if lToken == "" {
+			SendErrorResponse(w, ErrMissingToken)
+			return
+		}
+
+		lUser, lSessionID, lErr := VerifyToken(lToken, RequestClient(r))
+		if lErr != nil {
+			log.Println("RequireAuth error:", lErr)
+			SendErrorResponse(w, authError(lErr))
+			return
+		}
+
+		lCtx := context.WithValue(r.Context(), userContextKey{}, lUser)
+		lCtx = context.WithValue(lCtx, sessionContextKey{}, lSessionID)
+		pNext(w, r.WithContext(lCtx))
+	}
+}
+
+// RequireScope authenticates like RequireAuth but also accepts an API key,
+// provided the key was granted pScope. A session may do everything its user
+// may, so it passes any scope.
+func RequireScope(pScope string, pNext http.HandlerFunc) http.HandlerFunc {
+	lWithSession := RequireAuth(pNext)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		lToken := ExtractToken(r)
+		if !strings.HasPrefix(lToken, APIKeyPrefix) {
+			lWithSession(w, r)
+			return
+		}
+
+		lUser, lKey, lErr := VerifyAPIKey(lToken)
+		if lErr != nil {
+			log.Println("RequireScope error:", lErr)
+			SendErrorResponse(w, authError(lErr))
+			return
+		}
+		if !lKey.HasScope(pScope) {
+			log.Printf("RequireScope: API key %d lacks %s", lKey.ID, pScope)
+			SendErrorResponse(w, ErrInsufficientScope)
+			return
+		}
+
+		lCtx := context.WithValue(r.Context(), userContextKey{}, lUser)
+		lCtx = context.WithValue(lCtx, apiKeyContextKey{}, lKey)
+		pNext(w, r.WithContext(lCtx))
+	}
+}
+
+// RequireAdmin is RequireAuth for routes that only admins may use; other
+// users are refused with 403.
+func RequireAdmin(pNext http.HandlerFunc) http.HandlerFunc {
+	return RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		lUser := CurrentUser(r)
+		if lUser.Role != RoleAdmin {
+			log.Printf("RequireAdmin: user %d is not an admin", lUser.ID)
+			SendErrorResponse(w, ErrAdminRequired)
+			return
+		}
+		pNext(w, r)
+	})
+}
+
+// authError is the response to a token that did not authenticate. Disabled
+// accounts are told so; any other failure is an invalid token.
+func authError(pErr error) error {
+	if pErr == ErrAccountDisabled {
+		return pErr
+	}
+	return ErrInvalidToken
+}
+
+// CurrentUser returns the user attached by RequireAuth or RequireScope, or nil
+// when the handler is behind neither.
+func CurrentUser(r *http.Request) *User {
+	lUser, _ := r.Context().Value(userContextKey{}).(*User)
+	return lUser
+}
+
+// CurrentSessionID returns the ID of the session RequireAuth authenticated the
+// request with, or 0 when the handler is not behind RequireAuth.
+func CurrentSessionID(r *http.Request) int {
+	lSessionID, _ := r.Context().Value(sessionContextKey{}).(int)
+	return lSessionID
+}
+
+// CurrentAPIKey returns the API key RequireScope authenticated the request
+// with, or nil when it was made with a session.
+func CurrentAPIKey(r *http.Request) *APIKey {
+	lKey, _ := r.Context().Value(apiKeyContextKey{}).(*APIKey)
+	return lKey
+}
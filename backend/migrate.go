@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// migrationLockKey is the pg_advisory_lock key that serialises migrations, so
+// replicas starting together do not apply the same version twice.
+const migrationLockKey int64 = 0x746f646f01
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState is one row of `migrate status`.
+type MigrationState struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// MigrateUp applies every migration newer than the database's current version,
+// each in its own transaction, and returns how many were applied.
+func MigrateUp(pDB *sql.DB) (int, error) {
+	log.Println("MigrateUp(+)")
+
+	lApplied := 0
+	lErr := withMigrationLock(pDB, func(pConn *sql.Conn) error {
+		lAppliedMap, lErr := appliedMigrations(pConn)
+		if lErr != nil {
+			return lErr
+		}
+
+		for _, lMigration := range MigrationsArr {
+			if _, lOk := lAppliedMap[lMigration.Version]; lOk {
+				continue
+			}
+
+			log.Printf("MigrateUp applying %d_%s", lMigration.Version, lMigration.Name)
+			lErr = runMigration(pConn, lMigration.Up, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", lMigration.Version, lMigration.Name)
+			if lErr != nil {
+				return fmt.Errorf("migration %d_%s: %w", lMigration.Version, lMigration.Name, lErr)
+			}
+			lApplied++
+		}
+		return nil
+	})
+	if lErr != nil {
+		log.Println("MigrateUp(-) error:", lErr)
+		return lApplied, lErr
+	}
+
+	log.Println("MigrateUp(-)")
+	return lApplied, nil
+}
+
+// MigrateDown reverts the pSteps most recently applied migrations.
+func MigrateDown(pDB *sql.DB, pSteps int) (int, error) {
+	log.Println("MigrateDown(+)")
+
+	lReverted := 0
+	lErr := withMigrationLock(pDB, func(pConn *sql.Conn) error {
+		lAppliedMap, lErr := appliedMigrations(pConn)
+		if lErr != nil {
+			return lErr
+		}
+
+		for lIdx := len(MigrationsArr) - 1; lIdx >= 0 && lReverted < pSteps; lIdx-- {
+			lMigration := MigrationsArr[lIdx]
+			if _, lOk := lAppliedMap[lMigration.Version]; !lOk {
+				continue
+			}
+
+			log.Printf("MigrateDown reverting %d_%s", lMigration.Version, lMigration.Name)
+			lErr = runMigration(pConn, lMigration.Down, "DELETE FROM schema_migrations WHERE version = $1", lMigration.Version)
+			if lErr != nil {
+				return fmt.Errorf("migration %d_%s: %w", lMigration.Version, lMigration.Name, lErr)
+			}
+			lReverted++
+		}
+		return nil
+	})
+	if lErr != nil {
+		log.Println("MigrateDown(-) error:", lErr)
+		return lReverted, lErr
+	}
+
+	log.Println("MigrateDown(-)")
+	return lReverted, nil
+}
+
+// MigrationStatus lists every known migration and when it was applied, if at
+// all. It only reads schema_migrations and does not take the migration lock,
+// so it answers even while another replica is migrating.
+func MigrationStatus(pDB *sql.DB) ([]MigrationState, error) {
+	log.Println("MigrationStatus(+)")
+
+	var lExists bool
+	lErr := pDB.QueryRow("SELECT to_regclass('schema_migrations') IS NOT NULL").Scan(&lExists)
+	if lErr != nil {
+		log.Println("MigrationStatus(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lAppliedMap := map[int]time.Time{}
+	if lExists {
+		lAppliedMap, lErr = appliedMigrations(pDB)
+		if lErr != nil {
+			log.Println("MigrationStatus(-) error:", lErr)
+			return nil, lErr
+		}
+	}
+
+	var lStatesArr []MigrationState
+	for _, lMigration := range MigrationsArr {
+		lState := MigrationState{Version: lMigration.Version, Name: lMigration.Name}
+		if lAppliedAt, lOk := lAppliedMap[lMigration.Version]; lOk {
+			lState.AppliedAt = &lAppliedAt
+		}
+		lStatesArr = append(lStatesArr, lState)
+	}
+
+	log.Println("MigrationStatus(-)")
+	return lStatesArr, nil
+}
+
+// RunMigrateCommand implements `backend migrate up|down [steps]|status`.
+func RunMigrateCommand(pDB *sql.DB, pArgsArr []string) error {
+	if len(pArgsArr) == 0 {
+		return errors.New("usage: migrate up|down [steps]|status")
+	}
+
+	switch pArgsArr[0] {
+	case "up":
+		lApplied, lErr := MigrateUp(pDB)
+		if lErr != nil {
+			return lErr
+		}
+		fmt.Printf("applied %d migration(s)\n", lApplied)
+	case "down":
+		lSteps := 1
+		if len(pArgsArr) > 1 {
+			lParsed, lErr := strconv.Atoi(pArgsArr[1])
+			if lErr != nil || lParsed < 1 {
+				return fmt.Errorf("invalid step count %q", pArgsArr[1])
+			}
+			lSteps = lParsed
+		}
+		lReverted, lErr := MigrateDown(pDB, lSteps)
+		if lErr != nil {
+			return lErr
+		}
+		fmt.Printf("reverted %d migration(s)\n", lReverted)
+	case "status":
+		lStatesArr, lErr := MigrationStatus(pDB)
+		if lErr != nil {
+			return lErr
+		}
+		for _, lState := range lStatesArr {
+			lApplied := "pending"
+			if lState.AppliedAt != nil {
+				lApplied = "applied " + lState.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%4d  %-40s %s\n", lState.Version, lState.Name, lApplied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command %q", pArgsArr[0])
+	}
+	return nil
+}
+
+// withMigrationLock runs pFn on a single connection holding the migration
+// advisory lock. Session-level advisory locks belong to a connection, so the
+// lock, the bookkeeping table and every migration share pConn.
+func withMigrationLock(pDB *sql.DB, pFn func(pConn *sql.Conn) error) error {
+	lCtx := context.Background()
+
+	lConn, lErr := pDB.Conn(lCtx)
+	if lErr != nil {
+		return lErr
+	}
+	defer lConn.Close()
+
+	_, lErr = lConn.ExecContext(lCtx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+	if lErr != nil {
+		return lErr
+	}
+	defer func() {
+		_, lUnlockErr := lConn.ExecContext(lCtx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		if lUnlockErr != nil {
+			log.Println("withMigrationLock unlock error:", lUnlockErr)
+		}
+	}()
+
+	_, lErr = lConn.ExecContext(lCtx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if lErr != nil {
+		return lErr
+	}
+
+	return pFn(lConn)
+}
+
+// migrationQueryer is satisfied by both *sql.DB and *sql.Conn.
+type migrationQueryer interface {
+	QueryContext(pCtx context.Context, pQuery string, pArgsArr ...interface{}) (*sql.Rows, error)
+}
+
+func appliedMigrations(pConn migrationQueryer) (map[int]time.Time, error) {
+	lRows, lErr := pConn.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lAppliedMap := make(map[int]time.Time)
+	for lRows.Next() {
+		var lVersion int
+		var lAppliedAt time.Time
+		lErr = lRows.Scan(&lVersion, &lAppliedAt)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lAppliedMap[lVersion] = lAppliedAt
+	}
+	return lAppliedMap, lRows.Err()
+}
+
+// runMigration executes pSQL and the schema_migrations bookkeeping statement
+// in one transaction, so a failed migration leaves no trace.
+func runMigration(pConn *sql.Conn, pSQL string, pBookkeeping string, pArgsArr ...interface{}) error {
+	lCtx := context.Background()
+
+	lTx, lErr := pConn.BeginTx(lCtx, nil)
+	if lErr != nil {
+		return lErr
+	}
+	defer lTx.Rollback()
+
+	_, lErr = lTx.ExecContext(lCtx, pSQL)
+	if lErr != nil {
+		return lErr
+	}
+
+	_, lErr = lTx.ExecContext(lCtx, pBookkeeping, pArgsArr...)
+	if lErr != nil {
+		return lErr
+	}
+
+	return lTx.Commit()
+}
@@ -0,0 +1,306 @@
+package main
+
+// MigrationsArr is the ordered schema history. Append new migrations with the
+// next version number; never edit or renumber one that has shipped, since
+// deployed databases record applied versions in schema_migrations.
+var MigrationsArr = []Migration{
+	{
+		Version: 1,
+		Name:    "create_users_todos_sessions",
+		// IF NOT EXISTS keeps this a no-op on databases created by the old
+		// CreateTables bootstrap.
+		Up: `
+		CREATE TABLE IF NOT EXISTS users (
+			id SERIAL PRIMARY KEY,
+			username VARCHAR(50) UNIQUE NOT NULL,
+			email VARCHAR(100) UNIQUE NOT NULL,
+			password VARCHAR(255) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS todos (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			title VARCHAR(200) NOT NULL,
+			content TEXT,
+			completed BOOLEAN DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TABLE IF NOT EXISTS sessions (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			token VARCHAR(255) UNIQUE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		);`,
+		Down: `
+		DROP TABLE IF EXISTS sessions;
+		DROP TABLE IF EXISTS todos;
+		DROP TABLE IF EXISTS users;`,
+	},
+	{
+		Version: 2,
+		Name:    "add_todos_search_vector",
+		// Title matches outrank content matches (weight A vs B).
+		Up: `
+		ALTER TABLE todos ADD COLUMN search_vector tsvector
+			GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('english', coalesce(content, '')), 'B')
+			) STORED;
+
+		CREATE INDEX todos_search_vector_idx ON todos USING GIN (search_vector);`,
+		Down: `
+		DROP INDEX IF EXISTS todos_search_vector_idx;
+		ALTER TABLE todos DROP COLUMN IF EXISTS search_vector;`,
+	},
+	{
+		Version: 3,
+		Name:    "add_todos_due_and_reminders",
+		Up: `
+		ALTER TABLE todos
+			ADD COLUMN due_at TIMESTAMP,
+			ADD COLUMN remind_at TIMESTAMP,
+			ADD COLUMN reminder_fired_at TIMESTAMP;
+
+		CREATE INDEX todos_user_due_at_idx ON todos (user_id, due_at) WHERE due_at IS NOT NULL;
+		CREATE INDEX todos_pending_reminders_idx ON todos (remind_at) WHERE remind_at IS NOT NULL AND reminder_fired_at IS NULL;`,
+		Down: `
+		DROP INDEX IF EXISTS todos_pending_reminders_idx;
+		DROP INDEX IF EXISTS todos_user_due_at_idx;
+		ALTER TABLE todos
+			DROP COLUMN IF EXISTS reminder_fired_at,
+			DROP COLUMN IF EXISTS remind_at,
+			DROP COLUMN IF EXISTS due_at;`,
+	},
+	{
+		Version: 4,
+		Name:    "add_todos_version",
+		Up: `
+		ALTER TABLE todos ADD COLUMN version INTEGER NOT NULL DEFAULT 1;`,
+		Down: `
+		ALTER TABLE todos DROP COLUMN IF EXISTS version;`,
+	},
+	{
+		Version: 5,
+		Name:    "add_refresh_tokens",
+		// A family is one login: the access sessions and the chain of rotated
+		// refresh tokens that descend from it.
+		Up: `
+		ALTER TABLE sessions ADD COLUMN family_id VARCHAR(64);
+		CREATE INDEX sessions_family_id_idx ON sessions (family_id);
+
+		CREATE TABLE refresh_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			family_id VARCHAR(64) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		);
+		CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);`,
+		Down: `
+		DROP TABLE IF EXISTS refresh_tokens;
+		DROP INDEX IF EXISTS sessions_family_id_idx;
+		ALTER TABLE sessions DROP COLUMN IF EXISTS family_id;`,
+	},
+	{
+		Version: 6,
+		Name:    "hash_session_tokens",
+		// Existing sessions keep working: their plaintext token is replaced by
+		// its digest, which is what clients' tokens now hash to. Going back
+		// down cannot recover the plaintext, so it ends every session.
+		Up: `
+		ALTER TABLE sessions ADD COLUMN token_hash VARCHAR(64);
+		UPDATE sessions SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+		ALTER TABLE sessions ALTER COLUMN token_hash SET NOT NULL;
+		ALTER TABLE sessions ADD CONSTRAINT sessions_token_hash_key UNIQUE (token_hash);
+		ALTER TABLE sessions DROP COLUMN token;`,
+		Down: `
+		DELETE FROM sessions;
+		ALTER TABLE sessions ADD COLUMN token VARCHAR(255) UNIQUE NOT NULL;
+		ALTER TABLE sessions DROP COLUMN token_hash;`,
+	},
+	{
+		Version: 7,
+		Name:    "add_session_devices",
+		// A login keeps one sessions row for its whole family; refreshing
+		// swaps the token on that row, so its id identifies the device.
+		Up: `
+		ALTER TABLE sessions ADD COLUMN ip VARCHAR(45);
+		ALTER TABLE sessions ADD COLUMN user_agent TEXT;
+		ALTER TABLE sessions ADD COLUMN last_seen_at TIMESTAMP;
+		DROP INDEX IF EXISTS sessions_family_id_idx;
+		CREATE UNIQUE INDEX sessions_family_id_key ON sessions (family_id);
+		CREATE INDEX sessions_user_id_idx ON sessions (user_id);`,
+		Down: `
+		DROP INDEX IF EXISTS sessions_user_id_idx;
+		DROP INDEX IF EXISTS sessions_family_id_key;
+		CREATE INDEX sessions_family_id_idx ON sessions (family_id);
+		ALTER TABLE sessions DROP COLUMN IF EXISTS last_seen_at;
+		ALTER TABLE sessions DROP COLUMN IF EXISTS user_agent;
+		ALTER TABLE sessions DROP COLUMN IF EXISTS ip;`,
+	},
+	{
+		Version: 8,
+		Name:    "index_token_expiry",
+		// Lets the session reaper find expired rows without a full scan.
+		Up: `
+		CREATE INDEX sessions_expires_at_idx ON sessions (expires_at);
+		CREATE INDEX refresh_tokens_expires_at_idx ON refresh_tokens (expires_at);`,
+		Down: `
+		DROP INDEX IF EXISTS refresh_tokens_expires_at_idx;
+		DROP INDEX IF EXISTS sessions_expires_at_idx;`,
+	},
+	{
+		Version: 9,
+		Name:    "create_login_attempts",
+		// login_attempts is an append-only log of failed logins kept for
+		// review; login_throttles holds the rate limiter's live state per
+		// key, such as "user:alice" or "ip:203.0.113.7".
+		Up: `
+		CREATE TABLE login_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			username VARCHAR(255) NOT NULL,
+			ip VARCHAR(45) NOT NULL,
+			attempted_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX login_attempts_username_idx ON login_attempts (username, attempted_at);
+		CREATE INDEX login_attempts_ip_idx ON login_attempts (ip, attempted_at);
+
+		CREATE TABLE login_throttles (
+			key VARCHAR(300) PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			last_failure_at TIMESTAMP NOT NULL,
+			blocked_until TIMESTAMP
+		);`,
+		Down: `
+		DROP TABLE IF EXISTS login_throttles;
+		DROP TABLE IF EXISTS login_attempts;`,
+	},
+	{
+		Version: 10,
+		Name:    "case_insensitive_user_identity",
+		// Fails if existing accounts differ only in case; those have to be
+		// merged or renamed by hand before upgrading.
+		Up: `
+		CREATE UNIQUE INDEX users_username_lower_key ON users (lower(username));
+		CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));`,
+		Down: `
+		DROP INDEX IF EXISTS users_email_lower_key;
+		DROP INDEX IF EXISTS users_username_lower_key;`,
+	},
+	{
+		Version: 11,
+		Name:    "add_email_verification",
+		// user_tokens holds single-use tokens mailed to a user, such as email
+		// verification links; purpose says what a token may be used for.
+		Up: `
+		ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+		CREATE TABLE user_tokens (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			purpose VARCHAR(32) NOT NULL,
+			token_hash VARCHAR(64) UNIQUE NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL,
+			used_at TIMESTAMP
+		);
+		CREATE INDEX user_tokens_user_id_purpose_idx ON user_tokens (user_id, purpose);`,
+		Down: `
+		DROP TABLE IF EXISTS user_tokens;
+		ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;`,
+	},
+	{
+		Version: 12,
+		Name:    "add_two_factor_auth",
+		// totp_secret is set when enrollment starts and only takes effect once
+		// totp_enabled_at is set. totp_last_step is the time step of the last
+		// accepted code, so that a code cannot be replayed.
+		Up: `
+		ALTER TABLE users ADD COLUMN totp_secret VARCHAR(64);
+		ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMP;
+		ALTER TABLE users ADD COLUMN totp_last_step BIGINT;
+
+		CREATE TABLE recovery_codes (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			code_hash VARCHAR(64) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			used_at TIMESTAMP,
+			UNIQUE (user_id, code_hash)
+		);`,
+		Down: `
+		DROP TABLE IF EXISTS recovery_codes;
+		ALTER TABLE users DROP COLUMN IF EXISTS totp_last_step;
+		ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled_at;
+		ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;`,
+	},
+	{
+		Version: 13,
+		Name:    "add_oidc_login",
+		// user_identities links an account at an OpenID Connect provider to a
+		// local user. oidc_states holds the state, nonce and PKCE verifier of
+		// logins that were started but have not come back yet.
+		Up: `
+		CREATE TABLE user_identities (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider VARCHAR(64) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			email VARCHAR(100),
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (provider, subject)
+		);
+		CREATE INDEX user_identities_user_id_idx ON user_identities (user_id);
+
+		CREATE TABLE oidc_states (
+			state_hash VARCHAR(64) PRIMARY KEY,
+			provider VARCHAR(64) NOT NULL,
+			nonce VARCHAR(64) NOT NULL,
+			code_verifier VARCHAR(128) NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP NOT NULL
+		);
+		CREATE INDEX oidc_states_expires_at_idx ON oidc_states (expires_at);`,
+		Down: `
+		DROP TABLE IF EXISTS oidc_states;
+		DROP TABLE IF EXISTS user_identities;`,
+	},
+	{
+		Version: 14,
+		Name:    "create_api_keys",
+		// prefix keeps the first characters of the key so that users can tell
+		// their keys apart; the key itself is only stored as key_hash.
+		Up: `
+		CREATE TABLE api_keys (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name VARCHAR(100) NOT NULL,
+			prefix VARCHAR(16) NOT NULL,
+			key_hash VARCHAR(64) UNIQUE NOT NULL,
+			scopes TEXT[] NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP
+		);
+		CREATE INDEX api_keys_user_id_idx ON api_keys (user_id);`,
+		Down: `
+		DROP TABLE IF EXISTS api_keys;`,
+	},
+	{
+		Version: 15,
+		Name:    "add_user_roles",
+		// disabled_at is set while an admin has disabled the account.
+		Up: `
+		ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'admin'));
+		ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;`,
+		Down: `
+		ALTER TABLE users DROP COLUMN IF EXISTS disabled_at;
+		ALTER TABLE users DROP COLUMN IF EXISTS role;`,
+	},
+}
@@ -1,25 +1,93 @@
 package main
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"-"`
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Password        string     `json:"-"`
+	// TwoFactorEnabledAt is set while TOTP two-factor authentication is on.
+	// TOTPSecret may also be set during an enrollment that is not confirmed.
+	TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"`
+	TOTPSecret         string     `json:"-"`
+	// Role is RoleUser or RoleAdmin. DisabledAt is set while an admin has
+	// disabled the account, which can then neither log in nor use its
+	// sessions and API keys.
+	Role       string     `json:"role"`
+	DisabledAt *time.Time `json:"disabled_at"`
 }
 
 type Todo struct {
-	ID        int    `json:"id"`
-	UserID    int    `json:"user_id"`
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	Completed bool   `json:"completed"`
-	CreatedAt string `json:"created_at"`
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Title           string     `json:"title"`
+	Content         string     `json:"content"`
+	Completed       bool       `json:"completed"`
+	DueAt           *time.Time `json:"due_at"`
+	RemindAt        *time.Time `json:"remind_at"`
+	ReminderFiredAt *time.Time `json:"reminder_fired_at"`
+	Version         int        `json:"version"`
+	CreatedAt       string     `json:"created_at"`
+}
+
+// TodoFields are the client-editable fields of a todo, as written by
+// CreateTodo and UpdateTodo.
+type TodoFields struct {
+	Title     string
+	Content   string
+	Completed bool
+	DueAt     *time.Time
+	RemindAt  *time.Time
+}
+
+// AuthTokens are handed to the client when a login succeeds. Token is the
+// short-lived access token sent as the Authorization header; RefreshToken
+// obtains a new pair from POST /api/auth/refresh once Token expires.
+type AuthTokens struct {
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// RefreshToken is a stored refresh token, identified by the SHA-256 digest of
+// the value the client holds.
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+// Session is one signed-in device as listed by GET /api/auth/sessions.
+// ExpiresAt is when the device will be logged out unless it refreshes again.
+type Session struct {
+	ID         int        `json:"id"`
+	IP         string     `json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt *time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+}
+
+// SessionClient describes the device a request came from.
+type SessionClient struct {
+	IP        string
+	UserAgent string
 }
 
 type APIResponse struct {
-	Status  string      `json:"status"`
-	Message string      `json:"message"`
-	Data    interface{} `json:"data"`
+	Status  string            `json:"status"`
+	Code    string            `json:"code,omitempty"`
+	Message string            `json:"message"`
+	Errors  map[string]string `json:"errors,omitempty"`
+	Data    interface{}       `json:"data"`
 }
 
 type SignupRequest struct {
@@ -28,19 +96,215 @@ type SignupRequest struct {
 	Password string `json:"password"`
 }
 
+// LoginRequest names the account by Identifier, either its username or its
+// email. Username is still accepted from older clients as an alias.
 type LoginRequest struct {
-	Username string `json:"username"`
+	Identifier string `json:"identifier"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+}
+
+// LoginIdentifier returns Identifier, falling back to Username.
+func (pReq LoginRequest) LoginIdentifier() string {
+	if pReq.Identifier != "" {
+		return pReq.Identifier
+	}
+	return pReq.Username
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// UpdateProfileRequest is the body of PATCH /api/me; fields left out keep
+// their current value.
+type UpdateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
 	Password string `json:"password"`
 }
 
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// LoginChallenge is returned by a login whose password was right but which
+// still needs a two-factor code; see LoginTwoFactorAPI.
+type LoginChallenge struct {
+	TwoFactorRequired bool      `json:"two_factor_required"`
+	ChallengeToken    string    `json:"challenge_token"`
+	ExpiresAt         time.Time `json:"expires_at"`
+}
+
+// TwoFactorEnrollment is the TOTP secret handed out when 2FA enrollment
+// starts, both raw and as an otpauth:// URI for authenticator apps.
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// OIDCState is what is remembered about an OpenID Connect login between
+// sending the user to the provider and the provider sending them back.
+type OIDCState struct {
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+}
+
+type OIDCCallbackRequest struct {
+	State string `json:"state"`
+	Code  string `json:"code"`
+}
+
+// APIKey describes a personal API key. The key itself is only returned once,
+// when it is created.
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	ScopesArr  []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	ScopesArr []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type CreateTodoRequest struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	Title    string     `json:"title"`
+	Content  string     `json:"content"`
+	DueAt    *time.Time `json:"due_at"`
+	RemindAt *time.Time `json:"remind_at"`
 }
 
 type UpdateTodoRequest struct {
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	Completed bool   `json:"completed"`
+	Title     string     `json:"title"`
+	Content   string     `json:"content"`
+	Completed bool       `json:"completed"`
+	DueAt     *time.Time `json:"due_at"`
+	RemindAt  *time.Time `json:"remind_at"`
+}
+
+// PatchTodoRequest is the body of PATCH /api/todos/{id}. Only the fields
+// present in the JSON are changed; due_at and remind_at may be sent as null to
+// clear them.
+type PatchTodoRequest struct {
+	Title     *string      `json:"title"`
+	Content   *string      `json:"content"`
+	Completed *bool        `json:"completed"`
+	DueAt     OptionalTime `json:"due_at"`
+	RemindAt  OptionalTime `json:"remind_at"`
+}
+
+// TodoPatch is the field mask applied by PatchTodo: nil pointers and unset
+// OptionalTimes leave the stored value untouched.
+type TodoPatch struct {
+	Title     *string
+	Content   *string
+	Completed *bool
+	DueAt     OptionalTime
+	RemindAt  OptionalTime
 }
 
+// IsEmpty reports whether the patch would change nothing.
+func (pPatch TodoPatch) IsEmpty() bool {
+	return pPatch.Title == nil && pPatch.Content == nil && pPatch.Completed == nil && !pPatch.DueAt.Set && !pPatch.RemindAt.Set
+}
+
+// OptionalTime tells an omitted JSON field (Set is false) apart from an
+// explicit null (Set is true, Value is nil).
+type OptionalTime struct {
+	Set   bool
+	Value *time.Time
+}
+
+func (pOptional *OptionalTime) UnmarshalJSON(pData []byte) error {
+	pOptional.Set = true
+	if string(pData) == "null" {
+		pOptional.Value = nil
+		return nil
+	}
+
+	var lTime time.Time
+	lErr := json.Unmarshal(pData, &lTime)
+	if lErr != nil {
+		return lErr
+	}
+	pOptional.Value = &lTime
+	return nil
+}
+
+// TodoQuery filters, orders and pages the todos returned by ListTodos.
+type TodoQuery struct {
+	Completed     *bool
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	TitleContains string
+	DueAfter      *time.Time
+	DueBefore     *time.Time
+	SortBy        string
+	Descending    bool
+	Limit         int
+	After         *TodoCursor
+}
+
+// TodoCursor marks the last todo of a page. It is handed to clients as an
+// opaque base64 string in TodoPage.NextCursor.
+type TodoCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Value      string `json:"v"`
+	ID         int    `json:"id"`
+}
+
+// UserPage is one page of ListUsers. NextCursor is the ID of the page's last
+// user and is empty on the last page.
+type UserPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type TodoPage struct {
+	Todos      []Todo `json:"todos"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// TodoSearchResult is a todo matched by SearchTodos. The snippets repeat the
+// matched text HTML-escaped, with each hit wrapped in <mark></mark>, so they
+// can be rendered as HTML as they are.
+type TodoSearchResult struct {
+	Todo
+	Rank           float64 `json:"rank"`
+	TitleSnippet   string  `json:"title_snippet"`
+	ContentSnippet string  `json:"content_snippet"`
+}
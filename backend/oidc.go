@@ -0,0 +1,597 @@
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcMaxResponseBytes caps what is read from an identity provider.
+const oidcMaxResponseBytes = 1 << 20
+
+// oidcJWKSRefreshInterval limits how often an unknown key ID makes the JWKS
+// be fetched again, so that forged tokens cannot hammer the provider.
+const oidcJWKSRefreshInterval = time.Minute
+
+var lOIDCHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+var oidcProviderNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// OIDCProvider is an OpenID Connect identity provider users can log in with.
+// Its endpoints and signing keys are discovered from the issuer on first use.
+type OIDCProvider struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Issuer       string   `json:"-"`
+	ClientID     string   `json:"-"`
+	ClientSecret string   `json:"-"`
+	RedirectURL  string   `json:"-"`
+	ScopesArr    []string `json:"-"`
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keysArr       []jsonWebKey
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+var lOIDCProvidersArr []*OIDCProvider
+
+func SetOIDCProviders(pProvidersArr []*OIDCProvider) {
+	lOIDCProvidersArr = pProvidersArr
+}
+
+func GetOIDCProvider(pName string) *OIDCProvider {
+	for _, lProvider := range lOIDCProvidersArr {
+		if lProvider.Name == pName {
+			return lProvider
+		}
+	}
+	return nil
+}
+
+// LoadOIDCProvidersFromEnv reads the providers named in OIDC_PROVIDERS (comma
+// separated, e.g. "google,mock"). Each name N is configured by
+//
+//	OIDC_N_ISSUER         issuer URL (required)
+//	OIDC_N_CLIENT_ID      client ID (required)
+//	OIDC_N_CLIENT_SECRET  client secret; empty for a public client
+//	OIDC_N_DISPLAY_NAME   button label (default N)
+//	OIDC_N_SCOPES         space separated (default "openid email profile")
+//	OIDC_N_REDIRECT_URL   default APP_URL + "/oidc/callback"
+//
+// with N upper-cased and "-" written as "_".
+func LoadOIDCProvidersFromEnv() []*OIDCProvider {
+	var lProvidersArr []*OIDCProvider
+
+	for _, lName := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		lName = strings.ToLower(strings.TrimSpace(lName))
+		if lName == "" {
+			continue
+		}
+		if !oidcProviderNamePattern.MatchString(lName) {
+			log.Printf("LoadOIDCProvidersFromEnv: skipping provider %q: invalid name", lName)
+			continue
+		}
+
+		lPrefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(lName, "-", "_")) + "_"
+		lProvider := &OIDCProvider{
+			Name:         lName,
+			DisplayName:  GetEnvString(lPrefix+"DISPLAY_NAME", lName),
+			Issuer:       os.Getenv(lPrefix + "ISSUER"),
+			ClientID:     os.Getenv(lPrefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(lPrefix + "CLIENT_SECRET"),
+			RedirectURL:  GetEnvString(lPrefix+"REDIRECT_URL", GetEnvString("APP_URL", "http://localhost:8081")+"/oidc/callback"),
+			ScopesArr:    strings.Fields(GetEnvString(lPrefix+"SCOPES", "openid email profile")),
+		}
+		if lProvider.Issuer == "" || lProvider.ClientID == "" {
+			log.Printf("LoadOIDCProvidersFromEnv: skipping provider %q: %sISSUER and %sCLIENT_ID are required", lName, lPrefix, lPrefix)
+			continue
+		}
+		lProvidersArr = append(lProvidersArr, lProvider)
+	}
+
+	log.Printf("LoadOIDCProvidersFromEnv: %d providers configured", len(lProvidersArr))
+	return lProvidersArr
+}
+
+// ListOIDCProvidersAPI serves GET /api/auth/oidc/providers so the login page
+// can offer a button per provider.
+func ListOIDCProvidersAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ListOIDCProvidersAPI(+)")
+
+	lProvidersArr := lOIDCProvidersArr
+	if lProvidersArr == nil {
+		lProvidersArr = []*OIDCProvider{}
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Providers retrieved successfully",
+		Data:    lProvidersArr,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ListOIDCProvidersAPI(-)")
+}
+
+// StartOIDCLoginAPI serves POST /api/auth/oidc/{provider}/start. The client
+// keeps the returned state and sends the user to authorization_url; the
+// provider sends them back to the redirect URL with code and state.
+func StartOIDCLoginAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("StartOIDCLoginAPI(+)")
+
+	lProvider := GetOIDCProvider(PathParam(r, "provider"))
+	if lProvider == nil {
+		SendErrorResponse(w, ErrOIDCProviderNotFound)
+		log.Println("StartOIDCLoginAPI(-) error:", ErrOIDCProviderNotFound)
+		return
+	}
+
+	lAuthorizationURL, lState, lErr := StartOIDCLogin(lProvider)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("StartOIDCLoginAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Continue at the identity provider",
+		Data:    map[string]string{"authorization_url": lAuthorizationURL, "state": lState},
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("StartOIDCLoginAPI(-)")
+}
+
+// OIDCCallbackAPI serves POST /api/auth/oidc/callback with the code and state
+// the provider redirected back with. It answers like LoginAPI: with a session,
+// or with a challenge when the user has 2FA on.
+func OIDCCallbackAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("OIDCCallbackAPI(+)")
+
+	var lReq OIDCCallbackRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("OIDCCallbackAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr := CompleteOIDCLogin(lReq.State, lReq.Code)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("OIDCCallbackAPI(-) error:", lErr)
+		return
+	}
+
+	if lUser.TwoFactorEnabledAt != nil {
+		lChallenge, lErr := StartLoginChallenge(lUser.ID)
+		if lErr != nil {
+			SendErrorResponse(w, lErr)
+			log.Println("OIDCCallbackAPI(-) error:", lErr)
+			return
+		}
+
+		lResponse := APIResponse{
+			Status:  "s",
+			Message: "Two-factor authentication code required",
+			Data:    lChallenge,
+		}
+
+		SendJSONResponse(w, lResponse, http.StatusOK)
+		log.Println("OIDCCallbackAPI(-) two-factor challenge issued")
+		return
+	}
+
+	lTokens, lErr := CreateSession(lUser.ID, RequestClient(r))
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("OIDCCallbackAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Login successful",
+		Data:    authData(lUser, lTokens),
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("OIDCCallbackAPI(-)")
+}
+
+// StartOIDCLogin remembers a fresh state, nonce and PKCE verifier for
+// OIDC_STATE_TTL (default 10m) and returns the provider's authorization URL
+// together with the state.
+func StartOIDCLogin(pProvider *OIDCProvider) (string, string, error) {
+	log.Println("StartOIDCLogin(+)")
+
+	lDiscovery, lErr := pProvider.discover()
+	if lErr != nil {
+		log.Println("StartOIDCLogin(-) error:", lErr)
+		return "", "", ErrOIDCProviderUnavailable
+	}
+
+	lState, lErr := NewToken()
+	if lErr != nil {
+		log.Println("StartOIDCLogin(-) error:", lErr)
+		return "", "", lErr
+	}
+	lNonce, lErr := NewToken()
+	if lErr != nil {
+		log.Println("StartOIDCLogin(-) error:", lErr)
+		return "", "", lErr
+	}
+	lCodeVerifier, lErr := NewToken()
+	if lErr != nil {
+		log.Println("StartOIDCLogin(-) error:", lErr)
+		return "", "", lErr
+	}
+
+	lExpiresAt := time.Now().Add(GetEnvDuration("OIDC_STATE_TTL", 10*time.Minute))
+	lErr = GetStore().Identities.CreateOIDCState(HashToken(lState), OIDCState{
+		Provider:     pProvider.Name,
+		Nonce:        lNonce,
+		CodeVerifier: lCodeVerifier,
+	}, lExpiresAt)
+	if lErr != nil {
+		log.Println("StartOIDCLogin(-) error:", lErr)
+		return "", "", lErr
+	}
+
+	lAuthorizationURL, lErr := url.Parse(lDiscovery.AuthorizationEndpoint)
+	if lErr != nil {
+		log.Println("StartOIDCLogin(-) error:", lErr)
+		return "", "", ErrOIDCProviderUnavailable
+	}
+	lChallenge := sha256.Sum256([]byte(lCodeVerifier))
+
+	lQuery := lAuthorizationURL.Query()
+	lQuery.Set("response_type", "code")
+	lQuery.Set("client_id", pProvider.ClientID)
+	lQuery.Set("redirect_uri", pProvider.RedirectURL)
+	lQuery.Set("scope", strings.Join(pProvider.ScopesArr, " "))
+	lQuery.Set("state", lState)
+	lQuery.Set("nonce", lNonce)
+	lQuery.Set("code_challenge", base64.RawURLEncoding.EncodeToString(lChallenge[:]))
+	lQuery.Set("code_challenge_method", "S256")
+	lAuthorizationURL.RawQuery = lQuery.Encode()
+
+	log.Println("StartOIDCLogin(-)")
+	return lAuthorizationURL.String(), lState, nil
+}
+
+// CompleteOIDCLogin redeems pCode for an ID token, verifies it and returns the
+// local user of the identity it names, linking or creating one on first login.
+func CompleteOIDCLogin(pState string, pCode string) (*User, error) {
+	log.Println("CompleteOIDCLogin(+)")
+
+	lState, lErr := GetStore().Identities.ConsumeOIDCState(HashToken(pState), time.Now())
+	if lErr == ErrOIDCStateNotFound {
+		log.Println("CompleteOIDCLogin(-) error:", lErr)
+		return nil, ErrInvalidOIDCState
+	}
+	if lErr != nil {
+		log.Println("CompleteOIDCLogin(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lProvider := GetOIDCProvider(lState.Provider)
+	if lProvider == nil {
+		log.Println("CompleteOIDCLogin(-) error:", ErrOIDCProviderNotFound)
+		return nil, ErrOIDCProviderNotFound
+	}
+
+	lIDToken, lErr := lProvider.exchangeCode(pCode, lState.CodeVerifier)
+	if lErr != nil {
+		log.Println("CompleteOIDCLogin(-) error:", lErr)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	lClaims, lErr := lProvider.VerifyIDToken(lIDToken, lState.Nonce, time.Now())
+	if lErr != nil {
+		log.Println("CompleteOIDCLogin(-) error:", lErr)
+		return nil, ErrOIDCLoginFailed
+	}
+
+	lUser, lErr := oidcUser(lProvider.Name, lClaims)
+	if lErr != nil {
+		log.Println("CompleteOIDCLogin(-) error:", lErr)
+		return nil, lErr
+	}
+	if lUser.DisabledAt != nil {
+		log.Println("CompleteOIDCLogin(-) error:", ErrAccountDisabled)
+		return nil, ErrAccountDisabled
+	}
+
+	log.Println("CompleteOIDCLogin(-)")
+	return lUser, nil
+}
+
+// oidcUser finds the user linked to an ID token's subject. On the first login
+// through pProvider, an account with the same email is linked when both the
+// provider and the local account have verified the address; when no account
+// has the email, a new one is created.
+func oidcUser(pProvider string, pClaims *IDTokenClaims) (*User, error) {
+	lUserID, lErr := GetStore().Identities.GetUserIdentity(pProvider, pClaims.Subject)
+	if lErr == nil {
+		return getUserWithoutPassword(lUserID)
+	}
+	if lErr != ErrIdentityNotFound {
+		return nil, lErr
+	}
+
+	if validateEmail(pClaims.Email) != "" {
+		return nil, ErrOIDCEmailRequired
+	}
+
+	lUser, lErr := GetStore().Users.GetUserByEmail(pClaims.Email)
+	switch {
+	case lErr == nil && (!bool(pClaims.EmailVerified) || lUser.EmailVerifiedAt == nil):
+		// Linking on an address the provider has not checked would let anyone
+		// take over the local account by claiming its email there. Linking to
+		// a local account that never proved the address would hand its owner,
+		// who may have registered someone else's email, the identity's logins.
+		return nil, ErrEmailTaken
+	case lErr == ErrUserNotFound:
+		lUser, lErr = createOIDCUser(pClaims)
+	}
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	if pClaims.EmailVerified && lUser.EmailVerifiedAt == nil {
+		lErr = GetStore().Users.MarkEmailVerified(lUser.ID, time.Now())
+		if lErr != nil {
+			return nil, lErr
+		}
+	}
+
+	lErr = GetStore().Identities.CreateUserIdentity(lUser.ID, pProvider, pClaims.Subject, pClaims.Email)
+	if lErr != nil {
+		return nil, lErr
+	}
+	log.Printf("oidcUser: linked %s subject to user %d", pProvider, lUser.ID)
+
+	return getUserWithoutPassword(lUser.ID)
+}
+
+// createOIDCUser signs up the owner of an ID token. The account gets a random
+// password nobody knows; a password can be set later via forgot-password.
+func createOIDCUser(pClaims *IDTokenClaims) (*User, error) {
+	lHashedPassword, lErr := RandomPasswordHash()
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lBase := oidcUsernameBase(pClaims)
+	lUsername := lBase
+	for lAttempt := 0; ; lAttempt++ {
+		lUser, lErr := GetStore().Users.CreateUser(lUsername, pClaims.Email, lHashedPassword)
+		if lErr != ErrUsernameTaken || lAttempt == 5 {
+			if lErr == nil && !pClaims.EmailVerified {
+				lMailErr := SendVerificationEmail(lUser)
+				if lMailErr != nil {
+					log.Println("createOIDCUser verification email error:", lMailErr)
+				}
+			}
+			return lUser, lErr
+		}
+
+		lSuffix, lErr := NewToken()
+		if lErr != nil {
+			return nil, lErr
+		}
+		lUsername = lBase + "-" + lSuffix[:6]
+	}
+}
+
+// oidcUsernameBase derives a valid username from the preferred_username or
+// email claim.
+func oidcUsernameBase(pClaims *IDTokenClaims) string {
+	lCandidate := pClaims.PreferredUsername
+	if validateUsername(lCandidate) != "" {
+		lCandidate = strings.Split(pClaims.Email, "@")[0]
+	}
+
+	lCandidate = strings.Map(func(pRune rune) rune {
+		if pRune < 128 && usernamePattern.MatchString(string(pRune)) {
+			return pRune
+		}
+		return -1
+	}, lCandidate)
+
+	// Leave room for the suffix createOIDCUser adds on a clash.
+	if len(lCandidate) > MaxUsernameLength-7 {
+		lCandidate = lCandidate[:MaxUsernameLength-7]
+	}
+	if len(lCandidate) < MinUsernameLength {
+		lCandidate = "user" + lCandidate
+	}
+	return lCandidate
+}
+
+func getUserWithoutPassword(pUserID int) (*User, error) {
+	lUser, lErr := GetStore().Users.GetUserByID(pUserID)
+	if lErr != nil {
+		return nil, lErr
+	}
+	lUser.Password = ""
+	return lUser, nil
+}
+
+// discover fetches and caches the provider's OpenID configuration.
+func (pProvider *OIDCProvider) discover() (*oidcDiscovery, error) {
+	pProvider.mu.Lock()
+	defer pProvider.mu.Unlock()
+
+	if pProvider.discovery != nil {
+		return pProvider.discovery, nil
+	}
+
+	var lDiscovery oidcDiscovery
+	lErr := oidcGetJSON(strings.TrimSuffix(pProvider.Issuer, "/")+"/.well-known/openid-configuration", &lDiscovery)
+	if lErr != nil {
+		return nil, lErr
+	}
+	if lDiscovery.Issuer != pProvider.Issuer {
+		return nil, fmt.Errorf("discovery issuer %q does not match %q", lDiscovery.Issuer, pProvider.Issuer)
+	}
+	if lDiscovery.AuthorizationEndpoint == "" || lDiscovery.TokenEndpoint == "" || lDiscovery.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document of %q is incomplete", pProvider.Issuer)
+	}
+
+	pProvider.discovery = &lDiscovery
+	return pProvider.discovery, nil
+}
+
+// signingKey returns the provider key pKeyID for pAlgorithm. The JWKS is
+// fetched again when the key is unknown, since providers rotate keys.
+func (pProvider *OIDCProvider) signingKey(pKeyID string, pAlgorithm string) (crypto.PublicKey, error) {
+	lDiscovery, lErr := pProvider.discover()
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	pProvider.mu.Lock()
+	defer pProvider.mu.Unlock()
+
+	for lPass := 0; lPass < 2; lPass++ {
+		for _, lKey := range pProvider.keysArr {
+			// A token without kid is accepted when the key set has one key.
+			if lKey.KeyID == pKeyID || (pKeyID == "" && len(pProvider.keysArr) == 1) {
+				return lKey.publicKey(pAlgorithm)
+			}
+		}
+
+		if time.Since(pProvider.keysFetchedAt) < oidcJWKSRefreshInterval {
+			break
+		}
+		var lKeySet jsonWebKeySet
+		lErr = oidcGetJSON(lDiscovery.JWKSURI, &lKeySet)
+		if lErr != nil {
+			return nil, lErr
+		}
+		pProvider.keysArr = lKeySet.KeysArr
+		pProvider.keysFetchedAt = time.Now()
+	}
+	return nil, fmt.Errorf("no signing key %q", pKeyID)
+}
+
+// VerifyIDToken verifies an ID token's signature against the provider's JWKS
+// and validates its claims, including pNonce.
+func (pProvider *OIDCProvider) VerifyIDToken(pRaw string, pNonce string, pNow time.Time) (*IDTokenClaims, error) {
+	lHeader, lPartsArr, lErr := parseJWT(pRaw)
+	if lErr != nil {
+		return nil, lErr
+	}
+	if lHeader.Algorithm != "RS256" && lHeader.Algorithm != "ES256" {
+		return nil, fmt.Errorf("unsupported signature algorithm %q", lHeader.Algorithm)
+	}
+
+	lKey, lErr := pProvider.signingKey(lHeader.KeyID, lHeader.Algorithm)
+	if lErr != nil {
+		return nil, lErr
+	}
+	lErr = verifyJWTSignature(lHeader.Algorithm, lKey, lPartsArr)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lClaimsJSON, lErr := base64.RawURLEncoding.DecodeString(lPartsArr[1])
+	if lErr != nil {
+		return nil, lErr
+	}
+	var lClaims IDTokenClaims
+	lErr = json.Unmarshal(lClaimsJSON, &lClaims)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lErr = validateIDTokenClaims(&lClaims, pProvider.Issuer, pProvider.ClientID, pNonce, pNow)
+	if lErr != nil {
+		return nil, lErr
+	}
+	return &lClaims, nil
+}
+
+// exchangeCode redeems an authorization code at the token endpoint and
+// returns the ID token.
+func (pProvider *OIDCProvider) exchangeCode(pCode string, pCodeVerifier string) (string, error) {
+	lDiscovery, lErr := pProvider.discover()
+	if lErr != nil {
+		return "", lErr
+	}
+
+	lForm := url.Values{}
+	lForm.Set("grant_type", "authorization_code")
+	lForm.Set("code", pCode)
+	lForm.Set("redirect_uri", pProvider.RedirectURL)
+	lForm.Set("client_id", pProvider.ClientID)
+	lForm.Set("code_verifier", pCodeVerifier)
+
+	lReq, lErr := http.NewRequest(http.MethodPost, lDiscovery.TokenEndpoint, strings.NewReader(lForm.Encode()))
+	if lErr != nil {
+		return "", lErr
+	}
+	lReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	lReq.Header.Set("Accept", "application/json")
+	if pProvider.ClientSecret != "" {
+		lReq.SetBasicAuth(url.QueryEscape(pProvider.ClientID), url.QueryEscape(pProvider.ClientSecret))
+	}
+
+	lResp, lErr := lOIDCHTTPClient.Do(lReq)
+	if lErr != nil {
+		return "", lErr
+	}
+	defer lResp.Body.Close()
+
+	var lTokenResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	lErr = json.NewDecoder(io.LimitReader(lResp.Body, oidcMaxResponseBytes)).Decode(&lTokenResp)
+	if lErr != nil {
+		return "", fmt.Errorf("token endpoint: status %d: %v", lResp.StatusCode, lErr)
+	}
+	if lResp.StatusCode != http.StatusOK || lTokenResp.Error != "" {
+		return "", fmt.Errorf("token endpoint: status %d: %s %s", lResp.StatusCode, lTokenResp.Error, lTokenResp.ErrorDescription)
+	}
+	if lTokenResp.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned no id_token")
+	}
+	return lTokenResp.IDToken, nil
+}
+
+func oidcGetJSON(pURL string, pDest interface{}) error {
+	lResp, lErr := lOIDCHTTPClient.Get(pURL)
+	if lErr != nil {
+		return lErr
+	}
+	defer lResp.Body.Close()
+
+	if lResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", pURL, lResp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(lResp.Body, oidcMaxResponseBytes)).Decode(pDest)
+}
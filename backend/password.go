@@ -0,0 +1,225 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserTokenPasswordReset is the user_tokens purpose of password reset links.
+const UserTokenPasswordReset = "password_reset"
+
+// ForgotPasswordAPI serves POST /api/auth/forgot-password. It answers the
+// same way whether or not the email is registered, so it cannot be used to
+// find out which addresses have accounts. Requests are throttled per address
+// and per client IP, registered or not, so that it cannot be used to flood an
+// inbox either.
+func ForgotPasswordAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ForgotPasswordAPI(+)")
+
+	var lReq ForgotPasswordRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ForgotPasswordAPI(-) error:", lErr)
+		return
+	}
+
+	lEmail := strings.TrimSpace(lReq.Email)
+	if lEmail == "" {
+		SendErrorResponse(w, NewValidationError(map[string]string{"email": "Email is required"}))
+		log.Println("ForgotPasswordAPI(-) error: missing email")
+		return
+	}
+
+	lIP := ClientIP(r)
+	lRetryAfter, lErr := PasswordResetRetryAfter(lEmail, lIP)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ForgotPasswordAPI(-) error:", lErr)
+		return
+	}
+	if lRetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lRetryAfter.Seconds()))))
+		SendErrorResponse(w, ErrTooManyPasswordResets)
+		log.Println("ForgotPasswordAPI(-) error:", ErrTooManyPasswordResets)
+		return
+	}
+
+	lErr = RecordPasswordResetRequest(lEmail, lIP)
+	if lErr != nil {
+		log.Println("ForgotPasswordAPI record request error:", lErr)
+	}
+
+	lErr = ForgotPassword(lEmail)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ForgotPasswordAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "If an account exists for that email, a password reset link has been sent",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ForgotPasswordAPI(-)")
+}
+
+// ResetPasswordAPI serves POST /api/auth/reset-password with the token from
+// a reset link and the new password. Every session of the user is ended, so
+// they have to log in again.
+func ResetPasswordAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ResetPasswordAPI(+)")
+
+	var lReq ResetPasswordRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ResetPasswordAPI(-) error:", lErr)
+		return
+	}
+
+	lErr = ResetPassword(lReq.Token, lReq.Password)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ResetPasswordAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Password has been reset; please log in again",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ResetPasswordAPI(-)")
+}
+
+// ForgotPassword mails a reset link when pEmail belongs to an account. The
+// mail goes out in the background so that the response time does not reveal
+// whether it did.
+func ForgotPassword(pEmail string) error {
+	log.Println("ForgotPassword(+)")
+
+	lUser, lErr := GetStore().Users.GetUserByEmail(pEmail)
+	if lErr == ErrUserNotFound {
+		log.Println("ForgotPassword(-) no account for email")
+		return nil
+	}
+	if lErr != nil {
+		log.Println("ForgotPassword(-) error:", lErr)
+		return lErr
+	}
+
+	sendMailInBackground("ForgotPassword reset email", func() error {
+		return SendPasswordResetEmail(lUser)
+	})
+
+	log.Println("ForgotPassword(-)")
+	return nil
+}
+
+// SendPasswordResetEmail mails pUser a single-use link to APP_URL's
+// /reset-password page, valid for PASSWORD_RESET_TTL (default 1h).
+func SendPasswordResetEmail(pUser *User) error {
+	log.Println("SendPasswordResetEmail(+)")
+
+	lToken, lErr := NewToken()
+	if lErr != nil {
+		log.Println("SendPasswordResetEmail(-) error:", lErr)
+		return lErr
+	}
+
+	lTTL := GetEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	lErr = GetStore().UserTokens.CreateUserToken(pUser.ID, UserTokenPasswordReset, HashToken(lToken), time.Now().Add(lTTL))
+	if lErr != nil {
+		log.Println("SendPasswordResetEmail(-) error:", lErr)
+		return lErr
+	}
+
+	lErr = GetMailer().SendMail(MailMessage{
+		To:      pUser.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + pUser.Username + ",\n\n" +
+			"Someone asked to reset the password of your account. To choose a new one, open this link:\n\n" +
+			AppURL("/reset-password", lToken) + "\n\n" +
+			"The link expires in " + lTTL.String() + ". If you did not ask for a reset, you can ignore this email.\n",
+	})
+	if lErr != nil {
+		log.Println("SendPasswordResetEmail(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("SendPasswordResetEmail(-)")
+	return nil
+}
+
+// ResetPassword consumes a reset token, stores pPassword as the user's new
+// bcrypt hash and ends all of the user's sessions. The token is spent
+// together with the password change, so a failure leaves the link usable.
+func ResetPassword(pToken string, pPassword string) error {
+	log.Println("ResetPassword(+)")
+
+	// The password is checked before the token is spent so that a rejected
+	// password leaves the link usable. The username is not known yet, so the
+	// same-as-username rule is not applied here.
+	if lMessage := ValidatePassword(pPassword, ""); lMessage != "" {
+		log.Println("ResetPassword(-) error:", lMessage)
+		return NewValidationError(map[string]string{"password": lMessage})
+	}
+
+	lHashedPassword, lErr := bcrypt.GenerateFromPassword([]byte(pPassword), bcrypt.DefaultCost)
+	if lErr != nil {
+		log.Println("ResetPassword(-) error:", lErr)
+		return lErr
+	}
+
+	lUserID, lErr := GetStore().UserTokens.ResetPasswordWithToken(HashToken(pToken), string(lHashedPassword), time.Now())
+	if lErr == ErrUserTokenNotFound {
+		log.Println("ResetPassword(-) error:", lErr)
+		return ErrInvalidResetToken
+	}
+	if lErr != nil {
+		log.Println("ResetPassword(-) error:", lErr)
+		return lErr
+	}
+
+	lRevoked, lErr := GetStore().Sessions.DeleteOtherSessions(lUserID, 0)
+	if lErr != nil {
+		log.Println("ResetPassword(-) error:", lErr)
+		return lErr
+	}
+
+	// A user locked out by failed logins should be able to use the new
+	// password straight away.
+	lErr = ClearLoginFailures(UserThrottleKey(lUserID))
+	if lErr != nil {
+		log.Println("ResetPassword clear login failures error:", lErr)
+	}
+
+	log.Printf("ResetPassword(-) user %d, %d sessions revoked", lUserID, lRevoked)
+	return nil
+}
+
+// RandomPasswordHash hashes a random password that is never shown to anyone,
+// for accounts that must not be logged into with a password until it is reset.
+func RandomPasswordHash() (string, error) {
+	lPassword, lErr := NewToken()
+	if lErr != nil {
+		return "", lErr
+	}
+	lHashedPassword, lErr := bcrypt.GenerateFromPassword([]byte(lPassword), bcrypt.DefaultCost)
+	if lErr != nil {
+		return "", lErr
+	}
+	return string(lHashedPassword), nil
+}
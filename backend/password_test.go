@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testNewPassword = "Quiet-otter-42"
+
+// requestTestReset asks for a reset link for pEmail.
+func requestTestReset(t *testing.T, pServer *httptest.Server, pEmail string) (*http.Response, APIResponse) {
+	t.Helper()
+
+	return callAPI(t, pServer, http.MethodPost, "/api/auth/forgot-password", "", nil, ForgotPasswordRequest{Email: pEmail}, nil)
+}
+
+// resetTestPassword posts pToken and pPassword to the reset endpoint.
+func resetTestPassword(t *testing.T, pServer *httptest.Server, pToken string, pPassword string) (*http.Response, APIResponse) {
+	t.Helper()
+
+	return callAPI(t, pServer, http.MethodPost, "/api/auth/reset-password", "", nil, ResetPasswordRequest{Token: pToken, Password: pPassword}, nil)
+}
+
+func TestResetPassword(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	nextTestMail(t, "alice@example.com")
+
+	lResponse, _ := requestTestReset(t, lServer, "alice@example.com")
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("forgot password: %d", lResponse.StatusCode)
+	}
+	lToken := nextTestMail(t, "alice@example.com")
+
+	// A rejected password does not use the link up.
+	lResponse, lAPIResponse := resetTestPassword(t, lServer, lToken, "short")
+	if lResponse.StatusCode != http.StatusUnprocessableEntity || lAPIResponse.Errors["password"] == "" {
+		t.Errorf("weak password: %d %+v, want 422 on password", lResponse.StatusCode, lAPIResponse.Errors)
+	}
+
+	lResponse, _ = resetTestPassword(t, lServer, lToken, testNewPassword)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("reset: %d", lResponse.StatusCode)
+	}
+	lResponse, lAPIResponse = resetTestPassword(t, lServer, lToken, "Other-otter-43")
+	if lResponse.StatusCode != http.StatusBadRequest || lAPIResponse.Code != CodeInvalidResetToken {
+		t.Errorf("second use: %d %s, want 400 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInvalidResetToken)
+	}
+
+	// Every earlier session has ended.
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/verify", lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("old access token: %d, want 401", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lAlice.RefreshToken}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("old refresh token: %d, want 401", lResponse.StatusCode)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "alice", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with old password: %d, want 401", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "alice", Password: testNewPassword}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("login with new password: %d, want 200", lResponse.StatusCode)
+	}
+}
+
+func TestResetPasswordTokenExpires(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+
+	lErr := GetStore().UserTokens.CreateUserToken(lAlice.User.ID, UserTokenPasswordReset, HashToken("expired"), time.Now().Add(-time.Minute))
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	lResponse, lAPIResponse := resetTestPassword(t, lServer, "expired", testNewPassword)
+	if lResponse.StatusCode != http.StatusBadRequest || lAPIResponse.Code != CodeInvalidResetToken {
+		t.Errorf("expired token: %d %s, want 400 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInvalidResetToken)
+	}
+
+	// A verification token is not a reset token either.
+	lErr = GetStore().UserTokens.CreateUserToken(lAlice.User.ID, UserTokenEmailVerification, HashToken("verification"), time.Now().Add(time.Hour))
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	lResponse, _ = resetTestPassword(t, lServer, "verification", testNewPassword)
+	if lResponse.StatusCode != http.StatusBadRequest {
+		t.Errorf("verification token: %d, want 400", lResponse.StatusCode)
+	}
+}
+
+func TestForgotPasswordThrottle(t *testing.T) {
+	lServer := newTestServer(t)
+	t.Setenv("PASSWORD_RESET_MAX_PER_IP", "3")
+	signupTestUser(t, lServer, "alice")
+
+	// Each address gets one link per cooldown, whether or not it is
+	// registered, so the answer still says nothing about the account.
+	for _, lEmail := range []string{"alice@example.com", "nobody@example.com"} {
+		lResponse, _ := requestTestReset(t, lServer, lEmail)
+		if lResponse.StatusCode != http.StatusOK {
+			t.Fatalf("first request for %s: %d", lEmail, lResponse.StatusCode)
+		}
+		lResponse, lAPIResponse := requestTestReset(t, lServer, " "+lEmail)
+		if lResponse.StatusCode != http.StatusTooManyRequests || lAPIResponse.Code != CodeTooManyAttempts || lResponse.Header.Get("Retry-After") == "" {
+			t.Errorf("second request for %s: %d %s, Retry-After %q", lEmail, lResponse.StatusCode, lAPIResponse.Code, lResponse.Header.Get("Retry-After"))
+		}
+	}
+
+	// The address has now asked three times, two of them allowed.
+	lResponse, _ := requestTestReset(t, lServer, "carol@example.com")
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("third address: %d, want 200", lResponse.StatusCode)
+	}
+	lResponse, _ = requestTestReset(t, lServer, "dave@example.com")
+	if lResponse.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("request over the IP limit: %d, want 429", lResponse.StatusCode)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Login throttling is tracked under two keys per attempt. The account key
+// backs off exponentially from the first failure (LOGIN_BACKOFF_BASE, doubling
+// each time) and locks the account for LOGIN_LOCKOUT once it reaches
+// LOGIN_MAX_FAILURES. The IP key only locks out, after LOGIN_MAX_IP_FAILURES,
+// so that guessing across many usernames from one address is stopped as well
+// without slowing down users who share an address. Failures older than
+// LOGIN_FAILURE_WINDOW are forgotten.
+//
+// The account key is the user's ID (see UserThrottleKey), so that password
+// logins by username or by email, 2FA codes and password confirmations all
+// share one failure budget. Identifiers that
+// name no account are counted under their lower-cased text instead.
+const (
+	loginUserKeyPrefix    = "user:"
+	loginUnknownKeyPrefix = "unknown:"
+	loginIPKeyPrefix      = "ip:"
+)
+
+// UserThrottleKey is the account key of pUserID.
+func UserThrottleKey(pUserID int) string {
+	return loginUserKeyPrefix + strconv.Itoa(pUserID)
+}
+
+// LoginThrottleKey resolves the username or email a login names to its
+// account key.
+func LoginThrottleKey(pIdentifier string) (string, error) {
+	lUser, lErr := findLoginUser(pIdentifier)
+	if lErr == ErrUserNotFound {
+		return loginUnknownKeyPrefix + strings.ToLower(strings.TrimSpace(pIdentifier)), nil
+	}
+	if lErr != nil {
+		return "", lErr
+	}
+	return UserThrottleKey(lUser.ID), nil
+}
+
+// LoginRetryAfter returns how long a login counted under the account key
+// pUserKey from pIP has to wait, or 0 when it may go ahead.
+func LoginRetryAfter(pUserKey string, pIP string) (time.Duration, error) {
+	log.Println("LoginRetryAfter(+)")
+
+	lIPKey := loginIPKeyPrefix + pIP
+	lNow := time.Now()
+
+	lUntil, lErr := GetStore().LoginAttempts.LoginBlockedUntil([]string{pUserKey, lIPKey}, lNow)
+	if lErr != nil {
+		log.Println("LoginRetryAfter(-) error:", lErr)
+		return 0, lErr
+	}
+
+	log.Println("LoginRetryAfter(-)")
+	if lUntil.IsZero() {
+		return 0, nil
+	}
+	return lUntil.Sub(lNow), nil
+}
+
+// RecordLoginFailure logs a failed login of pIdentifier and applies backoff or
+// lockout to its account key pUserKey and to pIP.
+func RecordLoginFailure(pUserKey string, pIdentifier string, pIP string) error {
+	log.Println("RecordLoginFailure(+)")
+
+	lLoginAttempts := GetStore().LoginAttempts
+	lIPKey := loginIPKeyPrefix + pIP
+	lNow := time.Now()
+	lWindow := GetEnvDuration("LOGIN_FAILURE_WINDOW", time.Hour)
+	lLockout := GetEnvDuration("LOGIN_LOCKOUT", 15*time.Minute)
+
+	lErr := lLoginAttempts.RecordLoginFailure(pIdentifier, pIP, lNow)
+	if lErr != nil {
+		log.Println("RecordLoginFailure(-) error:", lErr)
+		return lErr
+	}
+
+	lFailures, lErr := lLoginAttempts.AddLoginFailure(pUserKey, lNow, lWindow)
+	if lErr != nil {
+		log.Println("RecordLoginFailure(-) error:", lErr)
+		return lErr
+	}
+	lMaxFailures := GetEnvInt("LOGIN_MAX_FAILURES", 5)
+	lBlockFor := loginBackoff(lFailures, lMaxFailures, GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second), lLockout)
+	if lFailures >= lMaxFailures {
+		log.Printf("RecordLoginFailure: %s locked out after %d failures", pUserKey, lFailures)
+	}
+	lErr = lLoginAttempts.BlockLogin(pUserKey, lNow.Add(lBlockFor))
+	if lErr != nil {
+		log.Println("RecordLoginFailure(-) error:", lErr)
+		return lErr
+	}
+
+	lFailures, lErr = lLoginAttempts.AddLoginFailure(lIPKey, lNow, lWindow)
+	if lErr != nil {
+		log.Println("RecordLoginFailure(-) error:", lErr)
+		return lErr
+	}
+	if lFailures >= GetEnvInt("LOGIN_MAX_IP_FAILURES", 20) {
+		log.Printf("RecordLoginFailure: %s locked out after %d failures", lIPKey, lFailures)
+		lErr = lLoginAttempts.BlockLogin(lIPKey, lNow.Add(lLockout))
+		if lErr != nil {
+			log.Println("RecordLoginFailure(-) error:", lErr)
+			return lErr
+		}
+	}
+
+	log.Println("RecordLoginFailure(-)")
+	return nil
+}
+
+// ClearLoginFailures resets the count of account key pUserKey after a
+// successful login. The IP's count is kept, otherwise one valid account would
+// let an address keep guessing at others.
+func ClearLoginFailures(pUserKey string) error {
+	return GetStore().LoginAttempts.ClearLoginFailures(pUserKey)
+}
+
+// Password reset requests are throttled with the same store. Each address
+// may ask for one link per PASSWORD_RESET_COOLDOWN (default 1m), and each IP
+// for PASSWORD_RESET_MAX_PER_IP links (default 10) per PASSWORD_RESET_WINDOW
+// (default 1h), after which it is blocked for another window.
+const (
+	resetEmailKeyPrefix = "reset-email:"
+	resetIPKeyPrefix    = "reset-ip:"
+)
+
+func passwordResetKeys(pEmail string, pIP string) (string, string) {
+	return resetEmailKeyPrefix + strings.ToLower(strings.TrimSpace(pEmail)), resetIPKeyPrefix + pIP
+}
+
+// PasswordResetRetryAfter returns how long a reset request for pEmail from
+// pIP has to wait, or 0 when it may go ahead.
+func PasswordResetRetryAfter(pEmail string, pIP string) (time.Duration, error) {
+	log.Println("PasswordResetRetryAfter(+)")
+
+	lEmailKey, lIPKey := passwordResetKeys(pEmail, pIP)
+	lNow := time.Now()
+
+	lUntil, lErr := GetStore().LoginAttempts.LoginBlockedUntil([]string{lEmailKey, lIPKey}, lNow)
+	if lErr != nil {
+		log.Println("PasswordResetRetryAfter(-) error:", lErr)
+		return 0, lErr
+	}
+
+	log.Println("PasswordResetRetryAfter(-)")
+	if lUntil.IsZero() {
+		return 0, nil
+	}
+	return lUntil.Sub(lNow), nil
+}
+
+// RecordPasswordResetRequest starts pEmail's cooldown and counts the request
+// against pIP.
+func RecordPasswordResetRequest(pEmail string, pIP string) error {
+	log.Println("RecordPasswordResetRequest(+)")
+
+	lLoginAttempts := GetStore().LoginAttempts
+	lEmailKey, lIPKey := passwordResetKeys(pEmail, pIP)
+	lNow := time.Now()
+	lWindow := GetEnvDuration("PASSWORD_RESET_WINDOW", time.Hour)
+
+	// Blocks only apply to keys with a recorded failure.
+	_, lErr := lLoginAttempts.AddLoginFailure(lEmailKey, lNow, lWindow)
+	if lErr != nil {
+		log.Println("RecordPasswordResetRequest(-) error:", lErr)
+		return lErr
+	}
+	lErr = lLoginAttempts.BlockLogin(lEmailKey, lNow.Add(GetEnvDuration("PASSWORD_RESET_COOLDOWN", time.Minute)))
+	if lErr != nil {
+		log.Println("RecordPasswordResetRequest(-) error:", lErr)
+		return lErr
+	}
+
+	lRequests, lErr := lLoginAttempts.AddLoginFailure(lIPKey, lNow, lWindow)
+	if lErr != nil {
+		log.Println("RecordPasswordResetRequest(-) error:", lErr)
+		return lErr
+	}
+	if lRequests >= GetEnvInt("PASSWORD_RESET_MAX_PER_IP", 10) {
+		log.Printf("RecordPasswordResetRequest: %s blocked after %d requests", lIPKey, lRequests)
+		lErr = lLoginAttempts.BlockLogin(lIPKey, lNow.Add(lWindow))
+		if lErr != nil {
+			log.Println("RecordPasswordResetRequest(-) error:", lErr)
+			return lErr
+		}
+	}
+
+	log.Println("RecordPasswordResetRequest(-)")
+	return nil
+}
+
+// loginBackoff is how long an account key is blocked after its pFailures-th
+// consecutive failure: pBase doubled per earlier failure, and pLockout once
+// pMaxFailures is reached.
+func loginBackoff(pFailures int, pMaxFailures int, pBase time.Duration, pLockout time.Duration) time.Duration {
+	if pFailures >= pMaxFailures {
+		return pLockout
+	}
+
+	lDelay := pBase
+	for i := 1; i < pFailures && lDelay < pLockout; i++ {
+		lDelay *= 2
+	}
+	if lDelay > pLockout {
+		return pLockout
+	}
+	return lDelay
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	lCasesArr := []struct {
+		failures int
+		delay    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		// Doubling stops at the lockout before the maximum is reached.
+		{9, time.Minute},
+		{10, time.Minute},
+		{50, time.Minute},
+	}
+
+	for _, lCase := range lCasesArr {
+		lDelay := loginBackoff(lCase.failures, 10, time.Second, time.Minute)
+		if lDelay != lCase.delay {
+			t.Errorf("loginBackoff(%d) = %s, want %s", lCase.failures, lDelay, lCase.delay)
+		}
+	}
+}
+
+func TestLoginBackoffLargeCount(t *testing.T) {
+	// A count far past the maximum must not overflow the doubling.
+	lDelay := loginBackoff(1000, 2000, time.Second, 15*time.Minute)
+	if lDelay != 15*time.Minute {
+		t.Errorf("loginBackoff(1000) = %s, want 15m", lDelay)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	lServer := newTestServer(t)
+	t.Setenv("LOGIN_MAX_FAILURES", "2")
+	t.Setenv("LOGIN_LOCKOUT", "1h")
+	signupTestUser(t, lServer, "alice")
+	signupTestUser(t, lServer, "bob")
+
+	// Failures by username and by email count against the same account.
+	for _, lIdentifier := range []string{"alice", "Alice@example.com"} {
+		lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: lIdentifier, Password: "wrong"}, nil)
+		if lResponse.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("wrong password for %s: %d, want 401", lIdentifier, lResponse.StatusCode)
+		}
+	}
+
+	// The right password no longer helps, however the name is typed.
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: "ALICE", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusTooManyRequests || lAPIResponse.Code != CodeTooManyAttempts || lResponse.Header.Get("Retry-After") == "" {
+		t.Errorf("locked out login: %d %s, Retry-After %q", lResponse.StatusCode, lAPIResponse.Code, lResponse.Header.Get("Retry-After"))
+	}
+
+	// Other accounts behind the same address are not affected.
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Username: "bob", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("other account: %d, want 200", lResponse.StatusCode)
+	}
+}
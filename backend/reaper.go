@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// sessionReaperLockKey is the advisory lock that keeps the session reaper
+// running on one replica at a time.
+const sessionReaperLockKey int64 = 0x746f646f02
+
+// StartSessionReaper deletes expired sessions and refresh tokens every
+// pInterval in a background goroutine, pBatchSize rows per statement so that
+// a large backlog never holds long row locks.
+func StartSessionReaper(pInterval time.Duration, pBatchSize int) {
+	log.Printf("StartSessionReaper: every %s, batches of %d", pInterval, pBatchSize)
+
+	go func() {
+		lTicker := time.NewTicker(pInterval)
+		defer lTicker.Stop()
+
+		for range lTicker.C {
+			ReapExpiredSessions(pBatchSize)
+		}
+	}()
+}
+
+// ReapExpiredSessions runs one reaper pass unless another replica is already
+// running one, returning how many sessions and refresh tokens it removed.
+func ReapExpiredSessions(pBatchSize int) (int, int) {
+	lSessions, lRefreshTokens := 0, 0
+
+	lRan, lErr := GetStore().Locker.WithTryLock(sessionReaperLockKey, func() error {
+		lNow := time.Now()
+
+		var lErr error
+		lSessions, lErr = deleteInBatches(pBatchSize, func() (int, error) {
+			return GetStore().Sessions.DeleteExpiredSessions(lNow, pBatchSize)
+		})
+		if lErr != nil {
+			return lErr
+		}
+
+		lRefreshTokens, lErr = deleteInBatches(pBatchSize, func() (int, error) {
+			return GetStore().Sessions.DeleteExpiredRefreshTokens(lNow, pBatchSize)
+		})
+		return lErr
+	})
+	if lErr != nil {
+		log.Println("ReapExpiredSessions error:", lErr)
+	}
+	if !lRan {
+		return 0, 0
+	}
+
+	if lSessions > 0 || lRefreshTokens > 0 {
+		log.Printf("ReapExpiredSessions: removed %d sessions and %d refresh tokens", lSessions, lRefreshTokens)
+	}
+	return lSessions, lRefreshTokens
+}
+
+// deleteInBatches calls pDelete until it removes fewer than pBatchSize rows
+// and returns the total removed.
+func deleteInBatches(pBatchSize int, pDelete func() (int, error)) (int, error) {
+	lTotal := 0
+	for {
+		lDeleted, lErr := pDelete()
+		lTotal += lDeleted
+		if lErr != nil || lDeleted < pBatchSize {
+			return lTotal, lErr
+		}
+	}
+}
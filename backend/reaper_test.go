@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReapExpiredSessions(t *testing.T) {
+	lStore := NewMemoryStore()
+	SetStore(lStore)
+
+	lUser, lErr := lStore.Users.CreateUser("alice", "alice@example.com", "hash")
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	lPast, lFuture := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	// Five logins that are over, one still signed in and one whose access
+	// token expired but can still be refreshed.
+	for lIndex := 0; lIndex < 5; lIndex++ {
+		lFamily := "expired-" + strconv.Itoa(lIndex)
+		mustTestStore(t, lStore.Sessions.CreateSession(lUser.ID, lFamily, lFamily+"-session", lPast, SessionClient{}))
+		mustTestStore(t, lStore.Sessions.CreateRefreshToken(lUser.ID, lFamily, lFamily+"-refresh", lPast))
+	}
+	mustTestStore(t, lStore.Sessions.CreateSession(lUser.ID, "live", "live-session", lFuture, SessionClient{}))
+	mustTestStore(t, lStore.Sessions.CreateSession(lUser.ID, "refreshable", "refreshable-session", lPast, SessionClient{}))
+	mustTestStore(t, lStore.Sessions.CreateRefreshToken(lUser.ID, "refreshable", "refreshable-refresh", lFuture))
+
+	lSessions, lRefreshTokens := ReapExpiredSessions(2)
+	if lSessions != 5 || lRefreshTokens != 5 {
+		t.Errorf("ReapExpiredSessions(2) = %d, %d, want 5, 5", lSessions, lRefreshTokens)
+	}
+
+	lLeftArr, lErr := lStore.Sessions.ListSessions(lUser.ID)
+	if lErr != nil || len(lLeftArr) != 2 {
+		t.Errorf("sessions left = %+v, %v, want the live and refreshable ones", lLeftArr, lErr)
+	}
+
+	lSessions, lRefreshTokens = ReapExpiredSessions(2)
+	if lSessions != 0 || lRefreshTokens != 0 {
+		t.Errorf("second pass = %d, %d, want 0, 0", lSessions, lRefreshTokens)
+	}
+}
+
+func TestDeleteInBatches(t *testing.T) {
+	lRemainingArr := []int{3, 3, 1}
+	lCalls := 0
+
+	lTotal, lErr := deleteInBatches(3, func() (int, error) {
+		lDeleted := lRemainingArr[lCalls]
+		lCalls++
+		return lDeleted, nil
+	})
+	if lErr != nil || lTotal != 7 || lCalls != 3 {
+		t.Errorf("deleteInBatches = %d, %v after %d calls, want 7 after 3", lTotal, lErr, lCalls)
+	}
+}
+
+// mustTestStore fails the test on a store error.
+func mustTestStore(t *testing.T, pErr error) {
+	t.Helper()
+
+	if pErr != nil {
+		t.Fatal(pErr)
+	}
+}
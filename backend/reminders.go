@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Notifier delivers a fired todo reminder to its owner.
+type Notifier interface {
+	NotifyReminder(pTodo Todo) error
+}
+
+// LogNotifier is the default Notifier; it only writes reminders to the log.
+type LogNotifier struct{}
+
+func (LogNotifier) NotifyReminder(pTodo Todo) error {
+	lDue := "no due date"
+	if pTodo.DueAt != nil {
+		lDue = "due " + pTodo.DueAt.Format(time.RFC3339)
+	}
+	log.Printf("Reminder for user %d: todo %d %q (%s)", pTodo.UserID, pTodo.ID, pTodo.Title, lDue)
+	return nil
+}
+
+// StartReminderScheduler polls for due reminders every pInterval in a
+// background goroutine. Each reminder is claimed (marked fired) before it is
+// handed to pNotifier, so it is delivered at most once even when several
+// replicas run the scheduler.
+func StartReminderScheduler(pNotifier Notifier, pInterval time.Duration, pBatchSize int) {
+	log.Printf("StartReminderScheduler: every %s, batches of %d", pInterval, pBatchSize)
+
+	go func() {
+		lTicker := time.NewTicker(pInterval)
+		defer lTicker.Stop()
+
+		for range lTicker.C {
+			FireDueReminders(pNotifier, pBatchSize)
+		}
+	}()
+}
+
+// FireDueReminders claims and delivers due reminders until none are left,
+// returning how many were delivered successfully.
+func FireDueReminders(pNotifier Notifier, pBatchSize int) int {
+	lDelivered := 0
+	for {
+		lTodosArr, lErr := GetStore().Todos.ClaimDueReminders(time.Now(), pBatchSize)
+		if lErr != nil {
+			log.Println("FireDueReminders error:", lErr)
+			return lDelivered
+		}
+
+		for _, lTodo := range lTodosArr {
+			lErr = pNotifier.NotifyReminder(lTodo)
+			if lErr != nil {
+				log.Printf("FireDueReminders: todo %d not delivered: %v", lTodo.ID, lErr)
+				continue
+			}
+			lDelivered++
+		}
+
+		if len(lTodosArr) < pBatchSize {
+			return lDelivered
+		}
+	}
+}
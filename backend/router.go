@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type pathParamsKey struct{}
+
+// Route is one registered path pattern together with the handler for each
+// HTTP method it accepts. Segments written as {name} capture that part of the
+// request path and are available to the handler through PathParam.
+type Route struct {
+	Pattern     string
+	segmentsArr []string
+	handlersMap map[string]http.HandlerFunc
+}
+
+// Router dispatches requests by path pattern and method. It answers 404 when
+// no pattern matches and 405 with an Allow header when the path matches but
+// the method does not.
+type Router struct {
+	routesArr []*Route
+}
+
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers pHandler for pMethod on pPattern, e.g.
+// Handle(http.MethodPut, "/api/todos/{id}", UpdateTodoAPI).
+func (pRouter *Router) Handle(pMethod string, pPattern string, pHandler http.HandlerFunc) {
+	for _, lRoute := range pRouter.routesArr {
+		if lRoute.Pattern == pPattern {
+			lRoute.handlersMap[pMethod] = pHandler
+			return
+		}
+	}
+
+	lRoute := &Route{
+		Pattern:     pPattern,
+		segmentsArr: splitPath(pPattern),
+		handlersMap: map[string]http.HandlerFunc{pMethod: pHandler},
+	}
+	pRouter.routesArr = append(pRouter.routesArr, lRoute)
+}
+
+func (pRouter *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	lRoute, lParamsMap := pRouter.match(r.URL.Path)
+	if lRoute == nil {
+		SendErrorResponse(w, ErrNotFound)
+		return
+	}
+
+	lHandler, lOk := lRoute.handlersMap[r.Method]
+	if !lOk {
+		w.Header().Set("Allow", strings.Join(lRoute.allowedMethods(), ", "))
+		SendErrorResponse(w, ErrMethodNotAllowed)
+		return
+	}
+
+	if len(lParamsMap) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, lParamsMap))
+	}
+	lHandler(w, r)
+}
+
+// match returns the route for pPath along with its captured parameters. When
+// more than one pattern matches, the one with the most literal segments wins,
+// so "/api/todos/search" takes precedence over "/api/todos/{id}".
+func (pRouter *Router) match(pPath string) (*Route, map[string]string) {
+	lPathArr := splitPath(pPath)
+
+	var lBest *Route
+	var lBestParamsMap map[string]string
+	lBestLiterals := -1
+	for _, lRoute := range pRouter.routesArr {
+		lParamsMap, lLiterals, lOk := lRoute.matchSegments(lPathArr)
+		if lOk && lLiterals > lBestLiterals {
+			lBest = lRoute
+			lBestParamsMap = lParamsMap
+			lBestLiterals = lLiterals
+		}
+	}
+	return lBest, lBestParamsMap
+}
+
+func (pRoute *Route) matchSegments(pPathArr []string) (map[string]string, int, bool) {
+	if len(pPathArr) != len(pRoute.segmentsArr) {
+		return nil, 0, false
+	}
+
+	var lParamsMap map[string]string
+	lLiterals := 0
+	for lIdx, lSegment := range pRoute.segmentsArr {
+		if strings.HasPrefix(lSegment, "{") && strings.HasSuffix(lSegment, "}") {
+			if pPathArr[lIdx] == "" {
+				return nil, 0, false
+			}
+			if lParamsMap == nil {
+				lParamsMap = make(map[string]string)
+			}
+			lParamsMap[lSegment[1:len(lSegment)-1]] = pPathArr[lIdx]
+			continue
+		}
+		if lSegment != pPathArr[lIdx] {
+			return nil, 0, false
+		}
+		lLiterals++
+	}
+	return lParamsMap, lLiterals, true
+}
+
+func (pRoute *Route) allowedMethods() []string {
+	lMethodsArr := make([]string, 0, len(pRoute.handlersMap))
+	for lMethod := range pRoute.handlersMap {
+		lMethodsArr = append(lMethodsArr, lMethod)
+	}
+	sort.Strings(lMethodsArr)
+	return lMethodsArr
+}
+
+// splitPath turns "/api/todos/7/" into ["api", "todos", "7"].
+func splitPath(pPath string) []string {
+	lTrimmed := strings.Trim(pPath, "/")
+	if lTrimmed == "" {
+		return nil
+	}
+	return strings.Split(lTrimmed, "/")
+}
+
+// PathParam returns the value captured for {pName} in the matched route
+// pattern, or "" when the route has no such parameter.
+func PathParam(r *http.Request, pName string) string {
+	lParamsMap, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return lParamsMap[pName]
+}
+
+// PathParamInt is PathParam for numeric identifiers such as {id}.
+func PathParamInt(r *http.Request, pName string) (int, error) {
+	return strconv.Atoi(PathParam(r, pName))
+}
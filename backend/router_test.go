@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestRouter() *Router {
+	lRouter := NewRouter()
+	lEcho := func(pName string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(pName + ":" + PathParam(r, "id")))
+		}
+	}
+	lRouter.Handle(http.MethodGet, "/api/todos", lEcho("list"))
+	lRouter.Handle(http.MethodPost, "/api/todos", lEcho("create"))
+	lRouter.Handle(http.MethodGet, "/api/todos/search", lEcho("search"))
+	lRouter.Handle(http.MethodGet, "/api/todos/{id}", lEcho("get"))
+	lRouter.Handle(http.MethodDelete, "/api/todos/{id}", lEcho("delete"))
+	return lRouter
+}
+
+func TestRouterMatch(t *testing.T) {
+	lCasesArr := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodGet, "/api/todos", "list:"},
+		{http.MethodPost, "/api/todos/", "create:"},
+		{http.MethodGet, "/api/todos/search", "search:"},
+		{http.MethodGet, "/api/todos/42", "get:42"},
+		{http.MethodDelete, "/api/todos/42", "delete:42"},
+	}
+
+	lRouter := newTestRouter()
+	for _, lCase := range lCasesArr {
+		lRecorder := httptest.NewRecorder()
+		lRouter.ServeHTTP(lRecorder, httptest.NewRequest(lCase.method, lCase.path, nil))
+		if lRecorder.Code != http.StatusOK || lRecorder.Body.String() != lCase.body {
+			t.Errorf("%s %s = %d %q, want 200 %q", lCase.method, lCase.path, lRecorder.Code, lRecorder.Body.String(), lCase.body)
+		}
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	lRecorder := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(lRecorder, httptest.NewRequest(http.MethodPut, "/api/todos/42", nil))
+
+	if lRecorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", lRecorder.Code)
+	}
+	if lAllow := lRecorder.Header().Get("Allow"); lAllow != "DELETE, GET" {
+		t.Errorf("Allow = %q, want %q", lAllow, "DELETE, GET")
+	}
+}
+
+func TestRouterNotFound(t *testing.T) {
+	for _, lPath := range []string{"/api/todo", "/api/todos/42/extra", "/api"} {
+		lRecorder := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(lRecorder, httptest.NewRequest(http.MethodGet, lPath, nil))
+		if lRecorder.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", lPath, lRecorder.Code)
+		}
+	}
+}
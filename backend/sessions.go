@@ -0,0 +1,128 @@
+package main
+
+import (
+	"log"
+	"net/http"
+)
+
+// ListSessionsAPI serves GET /api/auth/sessions: the devices the user is
+// signed in on, with the one making the request flagged as current.
+func ListSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ListSessionsAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lSessionsArr, lErr := ListSessions(lUser.ID, CurrentSessionID(r))
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ListSessionsAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Sessions retrieved successfully",
+		Data:    lSessionsArr,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ListSessionsAPI(-)")
+}
+
+// RevokeSessionAPI serves DELETE /api/auth/sessions/{id}. Revoking the
+// current session is the same as logging out.
+func RevokeSessionAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("RevokeSessionAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lSessionID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidSessionID)
+		log.Println("RevokeSessionAPI(-) error:", lErr)
+		return
+	}
+
+	lErr = RevokeSession(lUser.ID, lSessionID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("RevokeSessionAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Session revoked",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("RevokeSessionAPI(-)")
+}
+
+// RevokeOtherSessionsAPI serves DELETE /api/auth/sessions ("log out
+// everywhere else"): every session but the current one is revoked.
+func RevokeOtherSessionsAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("RevokeOtherSessionsAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lRevoked, lErr := RevokeOtherSessions(lUser.ID, CurrentSessionID(r))
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("RevokeOtherSessionsAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Other sessions revoked",
+		Data:    map[string]int{"revoked": lRevoked},
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("RevokeOtherSessionsAPI(-)")
+}
+
+func ListSessions(pUserID int, pCurrentSessionID int) ([]Session, error) {
+	log.Println("ListSessions(+)")
+
+	lSessionsArr, lErr := GetStore().Sessions.ListSessions(pUserID)
+	if lErr != nil {
+		log.Println("ListSessions(-) error:", lErr)
+		return nil, lErr
+	}
+
+	for i := range lSessionsArr {
+		lSessionsArr[i].Current = lSessionsArr[i].ID == pCurrentSessionID
+	}
+
+	log.Println("ListSessions(-)")
+	return lSessionsArr, nil
+}
+
+func RevokeSession(pUserID int, pSessionID int) error {
+	log.Println("RevokeSession(+)")
+
+	lErr := GetStore().Sessions.DeleteSessionByID(pUserID, pSessionID)
+	if lErr != nil {
+		log.Println("RevokeSession(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("RevokeSession(-)")
+	return nil
+}
+
+func RevokeOtherSessions(pUserID int, pCurrentSessionID int) (int, error) {
+	log.Println("RevokeOtherSessions(+)")
+
+	lRevoked, lErr := GetStore().Sessions.DeleteOtherSessions(pUserID, pCurrentSessionID)
+	if lErr != nil {
+		log.Println("RevokeOtherSessions(-) error:", lErr)
+		return 0, lErr
+	}
+
+	log.Println("RevokeOtherSessions(-)")
+	return lRevoked, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// loginTestDevice logs in as pUsername from a client with pUserAgent.
+func loginTestDevice(t *testing.T, pServer *httptest.Server, pUsername string, pUserAgent string) testAuth {
+	t.Helper()
+
+	var lAuth testAuth
+	lResponse, _ := callAPI(t, pServer, http.MethodPost, "/api/auth/login", "", map[string]string{"User-Agent": pUserAgent}, LoginRequest{Username: pUsername, Password: testPassword}, &lAuth)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("login of %s: %d", pUsername, lResponse.StatusCode)
+	}
+	return lAuth
+}
+
+// listTestSessions returns pToken's user's sessions keyed by user agent. It
+// calls from pUserAgent, since the current session records its client.
+func listTestSessions(t *testing.T, pServer *httptest.Server, pToken string, pUserAgent string) map[string]Session {
+	t.Helper()
+
+	var lSessionsArr []Session
+	lResponse, _ := callAPI(t, pServer, http.MethodGet, "/api/auth/sessions", pToken, map[string]string{"User-Agent": pUserAgent}, nil, &lSessionsArr)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("list sessions: %d", lResponse.StatusCode)
+	}
+	lSessionsMap := map[string]Session{}
+	for _, lSession := range lSessionsArr {
+		lSessionsMap[lSession.UserAgent] = lSession
+	}
+	return lSessionsMap
+}
+
+func TestRevokeSession(t *testing.T) {
+	lServer := newTestServer(t)
+	signupTestUser(t, lServer, "alice")
+	lLaptop := loginTestDevice(t, lServer, "alice", "laptop")
+	lPhone := loginTestDevice(t, lServer, "alice", "phone")
+
+	lSessionsMap := listTestSessions(t, lServer, lLaptop.Token, "laptop")
+	if !lSessionsMap["laptop"].Current || lSessionsMap["phone"].Current || lSessionsMap["phone"].ID == 0 {
+		t.Fatalf("sessions = %+v", lSessionsMap)
+	}
+
+	lPath := "/api/auth/sessions/" + strconv.Itoa(lSessionsMap["phone"].ID)
+	lResponse, _ := callAPI(t, lServer, http.MethodDelete, lPath, lLaptop.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("revoke phone: %d", lResponse.StatusCode)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/verify", lPhone.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("revoked session: %d, want 401", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/refresh", "", nil, RefreshRequest{RefreshToken: lPhone.RefreshToken}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("refresh of revoked session: %d, want 401", lResponse.StatusCode)
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/verify", lLaptop.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Errorf("revoking session: %d, want 200", lResponse.StatusCode)
+	}
+
+	// Someone else's session IDs are not found rather than revocable.
+	lBob := signupTestUser(t, lServer, "bob")
+	lResponse, _ = callAPI(t, lServer, http.MethodDelete, "/api/auth/sessions/"+strconv.Itoa(lSessionsMap["laptop"].ID), lBob.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusNotFound {
+		t.Errorf("revoke someone else's session: %d, want 404", lResponse.StatusCode)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	lServer := newTestServer(t)
+	signupTestUser(t, lServer, "alice")
+	lLaptop := loginTestDevice(t, lServer, "alice", "laptop")
+	lPhone := loginTestDevice(t, lServer, "alice", "phone")
+	lTablet := loginTestDevice(t, lServer, "alice", "tablet")
+
+	var lRevoked struct {
+		Revoked int `json:"revoked"`
+	}
+	lResponse, _ := callAPI(t, lServer, http.MethodDelete, "/api/auth/sessions", lLaptop.Token, nil, nil, &lRevoked)
+	// Signup's own session goes too.
+	if lResponse.StatusCode != http.StatusOK || lRevoked.Revoked != 3 {
+		t.Fatalf("revoke others: %d, revoked %d, want 3", lResponse.StatusCode, lRevoked.Revoked)
+	}
+
+	for _, lToken := range []string{lPhone.Token, lTablet.Token} {
+		lResponse, _ = callAPI(t, lServer, http.MethodGet, "/api/auth/verify", lToken, nil, nil, nil)
+		if lResponse.StatusCode != http.StatusUnauthorized {
+			t.Errorf("other session after revoke: %d, want 401", lResponse.StatusCode)
+		}
+	}
+
+	lSessionsMap := listTestSessions(t, lServer, lLaptop.Token, "laptop")
+	if len(lSessionsMap) != 1 || !lSessionsMap["laptop"].Current {
+		t.Errorf("sessions left = %+v, want only the current one", lSessionsMap)
+	}
+}
+
+func TestTouchSessionSkipsRecentSameClient(t *testing.T) {
+	lStore := NewMemoryStore()
+	lUser, lErr := lStore.Users.CreateUser("alice", "alice@example.com", "hash")
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	lClient := SessionClient{IP: "192.0.2.1", UserAgent: "laptop"}
+	lErr = lStore.Sessions.CreateSession(lUser.ID, "family", "token-hash", time.Now().Add(time.Hour), lClient)
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+
+	lLastSeen := func() Session {
+		lSessionsArr, lErr := lStore.Sessions.ListSessions(lUser.ID)
+		if lErr != nil || len(lSessionsArr) != 1 || lSessionsArr[0].LastSeenAt == nil {
+			t.Fatalf("ListSessions = %+v, %v", lSessionsArr, lErr)
+		}
+		return lSessionsArr[0]
+	}
+
+	lStore.Sessions.TouchSession("token-hash", lClient)
+	lFirst := lLastSeen()
+	time.Sleep(time.Millisecond)
+
+	lStore.Sessions.TouchSession("token-hash", lClient)
+	if lAgain := lLastSeen(); !lAgain.LastSeenAt.Equal(*lFirst.LastSeenAt) {
+		t.Errorf("same client within %s rewrote last_seen_at", SessionTouchInterval)
+	}
+
+	lStore.Sessions.TouchSession("token-hash", SessionClient{IP: "192.0.2.2", UserAgent: "laptop"})
+	if lMoved := lLastSeen(); lMoved.IP != "192.0.2.2" || !lMoved.LastSeenAt.After(*lFirst.LastSeenAt) {
+		t.Errorf("new IP not recorded: %+v", lMoved)
+	}
+}
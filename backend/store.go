@@ -0,0 +1,243 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrUserNotFound         = errors.New("user not found")
+	ErrRefreshTokenNotFound = errors.New("refresh token not found or expired")
+	ErrRefreshTokenReused   = errors.New("refresh token reused")
+	ErrUserTokenNotFound    = errors.New("user token not found, used or expired")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found or used")
+	ErrIdentityNotFound     = errors.New("identity not found")
+	ErrOIDCStateNotFound    = errors.New("oidc state not found or expired")
+)
+
+// UserStore persists accounts. Lookups return the user with its password hash
+// populated; callers are responsible for clearing it before it leaves the API.
+// Usernames and emails are unique and matched case-insensitively; CreateUser
+// returns ErrUsernameTaken or ErrEmailTaken when either is already in use.
+type UserStore interface {
+	CreateUser(pUsername string, pEmail string, pPasswordHash string) (*User, error)
+	GetUserByID(pUserID int) (*User, error)
+	GetUserByUsername(pUsername string) (*User, error)
+	GetUserByEmail(pEmail string) (*User, error)
+	MarkEmailVerified(pUserID int, pVerifiedAt time.Time) error
+	UpdatePassword(pUserID int, pPasswordHash string) error
+	// UpdateUserProfile sets the username and email of pUserID, returning
+	// ErrUsernameTaken or ErrEmailTaken like CreateUser. Changing the email
+	// clears EmailVerifiedAt.
+	UpdateUserProfile(pUserID int, pUsername string, pEmail string) (*User, error)
+	// DeleteUser deletes the account together with everything it owns:
+	// todos, sessions, tokens, identities and API keys.
+	DeleteUser(pUserID int) error
+	// ListUsers returns at most pLimit users with an ID above pAfterID, in ID
+	// order. A non-empty pSearch keeps only users whose username or email
+	// contains it, case-insensitively.
+	ListUsers(pSearch string, pAfterID int, pLimit int) ([]User, error)
+	SetUserRole(pUserID int, pRole string) error
+	// SetUserDisabled disables the account at pDisabledAt, or re-enables it
+	// when pDisabledAt is nil.
+	SetUserDisabled(pUserID int, pDisabledAt *time.Time) error
+}
+
+// SessionTouchInterval is how stale a session's last_seen_at may get before
+// TouchSession writes it again for the same client.
+const SessionTouchInterval = time.Minute
+
+// SessionStore persists login sessions, together with the refresh tokens that
+// renew them. Both are keyed by the SHA-256 digest of the token the client
+// holds (see HashToken); the tokens themselves are never stored.
+//
+// Sessions and refresh tokens that descend from the same login share a family
+// ID, and a family has a single session: creating another one for the same
+// family renews it in place, keeping its ID and creation time, and invalidates
+// the previous access token.
+type SessionStore interface {
+	CreateSession(pUserID int, pFamilyID string, pTokenHash string, pExpiresAt time.Time, pClient SessionClient) error
+	// TouchSession returns the owner and ID of an unexpired session and
+	// records pClient as its latest use. The record is only rewritten when it
+	// is older than SessionTouchInterval or the client has changed, so most
+	// authenticated requests stay reads.
+	TouchSession(pTokenHash string, pClient SessionClient) (*User, int, error)
+	// ListSessions returns pUserID's sessions that can still be used or
+	// refreshed, most recently seen first.
+	ListSessions(pUserID int) ([]Session, error)
+	// DeleteSession ends the login pTokenHash belongs to: every session and
+	// refresh token in its family is removed or revoked.
+	DeleteSession(pTokenHash string) error
+	// DeleteSessionByID is DeleteSession for one of pUserID's sessions by ID.
+	// It returns ErrSessionNotFound when pUserID has no such session.
+	DeleteSessionByID(pUserID int, pSessionID int) error
+	// DeleteOtherSessions ends every login of pUserID except the one behind
+	// session pKeepSessionID and returns how many were ended. A
+	// pKeepSessionID of 0 ends all of them.
+	DeleteOtherSessions(pUserID int, pKeepSessionID int) (int, error)
+
+	CreateRefreshToken(pUserID int, pFamilyID string, pTokenHash string, pExpiresAt time.Time) error
+	// RotateRefreshToken consumes the unexpired refresh token pTokenHash and
+	// stores pNewHash in the same family in its place. Presenting a token
+	// that was already consumed or revoked revokes the whole family and
+	// returns ErrRefreshTokenReused.
+	RotateRefreshToken(pTokenHash string, pNewHash string, pNewExpiresAt time.Time) (*RefreshToken, error)
+
+	// DeleteExpiredSessions deletes up to pLimit sessions that expired at or
+	// before pNow and can no longer be refreshed, returning how many it
+	// deleted.
+	DeleteExpiredSessions(pNow time.Time, pLimit int) (int, error)
+	// DeleteExpiredRefreshTokens deletes up to pLimit refresh tokens that
+	// expired at or before pNow, returning how many it deleted.
+	DeleteExpiredRefreshTokens(pNow time.Time, pLimit int) (int, error)
+}
+
+// TodoStore persists todos. Every method is scoped to pUserID so that one
+// user can never read or change another user's todos.
+//
+// Writes bump the todo's Version. The pIfMatch argument of UpdateTodo,
+// PatchTodo and DeleteTodo lists the versions the caller expects; when it is
+// non-nil and the stored version is not among them the write is refused with
+// ErrPreconditionFailed. A nil pIfMatch writes unconditionally.
+type TodoStore interface {
+	CreateTodo(pUserID int, pFields TodoFields) (*Todo, error)
+	// ListTodos returns at most pQuery.Limit todos matching pQuery, ordered by
+	// pQuery.SortBy and then id, starting after pQuery.After when set.
+	ListTodos(pUserID int, pQuery TodoQuery) ([]Todo, error)
+	GetTodo(pUserID int, pTodoID int) (*Todo, error)
+	// UpdateTodo replaces every field in pFields. Changing RemindAt re-arms a
+	// reminder that has already fired.
+	UpdateTodo(pUserID int, pTodoID int, pFields TodoFields, pIfMatch []int) (*Todo, error)
+	DeleteTodo(pUserID int, pTodoID int, pIfMatch []int) error
+	// PatchTodo changes only the fields set in pPatch. Like UpdateTodo, a new
+	// RemindAt re-arms a reminder that has already fired.
+	PatchTodo(pUserID int, pTodoID int, pPatch TodoPatch, pIfMatch []int) (*Todo, error)
+	// SearchTodos full-text searches title and content and returns the best
+	// pLimit matches, highest rank first.
+	SearchTodos(pUserID int, pQuery string, pLimit int) ([]TodoSearchResult, error)
+	// ClaimDueReminders marks up to pLimit reminders whose remind_at is at or
+	// before pNow as fired and returns their todos. A reminder is claimed by
+	// exactly one caller, even with several replicas polling concurrently.
+	ClaimDueReminders(pNow time.Time, pLimit int) ([]Todo, error)
+}
+
+// UserTokenStore persists single-use tokens mailed to users, keyed by the
+// SHA-256 digest of the token and scoped to a purpose such as
+// UserTokenEmailVerification.
+type UserTokenStore interface {
+	// CreateUserToken stores a new token for pUserID and pPurpose. Any of the
+	// user's earlier unused tokens for the same purpose stop working.
+	CreateUserToken(pUserID int, pPurpose string, pTokenHash string, pExpiresAt time.Time) error
+	// ConsumeUserToken marks an unused, unexpired pPurpose token as used and
+	// returns its user's ID, or ErrUserTokenNotFound.
+	ConsumeUserToken(pPurpose string, pTokenHash string, pNow time.Time) (int, error)
+	// GetUserToken is ConsumeUserToken without using the token up.
+	GetUserToken(pPurpose string, pTokenHash string, pNow time.Time) (int, error)
+	// ResetPasswordWithToken consumes a UserTokenPasswordReset token and sets
+	// its user's password hash in one step, so that the token is only spent
+	// when the password is changed. It returns the user's ID, or
+	// ErrUserTokenNotFound.
+	ResetPasswordWithToken(pTokenHash string, pPasswordHash string, pNow time.Time) (int, error)
+}
+
+// TwoFactorStore persists TOTP two-factor settings. The secret itself is read
+// with the user (User.TOTPSecret); recovery codes are keyed by their SHA-256
+// digest.
+type TwoFactorStore interface {
+	// SetPendingTOTPSecret starts enrollment by storing pSecret without
+	// enabling it. It returns ErrTwoFactorAlreadyEnabled when 2FA is on.
+	SetPendingTOTPSecret(pUserID int, pSecret string) error
+	// EnableTOTP turns 2FA on, provided pSecret is still the pending secret,
+	// and replaces the user's recovery codes. pStep is the time step of the
+	// code that confirmed it. It returns ErrTwoFactorNotPending otherwise.
+	EnableTOTP(pUserID int, pSecret string, pStep int64, pRecoveryHashesArr []string, pEnabledAt time.Time) error
+	// DisableTOTP turns 2FA off and deletes the secret and recovery codes.
+	DisableTOTP(pUserID int) error
+	// UseTOTPStep records that a code from time step pStep was accepted. It
+	// reports false when a code from pStep or a later step was accepted
+	// before, so each code works only once.
+	UseTOTPStep(pUserID int, pStep int64) (bool, error)
+	// ConsumeRecoveryCode marks one of pUserID's unused recovery codes as
+	// used, or returns ErrRecoveryCodeNotFound.
+	ConsumeRecoveryCode(pUserID int, pCodeHash string, pNow time.Time) error
+}
+
+// IdentityStore persists the links between OpenID Connect accounts and local
+// users, and the state of logins in progress.
+type IdentityStore interface {
+	// GetUserIdentity returns the user linked to pSubject at pProvider, or
+	// ErrIdentityNotFound.
+	GetUserIdentity(pProvider string, pSubject string) (int, error)
+	CreateUserIdentity(pUserID int, pProvider string, pSubject string, pEmail string) error
+
+	// CreateOIDCState stores a login in progress under the SHA-256 digest of
+	// its state parameter. Expired states are cleared out along the way.
+	CreateOIDCState(pStateHash string, pState OIDCState, pExpiresAt time.Time) error
+	// ConsumeOIDCState deletes and returns an unexpired state, or returns
+	// ErrOIDCStateNotFound.
+	ConsumeOIDCState(pStateHash string, pNow time.Time) (*OIDCState, error)
+}
+
+// APIKeyStore persists personal API keys, keyed by the SHA-256 digest of the
+// key. Keys that expired are kept, so that their owner still sees them, but no
+// longer authenticate.
+type APIKeyStore interface {
+	// CreateAPIKey stores pKey for pUserID and returns it with its ID and
+	// creation time filled in.
+	CreateAPIKey(pUserID int, pKey APIKey, pKeyHash string) (*APIKey, error)
+	ListAPIKeys(pUserID int) ([]APIKey, error)
+	// DeleteAPIKey returns ErrAPIKeyNotFound when pUserID has no such key.
+	DeleteAPIKey(pUserID int, pKeyID int) error
+	// TouchAPIKey returns an unexpired key and its owner and records pNow as
+	// its last use, or returns ErrAPIKeyNotFound.
+	TouchAPIKey(pKeyHash string, pNow time.Time) (*User, *APIKey, error)
+}
+
+// LoginAttemptStore backs the login and password reset rate limiters.
+// Failures are counted per key, such as the account tried or the client IP.
+type LoginAttemptStore interface {
+	// RecordLoginFailure appends a failed attempt to the review log.
+	RecordLoginFailure(pUsername string, pIP string, pAttemptedAt time.Time) error
+	// AddLoginFailure counts a failure against pKey and returns its number of
+	// consecutive failures. The count starts over when the previous failure
+	// is older than pWindow.
+	AddLoginFailure(pKey string, pNow time.Time, pWindow time.Duration) (int, error)
+	// BlockLogin refuses logins for pKey until pUntil.
+	BlockLogin(pKey string, pUntil time.Time) error
+	// LoginBlockedUntil returns the latest block among pKeysArr that is still
+	// in force at pNow, or the zero time when there is none.
+	LoginBlockedUntil(pKeysArr []string, pNow time.Time) (time.Time, error)
+	// ClearLoginFailures forgets pKey's failures and any block on it.
+	ClearLoginFailures(pKey string) error
+}
+
+// Locker lets background jobs run on one replica at a time.
+type Locker interface {
+	// WithTryLock runs pFn while holding the lock pKey and reports whether it
+	// ran. When another process already holds the lock, pFn is skipped.
+	WithTryLock(pKey int64, pFn func() error) (bool, error)
+}
+
+// Store bundles the repositories the business logic depends on.
+type Store struct {
+	Users    UserStore
+	Sessions SessionStore
+	Todos    TodoStore
+	Locker   Locker
+
+	LoginAttempts LoginAttemptStore
+	UserTokens    UserTokenStore
+	TwoFactor     TwoFactorStore
+	Identities    IdentityStore
+	APIKeys       APIKeyStore
+}
+
+var lStore *Store
+
+func SetStore(pStore *Store) {
+	lStore = pStore
+}
+
+func GetStore() *Store {
+	return lStore
+}
@@ -0,0 +1,1273 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// memoryStore is a thread-safe, process-local implementation of the Store
+// repositories. It needs no database, which makes it suitable for httptest
+// based tests and for running the API locally with STORE=memory.
+type memoryStore struct {
+	mu sync.Mutex
+
+	lastUserID int
+	lastTodoID int
+
+	lastSessionID int
+	lastRefreshID int
+
+	usersMap         map[int]*User
+	sessionsMap      map[string]memorySession
+	refreshTokensMap map[string]*memoryRefreshToken
+	todosMap         map[int]*Todo
+	locksMap         map[int64]bool
+
+	loginThrottlesMap map[string]*memoryLoginThrottle
+	loginAttemptsArr  []memoryLoginAttempt
+	userTokensMap     map[string]*memoryUserToken
+
+	// totpStepsMap holds each user's last accepted TOTP time step, and
+	// recoveryCodesMap their recovery code hashes, mapped to whether the code
+	// was used.
+	totpStepsMap     map[int]int64
+	recoveryCodesMap map[int]map[string]bool
+
+	identitiesMap map[string]int
+	oidcStatesMap map[string]memoryOIDCState
+
+	lastAPIKeyID int
+	apiKeysMap   map[string]*memoryAPIKey
+}
+
+type memoryAPIKey struct {
+	APIKey
+	UserID int
+}
+
+type memoryOIDCState struct {
+	OIDCState
+	ExpiresAt time.Time
+}
+
+type memorySession struct {
+	Session
+	UserID   int
+	FamilyID string
+}
+
+type memoryLoginThrottle struct {
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+type memoryLoginAttempt struct {
+	Username    string
+	IP          string
+	AttemptedAt time.Time
+}
+
+type memoryUserToken struct {
+	UserID    int
+	Purpose   string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type memoryRefreshToken struct {
+	RefreshToken
+	Used    bool
+	Revoked bool
+}
+
+func NewMemoryStore() *Store {
+	lStore := &memoryStore{
+		usersMap:         make(map[int]*User),
+		sessionsMap:      make(map[string]memorySession),
+		refreshTokensMap: make(map[string]*memoryRefreshToken),
+		todosMap:         make(map[int]*Todo),
+		locksMap:         make(map[int64]bool),
+
+		loginThrottlesMap: make(map[string]*memoryLoginThrottle),
+		userTokensMap:     make(map[string]*memoryUserToken),
+
+		totpStepsMap:     make(map[int]int64),
+		recoveryCodesMap: make(map[int]map[string]bool),
+
+		identitiesMap: make(map[string]int),
+		oidcStatesMap: make(map[string]memoryOIDCState),
+
+		apiKeysMap: make(map[string]*memoryAPIKey),
+	}
+	return &Store{
+		Users:    lStore,
+		Sessions: lStore,
+		Todos:    lStore,
+		Locker:   lStore,
+
+		LoginAttempts: lStore,
+		UserTokens:    lStore,
+		TwoFactor:     lStore,
+		Identities:    lStore,
+		APIKeys:       lStore,
+	}
+}
+
+func memoryTimestamp(pTime time.Time) string {
+	return pTime.UTC().Format(time.RFC3339Nano)
+}
+
+// memoryTimePtr copies an optional timestamp at the microsecond precision a
+// Postgres TIMESTAMP column keeps.
+func memoryTimePtr(pTime *time.Time) *time.Time {
+	if pTime == nil {
+		return nil
+	}
+	lTime := pTime.UTC().Truncate(time.Microsecond)
+	return &lTime
+}
+
+func (pStore *memoryStore) CreateUser(pUsername string, pEmail string, pPasswordHash string) (*User, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	for _, lUser := range pStore.usersMap {
+		if strings.EqualFold(lUser.Username, pUsername) {
+			return nil, ErrUsernameTaken
+		}
+		if strings.EqualFold(lUser.Email, pEmail) {
+			return nil, ErrEmailTaken
+		}
+	}
+
+	pStore.lastUserID++
+	lUser := &User{ID: pStore.lastUserID, Username: pUsername, Email: pEmail, Password: pPasswordHash, Role: RoleUser}
+	pStore.usersMap[lUser.ID] = lUser
+
+	lCopy := *lUser
+	lCopy.Password = ""
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) GetUserByID(pUserID int) (*User, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return nil, ErrUserNotFound
+	}
+	lCopy := *lUser
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) GetUserByUsername(pUsername string) (*User, error) {
+	return pStore.findUser(func(pUser *User) bool {
+		return strings.EqualFold(pUser.Username, pUsername)
+	})
+}
+
+func (pStore *memoryStore) GetUserByEmail(pEmail string) (*User, error) {
+	return pStore.findUser(func(pUser *User) bool {
+		return strings.EqualFold(pUser.Email, pEmail)
+	})
+}
+
+func (pStore *memoryStore) MarkEmailVerified(pUserID int, pVerifiedAt time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return ErrUserNotFound
+	}
+	if lUser.EmailVerifiedAt == nil {
+		lUser.EmailVerifiedAt = memoryTimePtr(&pVerifiedAt)
+	}
+	return nil
+}
+
+func (pStore *memoryStore) UpdatePassword(pUserID int, pPasswordHash string) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return ErrUserNotFound
+	}
+	lUser.Password = pPasswordHash
+	return nil
+}
+
+func (pStore *memoryStore) UpdateUserProfile(pUserID int, pUsername string, pEmail string) (*User, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return nil, ErrUserNotFound
+	}
+	for _, lOther := range pStore.usersMap {
+		if lOther.ID == pUserID {
+			continue
+		}
+		if strings.EqualFold(lOther.Username, pUsername) {
+			return nil, ErrUsernameTaken
+		}
+		if strings.EqualFold(lOther.Email, pEmail) {
+			return nil, ErrEmailTaken
+		}
+	}
+
+	if !strings.EqualFold(lUser.Email, pEmail) {
+		lUser.EmailVerifiedAt = nil
+	}
+	lUser.Username = pUsername
+	lUser.Email = pEmail
+
+	lCopy := *lUser
+	lCopy.Password = ""
+	return &lCopy, nil
+}
+
+// DeleteUser removes everything that the ON DELETE CASCADE foreign keys
+// would remove in Postgres.
+func (pStore *memoryStore) DeleteUser(pUserID int) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return ErrUserNotFound
+	}
+	delete(pStore.usersMap, pUserID)
+
+	for lHash, lSession := range pStore.sessionsMap {
+		if lSession.UserID == pUserID {
+			delete(pStore.sessionsMap, lHash)
+		}
+	}
+	for lHash, lToken := range pStore.refreshTokensMap {
+		if lToken.UserID == pUserID {
+			delete(pStore.refreshTokensMap, lHash)
+		}
+	}
+	for lID, lTodo := range pStore.todosMap {
+		if lTodo.UserID == pUserID {
+			delete(pStore.todosMap, lID)
+		}
+	}
+	for lHash, lToken := range pStore.userTokensMap {
+		if lToken.UserID == pUserID {
+			delete(pStore.userTokensMap, lHash)
+		}
+	}
+	delete(pStore.totpStepsMap, pUserID)
+	delete(pStore.recoveryCodesMap, pUserID)
+	for lKey, lUserID := range pStore.identitiesMap {
+		if lUserID == pUserID {
+			delete(pStore.identitiesMap, lKey)
+		}
+	}
+	for lHash, lKey := range pStore.apiKeysMap {
+		if lKey.UserID == pUserID {
+			delete(pStore.apiKeysMap, lHash)
+		}
+	}
+	return nil
+}
+
+func (pStore *memoryStore) ListUsers(pSearch string, pAfterID int, pLimit int) ([]User, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lSearch := strings.ToLower(pSearch)
+	lUsersArr := []User{}
+	for _, lUser := range pStore.usersMap {
+		if lUser.ID <= pAfterID {
+			continue
+		}
+		if lSearch != "" && !strings.Contains(strings.ToLower(lUser.Username), lSearch) && !strings.Contains(strings.ToLower(lUser.Email), lSearch) {
+			continue
+		}
+		lCopy := *lUser
+		lCopy.Password = ""
+		lUsersArr = append(lUsersArr, lCopy)
+	}
+
+	sort.Slice(lUsersArr, func(i, j int) bool {
+		return lUsersArr[i].ID < lUsersArr[j].ID
+	})
+	if len(lUsersArr) > pLimit {
+		lUsersArr = lUsersArr[:pLimit]
+	}
+	return lUsersArr, nil
+}
+
+func (pStore *memoryStore) SetUserRole(pUserID int, pRole string) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return ErrUserNotFound
+	}
+	lUser.Role = pRole
+	return nil
+}
+
+func (pStore *memoryStore) SetUserDisabled(pUserID int, pDisabledAt *time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return ErrUserNotFound
+	}
+	lUser.DisabledAt = memoryTimePtr(pDisabledAt)
+	return nil
+}
+
+func (pStore *memoryStore) CreateUserToken(pUserID int, pPurpose string, pTokenHash string, pExpiresAt time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return ErrUserNotFound
+	}
+	for lHash, lToken := range pStore.userTokensMap {
+		if lToken.UserID == pUserID && lToken.Purpose == pPurpose && !lToken.Used {
+			delete(pStore.userTokensMap, lHash)
+		}
+	}
+	pStore.userTokensMap[pTokenHash] = &memoryUserToken{UserID: pUserID, Purpose: pPurpose, ExpiresAt: pExpiresAt}
+	return nil
+}
+
+func (pStore *memoryStore) ConsumeUserToken(pPurpose string, pTokenHash string, pNow time.Time) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lToken, lOk := pStore.userTokensMap[pTokenHash]
+	if !lOk || lToken.Purpose != pPurpose || lToken.Used || !lToken.ExpiresAt.After(pNow) {
+		return 0, ErrUserTokenNotFound
+	}
+	lToken.Used = true
+	return lToken.UserID, nil
+}
+
+func (pStore *memoryStore) ResetPasswordWithToken(pTokenHash string, pPasswordHash string, pNow time.Time) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lToken, lOk := pStore.userTokensMap[pTokenHash]
+	if !lOk || lToken.Purpose != UserTokenPasswordReset || lToken.Used || !lToken.ExpiresAt.After(pNow) {
+		return 0, ErrUserTokenNotFound
+	}
+	lUser, lOk := pStore.usersMap[lToken.UserID]
+	if !lOk {
+		return 0, ErrUserTokenNotFound
+	}
+
+	lToken.Used = true
+	lUser.Password = pPasswordHash
+	return lToken.UserID, nil
+}
+
+func (pStore *memoryStore) GetUserToken(pPurpose string, pTokenHash string, pNow time.Time) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lToken, lOk := pStore.userTokensMap[pTokenHash]
+	if !lOk || lToken.Purpose != pPurpose || lToken.Used || !lToken.ExpiresAt.After(pNow) {
+		return 0, ErrUserTokenNotFound
+	}
+	return lToken.UserID, nil
+}
+
+func (pStore *memoryStore) SetPendingTOTPSecret(pUserID int, pSecret string) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return ErrUserNotFound
+	}
+	if lUser.TwoFactorEnabledAt != nil {
+		return ErrTwoFactorAlreadyEnabled
+	}
+	lUser.TOTPSecret = pSecret
+	delete(pStore.totpStepsMap, pUserID)
+	return nil
+}
+
+func (pStore *memoryStore) EnableTOTP(pUserID int, pSecret string, pStep int64, pRecoveryHashesArr []string, pEnabledAt time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk || lUser.TwoFactorEnabledAt != nil || lUser.TOTPSecret == "" || lUser.TOTPSecret != pSecret {
+		return ErrTwoFactorNotPending
+	}
+	lUser.TwoFactorEnabledAt = memoryTimePtr(&pEnabledAt)
+	pStore.totpStepsMap[pUserID] = pStep
+
+	lCodesMap := make(map[string]bool, len(pRecoveryHashesArr))
+	for _, lHash := range pRecoveryHashesArr {
+		lCodesMap[lHash] = false
+	}
+	pStore.recoveryCodesMap[pUserID] = lCodesMap
+	return nil
+}
+
+func (pStore *memoryStore) DisableTOTP(pUserID int) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUser, lOk := pStore.usersMap[pUserID]
+	if !lOk {
+		return ErrUserNotFound
+	}
+	lUser.TOTPSecret = ""
+	lUser.TwoFactorEnabledAt = nil
+	delete(pStore.totpStepsMap, pUserID)
+	delete(pStore.recoveryCodesMap, pUserID)
+	return nil
+}
+
+func (pStore *memoryStore) UseTOTPStep(pUserID int, pStep int64) (bool, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if lLastStep, lOk := pStore.totpStepsMap[pUserID]; lOk && lLastStep >= pStep {
+		return false, nil
+	}
+	pStore.totpStepsMap[pUserID] = pStep
+	return true, nil
+}
+
+func (pStore *memoryStore) ConsumeRecoveryCode(pUserID int, pCodeHash string, pNow time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUsed, lOk := pStore.recoveryCodesMap[pUserID][pCodeHash]
+	if !lOk || lUsed {
+		return ErrRecoveryCodeNotFound
+	}
+	pStore.recoveryCodesMap[pUserID][pCodeHash] = true
+	return nil
+}
+
+// memoryIdentityKey keys identitiesMap; a NUL cannot appear in either part.
+func memoryIdentityKey(pProvider string, pSubject string) string {
+	return pProvider + "\x00" + pSubject
+}
+
+func (pStore *memoryStore) GetUserIdentity(pProvider string, pSubject string) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lUserID, lOk := pStore.identitiesMap[memoryIdentityKey(pProvider, pSubject)]
+	if !lOk {
+		return 0, ErrIdentityNotFound
+	}
+	return lUserID, nil
+}
+
+func (pStore *memoryStore) CreateUserIdentity(pUserID int, pProvider string, pSubject string, pEmail string) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return ErrUserNotFound
+	}
+	lKey := memoryIdentityKey(pProvider, pSubject)
+	if _, lOk := pStore.identitiesMap[lKey]; lOk {
+		return errors.New("identity already linked")
+	}
+	pStore.identitiesMap[lKey] = pUserID
+	return nil
+}
+
+func (pStore *memoryStore) CreateOIDCState(pStateHash string, pState OIDCState, pExpiresAt time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lNow := time.Now()
+	for lHash, lState := range pStore.oidcStatesMap {
+		if !lState.ExpiresAt.After(lNow) {
+			delete(pStore.oidcStatesMap, lHash)
+		}
+	}
+	pStore.oidcStatesMap[pStateHash] = memoryOIDCState{OIDCState: pState, ExpiresAt: pExpiresAt}
+	return nil
+}
+
+func (pStore *memoryStore) ConsumeOIDCState(pStateHash string, pNow time.Time) (*OIDCState, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lState, lOk := pStore.oidcStatesMap[pStateHash]
+	if !lOk || !lState.ExpiresAt.After(pNow) {
+		return nil, ErrOIDCStateNotFound
+	}
+	delete(pStore.oidcStatesMap, pStateHash)
+	return &lState.OIDCState, nil
+}
+
+func (pStore *memoryStore) CreateAPIKey(pUserID int, pKey APIKey, pKeyHash string) (*APIKey, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return nil, ErrUserNotFound
+	}
+
+	pStore.lastAPIKeyID++
+	pKey.ID = pStore.lastAPIKeyID
+	pKey.ScopesArr = append([]string(nil), pKey.ScopesArr...)
+	pKey.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	pKey.ExpiresAt = memoryTimePtr(pKey.ExpiresAt)
+	pKey.LastUsedAt = nil
+	pStore.apiKeysMap[pKeyHash] = &memoryAPIKey{APIKey: pKey, UserID: pUserID}
+	return &pKey, nil
+}
+
+func (pStore *memoryStore) ListAPIKeys(pUserID int) ([]APIKey, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lKeysArr := []APIKey{}
+	for _, lKey := range pStore.apiKeysMap {
+		if lKey.UserID == pUserID {
+			lKeysArr = append(lKeysArr, lKey.APIKey)
+		}
+	}
+	sort.Slice(lKeysArr, func(i, j int) bool {
+		return lKeysArr[i].ID > lKeysArr[j].ID
+	})
+	return lKeysArr, nil
+}
+
+func (pStore *memoryStore) DeleteAPIKey(pUserID int, pKeyID int) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	for lHash, lKey := range pStore.apiKeysMap {
+		if lKey.UserID == pUserID && lKey.ID == pKeyID {
+			delete(pStore.apiKeysMap, lHash)
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (pStore *memoryStore) TouchAPIKey(pKeyHash string, pNow time.Time) (*User, *APIKey, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lKey, lOk := pStore.apiKeysMap[pKeyHash]
+	if !lOk || (lKey.ExpiresAt != nil && !lKey.ExpiresAt.After(pNow)) {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	lUser, lOk := pStore.usersMap[lKey.UserID]
+	if !lOk {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+
+	lKey.LastUsedAt = memoryTimePtr(&pNow)
+	lKeyCopy := lKey.APIKey
+	lUserCopy := *lUser
+	lUserCopy.Password = ""
+	return &lUserCopy, &lKeyCopy, nil
+}
+
+func (pStore *memoryStore) findUser(pMatch func(pUser *User) bool) (*User, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	for _, lUser := range pStore.usersMap {
+		if pMatch(lUser) {
+			lCopy := *lUser
+			return &lCopy, nil
+		}
+	}
+	return nil, ErrUserNotFound
+}
+
+func (pStore *memoryStore) CreateSession(pUserID int, pFamilyID string, pTokenHash string, pExpiresAt time.Time, pClient SessionClient) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return ErrUserNotFound
+	}
+
+	lNow := time.Now().UTC().Truncate(time.Microsecond)
+	lSession := memorySession{UserID: pUserID, FamilyID: pFamilyID}
+	for lHash, lExisting := range pStore.sessionsMap {
+		if lExisting.FamilyID == pFamilyID {
+			lSession = lExisting
+			delete(pStore.sessionsMap, lHash)
+			break
+		}
+	}
+	if lSession.ID == 0 {
+		pStore.lastSessionID++
+		lSession.ID = pStore.lastSessionID
+		lSession.CreatedAt = lNow
+	}
+
+	lSession.ExpiresAt = pExpiresAt
+	lSession.LastSeenAt = &lNow
+	lSession.IP = pClient.IP
+	lSession.UserAgent = pClient.UserAgent
+	pStore.sessionsMap[pTokenHash] = lSession
+	return nil
+}
+
+func (pStore *memoryStore) TouchSession(pTokenHash string, pClient SessionClient) (*User, int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lSession, lOk := pStore.sessionsMap[pTokenHash]
+	if !lOk || !lSession.ExpiresAt.After(time.Now()) {
+		return nil, 0, ErrSessionNotFound
+	}
+
+	lUser, lOk := pStore.usersMap[lSession.UserID]
+	if !lOk {
+		return nil, 0, ErrSessionNotFound
+	}
+
+	lNow := time.Now().UTC().Truncate(time.Microsecond)
+	if lSession.LastSeenAt == nil || lSession.LastSeenAt.Before(lNow.Add(-SessionTouchInterval)) || lSession.IP != pClient.IP || lSession.UserAgent != pClient.UserAgent {
+		lSession.LastSeenAt = &lNow
+		lSession.IP = pClient.IP
+		lSession.UserAgent = pClient.UserAgent
+		pStore.sessionsMap[pTokenHash] = lSession
+	}
+	lCopy := *lUser
+	lCopy.Password = ""
+	return &lCopy, lSession.ID, nil
+}
+
+func (pStore *memoryStore) ListSessions(pUserID int) ([]Session, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lNow := time.Now()
+	lSessionsArr := []Session{}
+	for _, lStored := range pStore.sessionsMap {
+		if lStored.UserID != pUserID {
+			continue
+		}
+
+		lSession := lStored.Session
+		for _, lToken := range pStore.refreshTokensMap {
+			if lToken.FamilyID == lStored.FamilyID && !lToken.Used && !lToken.Revoked && lToken.ExpiresAt.After(lSession.ExpiresAt) {
+				lSession.ExpiresAt = lToken.ExpiresAt
+			}
+		}
+		if !lSession.ExpiresAt.After(lNow) {
+			continue
+		}
+		lSessionsArr = append(lSessionsArr, lSession)
+	}
+
+	// Memory sessions are always created with LastSeenAt set.
+	sort.Slice(lSessionsArr, func(i, j int) bool {
+		lLeft, lRight := *lSessionsArr[i].LastSeenAt, *lSessionsArr[j].LastSeenAt
+		if !lLeft.Equal(lRight) {
+			return lLeft.After(lRight)
+		}
+		return lSessionsArr[i].ID > lSessionsArr[j].ID
+	})
+	return lSessionsArr, nil
+}
+
+func (pStore *memoryStore) DeleteSession(pTokenHash string) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lSession, lOk := pStore.sessionsMap[pTokenHash]
+	if !lOk {
+		return nil
+	}
+	pStore.deleteSession(pTokenHash, lSession)
+	return nil
+}
+
+func (pStore *memoryStore) DeleteSessionByID(pUserID int, pSessionID int) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	for lHash, lSession := range pStore.sessionsMap {
+		if lSession.ID == pSessionID && lSession.UserID == pUserID {
+			pStore.deleteSession(lHash, lSession)
+			return nil
+		}
+	}
+	return ErrSessionNotFound
+}
+
+func (pStore *memoryStore) DeleteOtherSessions(pUserID int, pKeepSessionID int) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lKeepFamilyID := ""
+	lDeleted := 0
+	for lHash, lSession := range pStore.sessionsMap {
+		if lSession.UserID != pUserID {
+			continue
+		}
+		if lSession.ID == pKeepSessionID {
+			lKeepFamilyID = lSession.FamilyID
+			continue
+		}
+		delete(pStore.sessionsMap, lHash)
+		lDeleted++
+	}
+
+	for _, lToken := range pStore.refreshTokensMap {
+		if lToken.UserID == pUserID && (lKeepFamilyID == "" || lToken.FamilyID != lKeepFamilyID) {
+			lToken.Revoked = true
+		}
+	}
+	return lDeleted, nil
+}
+
+// deleteSession removes one session and revokes the rest of its family. It
+// must be called with mu held.
+func (pStore *memoryStore) deleteSession(pTokenHash string, pSession memorySession) {
+	delete(pStore.sessionsMap, pTokenHash)
+	if pSession.FamilyID != "" {
+		pStore.revokeFamily(pSession.FamilyID)
+	}
+}
+
+// revokeFamily must be called with mu held.
+func (pStore *memoryStore) revokeFamily(pFamilyID string) {
+	for _, lToken := range pStore.refreshTokensMap {
+		if lToken.FamilyID == pFamilyID {
+			lToken.Revoked = true
+		}
+	}
+	pStore.deleteFamilySessions(pFamilyID)
+}
+
+// deleteFamilySessions must be called with mu held.
+func (pStore *memoryStore) deleteFamilySessions(pFamilyID string) {
+	for lToken, lSession := range pStore.sessionsMap {
+		if lSession.FamilyID == pFamilyID {
+			delete(pStore.sessionsMap, lToken)
+		}
+	}
+}
+
+func (pStore *memoryStore) CreateRefreshToken(pUserID int, pFamilyID string, pTokenHash string, pExpiresAt time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return ErrUserNotFound
+	}
+	pStore.lastRefreshID++
+	pStore.refreshTokensMap[pTokenHash] = &memoryRefreshToken{
+		RefreshToken: RefreshToken{ID: pStore.lastRefreshID, UserID: pUserID, FamilyID: pFamilyID, ExpiresAt: pExpiresAt},
+	}
+	return nil
+}
+
+func (pStore *memoryStore) RotateRefreshToken(pTokenHash string, pNewHash string, pNewExpiresAt time.Time) (*RefreshToken, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lToken, lOk := pStore.refreshTokensMap[pTokenHash]
+	if !lOk {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if lToken.Used || lToken.Revoked {
+		pStore.revokeFamily(lToken.FamilyID)
+		return nil, ErrRefreshTokenReused
+	}
+	if !lToken.ExpiresAt.After(time.Now()) {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	lToken.Used = true
+	pStore.lastRefreshID++
+	pStore.refreshTokensMap[pNewHash] = &memoryRefreshToken{
+		RefreshToken: RefreshToken{ID: pStore.lastRefreshID, UserID: lToken.UserID, FamilyID: lToken.FamilyID, ExpiresAt: pNewExpiresAt},
+	}
+
+	lCopy := lToken.RefreshToken
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) DeleteExpiredSessions(pNow time.Time, pLimit int) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lDeleted := 0
+	for lHash, lSession := range pStore.sessionsMap {
+		if lDeleted == pLimit {
+			break
+		}
+		if lSession.ExpiresAt.After(pNow) || pStore.familyRefreshable(lSession.FamilyID, pNow) {
+			continue
+		}
+		delete(pStore.sessionsMap, lHash)
+		lDeleted++
+	}
+	return lDeleted, nil
+}
+
+// familyRefreshable reports whether pFamilyID holds a refresh token that can
+// still be used at pNow. It must be called with mu held.
+func (pStore *memoryStore) familyRefreshable(pFamilyID string, pNow time.Time) bool {
+	for _, lToken := range pStore.refreshTokensMap {
+		if lToken.FamilyID == pFamilyID && !lToken.Used && !lToken.Revoked && lToken.ExpiresAt.After(pNow) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pStore *memoryStore) DeleteExpiredRefreshTokens(pNow time.Time, pLimit int) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lDeleted := 0
+	for lHash, lToken := range pStore.refreshTokensMap {
+		if lDeleted == pLimit {
+			break
+		}
+		if lToken.ExpiresAt.After(pNow) {
+			continue
+		}
+		delete(pStore.refreshTokensMap, lHash)
+		lDeleted++
+	}
+	return lDeleted, nil
+}
+
+func (pStore *memoryStore) WithTryLock(pKey int64, pFn func() error) (bool, error) {
+	pStore.mu.Lock()
+	if pStore.locksMap[pKey] {
+		pStore.mu.Unlock()
+		return false, nil
+	}
+	pStore.locksMap[pKey] = true
+	pStore.mu.Unlock()
+
+	defer func() {
+		pStore.mu.Lock()
+		delete(pStore.locksMap, pKey)
+		pStore.mu.Unlock()
+	}()
+	return true, pFn()
+}
+
+func (pStore *memoryStore) RecordLoginFailure(pUsername string, pIP string, pAttemptedAt time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	pStore.loginAttemptsArr = append(pStore.loginAttemptsArr, memoryLoginAttempt{Username: pUsername, IP: pIP, AttemptedAt: pAttemptedAt})
+	return nil
+}
+
+func (pStore *memoryStore) AddLoginFailure(pKey string, pNow time.Time, pWindow time.Duration) (int, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lThrottle, lOk := pStore.loginThrottlesMap[pKey]
+	if !lOk {
+		lThrottle = &memoryLoginThrottle{}
+		pStore.loginThrottlesMap[pKey] = lThrottle
+	}
+	if !lThrottle.LastFailureAt.After(pNow.Add(-pWindow)) {
+		lThrottle.Failures = 0
+	}
+	lThrottle.Failures++
+	lThrottle.LastFailureAt = pNow
+	return lThrottle.Failures, nil
+}
+
+func (pStore *memoryStore) BlockLogin(pKey string, pUntil time.Time) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if lThrottle, lOk := pStore.loginThrottlesMap[pKey]; lOk {
+		lThrottle.BlockedUntil = pUntil
+	}
+	return nil
+}
+
+func (pStore *memoryStore) LoginBlockedUntil(pKeysArr []string, pNow time.Time) (time.Time, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	var lUntil time.Time
+	for _, lKey := range pKeysArr {
+		lThrottle, lOk := pStore.loginThrottlesMap[lKey]
+		if lOk && lThrottle.BlockedUntil.After(pNow) && lThrottle.BlockedUntil.After(lUntil) {
+			lUntil = lThrottle.BlockedUntil
+		}
+	}
+	return lUntil, nil
+}
+
+func (pStore *memoryStore) ClearLoginFailures(pKey string) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	delete(pStore.loginThrottlesMap, pKey)
+	return nil
+}
+
+func (pStore *memoryStore) CreateTodo(pUserID int, pFields TodoFields) (*Todo, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	if _, lOk := pStore.usersMap[pUserID]; !lOk {
+		return nil, ErrUserNotFound
+	}
+
+	pStore.lastTodoID++
+	lTodo := &Todo{
+		ID:        pStore.lastTodoID,
+		UserID:    pUserID,
+		Title:     pFields.Title,
+		Content:   pFields.Content,
+		Completed: pFields.Completed,
+		DueAt:     memoryTimePtr(pFields.DueAt),
+		RemindAt:  memoryTimePtr(pFields.RemindAt),
+		Version:   1,
+		CreatedAt: memoryTimestamp(time.Now()),
+	}
+	pStore.todosMap[lTodo.ID] = lTodo
+
+	lCopy := *lTodo
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) ListTodos(pUserID int, pQuery TodoQuery) ([]Todo, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lTitle := strings.ToLower(pQuery.TitleContains)
+	lTodosArr := []Todo{}
+	for _, lTodo := range pStore.todosMap {
+		if lTodo.UserID != pUserID {
+			continue
+		}
+		if pQuery.Completed != nil && lTodo.Completed != *pQuery.Completed {
+			continue
+		}
+		lCreatedAt, _ := time.Parse(time.RFC3339Nano, lTodo.CreatedAt)
+		if pQuery.CreatedAfter != nil && lCreatedAt.Before(*pQuery.CreatedAfter) {
+			continue
+		}
+		if pQuery.CreatedBefore != nil && !lCreatedAt.Before(*pQuery.CreatedBefore) {
+			continue
+		}
+		if lTitle != "" && !strings.Contains(strings.ToLower(lTodo.Title), lTitle) {
+			continue
+		}
+		if (pQuery.DueAfter != nil || pQuery.DueBefore != nil) && lTodo.DueAt == nil {
+			continue
+		}
+		if pQuery.DueAfter != nil && lTodo.DueAt.Before(*pQuery.DueAfter) {
+			continue
+		}
+		if pQuery.DueBefore != nil && !lTodo.DueAt.Before(*pQuery.DueBefore) {
+			continue
+		}
+		if pQuery.After != nil {
+			lCmp := compareTodoSortKey(*lTodo, pQuery.SortBy, pQuery.After.Value, pQuery.After.ID)
+			if (pQuery.Descending && lCmp >= 0) || (!pQuery.Descending && lCmp <= 0) {
+				continue
+			}
+		}
+		lTodosArr = append(lTodosArr, *lTodo)
+	}
+
+	sort.Slice(lTodosArr, func(i, j int) bool {
+		lCmp := compareTodoSortKey(lTodosArr[i], pQuery.SortBy, TodoSortValue(lTodosArr[j], pQuery.SortBy), lTodosArr[j].ID)
+		if pQuery.Descending {
+			return lCmp > 0
+		}
+		return lCmp < 0
+	})
+
+	if pQuery.Limit > 0 && len(lTodosArr) > pQuery.Limit {
+		lTodosArr = lTodosArr[:pQuery.Limit]
+	}
+	return lTodosArr, nil
+}
+
+// compareTodoSortKey orders pTodo against the (pValue, pID) sort key the same
+// way the Postgres store's ORDER BY <column>, id does. Titles compare by byte,
+// which Postgres matches with COLLATE "C".
+func compareTodoSortKey(pTodo Todo, pSortBy string, pValue string, pID int) int {
+	lCmp := 0
+	switch pSortBy {
+	case TodoSortCreatedAt:
+		lLeft, _ := time.Parse(time.RFC3339Nano, pTodo.CreatedAt)
+		lRight, _ := time.Parse(time.RFC3339Nano, pValue)
+		if lLeft.Before(lRight) {
+			lCmp = -1
+		} else if lLeft.After(lRight) {
+			lCmp = 1
+		}
+	default:
+		lCmp = strings.Compare(TodoSortValue(pTodo, pSortBy), pValue)
+	}
+
+	if lCmp != 0 {
+		return lCmp
+	}
+	if pTodo.ID < pID {
+		return -1
+	}
+	if pTodo.ID > pID {
+		return 1
+	}
+	return 0
+}
+
+func (pStore *memoryStore) GetTodo(pUserID int, pTodoID int) (*Todo, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lTodo, lOk := pStore.todosMap[pTodoID]
+	if !lOk || lTodo.UserID != pUserID {
+		return nil, ErrTodoNotFound
+	}
+	lCopy := *lTodo
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) UpdateTodo(pUserID int, pTodoID int, pFields TodoFields, pIfMatch []int) (*Todo, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lTodo, lOk := pStore.todosMap[pTodoID]
+	if !lOk || lTodo.UserID != pUserID {
+		return nil, ErrTodoNotFound
+	}
+	if !versionMatches(lTodo.Version, pIfMatch) {
+		return nil, ErrPreconditionFailed
+	}
+
+	lRemindAt := memoryTimePtr(pFields.RemindAt)
+	if !sameTimePtr(lTodo.RemindAt, lRemindAt) {
+		lTodo.ReminderFiredAt = nil
+	}
+
+	lTodo.Title = pFields.Title
+	lTodo.Content = pFields.Content
+	lTodo.Completed = pFields.Completed
+	lTodo.DueAt = memoryTimePtr(pFields.DueAt)
+	lTodo.RemindAt = lRemindAt
+	lTodo.Version++
+
+	lCopy := *lTodo
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) PatchTodo(pUserID int, pTodoID int, pPatch TodoPatch, pIfMatch []int) (*Todo, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lTodo, lOk := pStore.todosMap[pTodoID]
+	if !lOk || lTodo.UserID != pUserID {
+		return nil, ErrTodoNotFound
+	}
+	if !versionMatches(lTodo.Version, pIfMatch) {
+		return nil, ErrPreconditionFailed
+	}
+	if pPatch.IsEmpty() {
+		lCopy := *lTodo
+		return &lCopy, nil
+	}
+
+	if pPatch.Title != nil {
+		lTodo.Title = *pPatch.Title
+	}
+	if pPatch.Content != nil {
+		lTodo.Content = *pPatch.Content
+	}
+	if pPatch.Completed != nil {
+		lTodo.Completed = *pPatch.Completed
+	}
+	if pPatch.DueAt.Set {
+		lTodo.DueAt = memoryTimePtr(pPatch.DueAt.Value)
+	}
+	if pPatch.RemindAt.Set {
+		lRemindAt := memoryTimePtr(pPatch.RemindAt.Value)
+		if !sameTimePtr(lTodo.RemindAt, lRemindAt) {
+			lTodo.ReminderFiredAt = nil
+		}
+		lTodo.RemindAt = lRemindAt
+	}
+	lTodo.Version++
+
+	lCopy := *lTodo
+	return &lCopy, nil
+}
+
+func (pStore *memoryStore) DeleteTodo(pUserID int, pTodoID int, pIfMatch []int) error {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lTodo, lOk := pStore.todosMap[pTodoID]
+	if !lOk || lTodo.UserID != pUserID {
+		return ErrTodoNotFound
+	}
+	if !versionMatches(lTodo.Version, pIfMatch) {
+		return ErrPreconditionFailed
+	}
+	delete(pStore.todosMap, pTodoID)
+	return nil
+}
+
+// SearchTodos approximates the Postgres full-text search: every query term
+// must appear in the title or content (case-insensitively), and title hits
+// weigh more than content hits.
+func (pStore *memoryStore) SearchTodos(pUserID int, pQuery string, pLimit int) ([]TodoSearchResult, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lTermsArr := searchTerms(pQuery)
+	lResultsArr := []TodoSearchResult{}
+	if len(lTermsArr) == 0 {
+		return lResultsArr, nil
+	}
+
+	for _, lTodo := range pStore.todosMap {
+		if lTodo.UserID != pUserID {
+			continue
+		}
+
+		lTitle := strings.ToLower(lTodo.Title)
+		lContent := strings.ToLower(lTodo.Content)
+		lRank := 0.0
+		lMatched := true
+		for _, lTerm := range lTermsArr {
+			lTitleHits := strings.Count(lTitle, lTerm)
+			lContentHits := strings.Count(lContent, lTerm)
+			if lTitleHits+lContentHits == 0 {
+				lMatched = false
+				break
+			}
+			lRank += float64(lTitleHits) + 0.4*float64(lContentHits)
+		}
+		if !lMatched {
+			continue
+		}
+
+		lResultsArr = append(lResultsArr, TodoSearchResult{
+			Todo:           *lTodo,
+			Rank:           lRank,
+			TitleSnippet:   highlightTerms(lTodo.Title, lTermsArr),
+			ContentSnippet: highlightTerms(lTodo.Content, lTermsArr),
+		})
+	}
+
+	sort.Slice(lResultsArr, func(i, j int) bool {
+		if lResultsArr[i].Rank != lResultsArr[j].Rank {
+			return lResultsArr[i].Rank > lResultsArr[j].Rank
+		}
+		return lResultsArr[i].ID > lResultsArr[j].ID
+	})
+
+	if len(lResultsArr) > pLimit {
+		lResultsArr = lResultsArr[:pLimit]
+	}
+	return lResultsArr, nil
+}
+
+func searchTerms(pQuery string) []string {
+	return strings.FieldsFunc(strings.ToLower(pQuery), func(pRune rune) bool {
+		return !unicode.IsLetter(pRune) && !unicode.IsDigit(pRune)
+	})
+}
+
+// highlightTerms HTML-escapes pText and wraps every case-insensitive
+// occurrence of pTermsArr in <mark></mark>, mirroring ts_headline's markers.
+func highlightTerms(pText string, pTermsArr []string) string {
+	lLower := strings.ToLower(pText)
+	if len(lLower) != len(pText) {
+		// Case folding changed byte offsets; fall back to the plain text.
+		return html.EscapeString(pText)
+	}
+
+	lMarkedArr := make([]bool, len(pText))
+	for _, lTerm := range pTermsArr {
+		for lStart := 0; ; {
+			lIdx := strings.Index(lLower[lStart:], lTerm)
+			if lIdx < 0 {
+				break
+			}
+			for lPos := lStart + lIdx; lPos < lStart+lIdx+len(lTerm); lPos++ {
+				lMarkedArr[lPos] = true
+			}
+			lStart += lIdx + len(lTerm)
+		}
+	}
+
+	var lBuilder strings.Builder
+	for lStart := 0; lStart < len(pText); {
+		lEnd := lStart + 1
+		for lEnd < len(pText) && lMarkedArr[lEnd] == lMarkedArr[lStart] {
+			lEnd++
+		}
+		if lMarkedArr[lStart] {
+			lBuilder.WriteString("<mark>" + html.EscapeString(pText[lStart:lEnd]) + "</mark>")
+		} else {
+			lBuilder.WriteString(html.EscapeString(pText[lStart:lEnd]))
+		}
+		lStart = lEnd
+	}
+	return lBuilder.String()
+}
+
+func (pStore *memoryStore) ClaimDueReminders(pNow time.Time, pLimit int) ([]Todo, error) {
+	pStore.mu.Lock()
+	defer pStore.mu.Unlock()
+
+	lDueArr := []*Todo{}
+	for _, lTodo := range pStore.todosMap {
+		if lTodo.RemindAt != nil && !lTodo.RemindAt.After(pNow) && lTodo.ReminderFiredAt == nil && !lTodo.Completed {
+			lDueArr = append(lDueArr, lTodo)
+		}
+	}
+	sort.Slice(lDueArr, func(i, j int) bool {
+		return lDueArr[i].RemindAt.Before(*lDueArr[j].RemindAt)
+	})
+	if len(lDueArr) > pLimit {
+		lDueArr = lDueArr[:pLimit]
+	}
+
+	lFiredAt := pNow.UTC()
+	lTodosArr := make([]Todo, 0, len(lDueArr))
+	for _, lTodo := range lDueArr {
+		lTodo.ReminderFiredAt = &lFiredAt
+		lTodosArr = append(lTodosArr, *lTodo)
+	}
+	return lTodosArr, nil
+}
+
+func sameTimePtr(pLeft *time.Time, pRight *time.Time) bool {
+	if pLeft == nil || pRight == nil {
+		return pLeft == pRight
+	}
+	return pLeft.Equal(*pRight)
+}
@@ -0,0 +1,1082 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresStore implements the Store repositories on top of the tables created
+// by MigrationsArr.
+type postgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(pDB *sql.DB) *Store {
+	lStore := &postgresStore{db: pDB}
+	return &Store{
+		Users:    lStore,
+		Sessions: lStore,
+		Todos:    lStore,
+		Locker:   lStore,
+
+		LoginAttempts: lStore,
+		UserTokens:    lStore,
+		TwoFactor:     lStore,
+		Identities:    lStore,
+		APIKeys:       lStore,
+	}
+}
+
+const userColumns = "id, username, email, password, email_verified_at, totp_secret, totp_enabled_at, role, disabled_at"
+
+const todoColumns = "id, user_id, title, content, completed, due_at, remind_at, reminder_fired_at, version, created_at"
+
+// likeEscaper escapes user input for use inside a LIKE/ILIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+type rowScanner interface {
+	Scan(pDestArr ...interface{}) error
+}
+
+// scanUser scans the userColumns of pRow, followed by any pExtraArr columns
+// the query selects after them.
+func scanUser(pRow rowScanner, pExtraArr ...interface{}) (*User, error) {
+	var lUser User
+	var lEmailVerifiedAt, lTwoFactorEnabledAt, lDisabledAt sql.NullTime
+	var lTOTPSecret sql.NullString
+
+	lDestArr := []interface{}{&lUser.ID, &lUser.Username, &lUser.Email, &lUser.Password, &lEmailVerifiedAt, &lTOTPSecret, &lTwoFactorEnabledAt, &lUser.Role, &lDisabledAt}
+	lErr := pRow.Scan(append(lDestArr, pExtraArr...)...)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lUser.EmailVerifiedAt = nullTimePtr(lEmailVerifiedAt)
+	lUser.TOTPSecret = lTOTPSecret.String
+	lUser.TwoFactorEnabledAt = nullTimePtr(lTwoFactorEnabledAt)
+	lUser.DisabledAt = nullTimePtr(lDisabledAt)
+	return &lUser, nil
+}
+
+// scanTodo scans the todoColumns of pRow, followed by any pExtraArr columns
+// the query selects after them.
+func scanTodo(pRow rowScanner, pExtraArr ...interface{}) (*Todo, error) {
+	var lTodo Todo
+	var lContent sql.NullString
+	var lDueAt, lRemindAt, lReminderFiredAt sql.NullTime
+
+	lDestArr := []interface{}{&lTodo.ID, &lTodo.UserID, &lTodo.Title, &lContent, &lTodo.Completed, &lDueAt, &lRemindAt, &lReminderFiredAt, &lTodo.Version, &lTodo.CreatedAt}
+	lErr := pRow.Scan(append(lDestArr, pExtraArr...)...)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lTodo.Content = lContent.String
+	lTodo.DueAt = nullTimePtr(lDueAt)
+	lTodo.RemindAt = nullTimePtr(lRemindAt)
+	lTodo.ReminderFiredAt = nullTimePtr(lReminderFiredAt)
+	return &lTodo, nil
+}
+
+func nullTimePtr(pTime sql.NullTime) *time.Time {
+	if !pTime.Valid {
+		return nil
+	}
+	lTime := pTime.Time.UTC()
+	return &lTime
+}
+
+// utcTimePtr normalises an optional timestamp before it is written to a
+// TIMESTAMP (without time zone) column, which would otherwise drop the offset.
+func utcTimePtr(pTime *time.Time) interface{} {
+	if pTime == nil {
+		return nil
+	}
+	return pTime.UTC()
+}
+
+func (pStore *postgresStore) CreateUser(pUsername string, pEmail string, pPasswordHash string) (*User, error) {
+	lQuery := "INSERT INTO users (username, email, password) VALUES ($1, $2, $3) RETURNING " + userColumns
+
+	lUser, lErr := scanUser(pStore.db.QueryRow(lQuery, pUsername, pEmail, pPasswordHash))
+	if lErr != nil {
+		return nil, userWriteError(lErr)
+	}
+	lUser.Password = ""
+	return lUser, nil
+}
+
+// pqUniqueViolation is the SQLSTATE Postgres reports for a UNIQUE conflict.
+const pqUniqueViolation = "23505"
+
+// userWriteError turns a unique violation on the users table into
+// ErrUsernameTaken or ErrEmailTaken, telling them apart by constraint name.
+func userWriteError(pErr error) error {
+	lPqErr, lOk := pErr.(*pq.Error)
+	if !lOk || lPqErr.Code != pqUniqueViolation {
+		return pErr
+	}
+
+	switch {
+	case strings.Contains(lPqErr.Constraint, "username"):
+		return ErrUsernameTaken
+	case strings.Contains(lPqErr.Constraint, "email"):
+		return ErrEmailTaken
+	}
+	return pErr
+}
+
+func (pStore *postgresStore) GetUserByID(pUserID int) (*User, error) {
+	return pStore.getUser("SELECT "+userColumns+" FROM users WHERE id = $1", pUserID)
+}
+
+func (pStore *postgresStore) GetUserByUsername(pUsername string) (*User, error) {
+	return pStore.getUser("SELECT "+userColumns+" FROM users WHERE lower(username) = lower($1)", pUsername)
+}
+
+func (pStore *postgresStore) GetUserByEmail(pEmail string) (*User, error) {
+	return pStore.getUser("SELECT "+userColumns+" FROM users WHERE lower(email) = lower($1)", pEmail)
+}
+
+func (pStore *postgresStore) getUser(pQuery string, pArg interface{}) (*User, error) {
+	lUser, lErr := scanUser(pStore.db.QueryRow(pQuery, pArg))
+	if lErr == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	return lUser, lErr
+}
+
+func (pStore *postgresStore) MarkEmailVerified(pUserID int, pVerifiedAt time.Time) error {
+	lQuery := "UPDATE users SET email_verified_at = $2 WHERE id = $1 AND email_verified_at IS NULL"
+
+	_, lErr := pStore.db.Exec(lQuery, pUserID, pVerifiedAt.UTC())
+	return lErr
+}
+
+func (pStore *postgresStore) UpdatePassword(pUserID int, pPasswordHash string) error {
+	return pStore.execUser("UPDATE users SET password = $2 WHERE id = $1", pUserID, pPasswordHash)
+}
+
+func (pStore *postgresStore) UpdateUserProfile(pUserID int, pUsername string, pEmail string) (*User, error) {
+	// SET expressions see the row as it was, so the CASE compares against the
+	// old email.
+	lQuery := `UPDATE users SET username = $2, email = $3,
+			email_verified_at = CASE WHEN lower(email) = lower($3) THEN email_verified_at END
+		WHERE id = $1 RETURNING ` + userColumns
+
+	lUser, lErr := scanUser(pStore.db.QueryRow(lQuery, pUserID, pUsername, pEmail))
+	if lErr == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if lErr != nil {
+		return nil, userWriteError(lErr)
+	}
+	lUser.Password = ""
+	return lUser, nil
+}
+
+// DeleteUser relies on the ON DELETE CASCADE of every table that references
+// users.
+func (pStore *postgresStore) DeleteUser(pUserID int) error {
+	return pStore.execUser("DELETE FROM users WHERE id = $1", pUserID)
+}
+
+func (pStore *postgresStore) ListUsers(pSearch string, pAfterID int, pLimit int) ([]User, error) {
+	lWhere := "id > $1"
+	lArgsArr := []interface{}{pAfterID, pLimit}
+	if pSearch != "" {
+		lWhere += " AND (username ILIKE $3 OR email ILIKE $3)"
+		lArgsArr = append(lArgsArr, "%"+likeEscaper.Replace(pSearch)+"%")
+	}
+	lQuery := "SELECT " + userColumns + " FROM users WHERE " + lWhere + " ORDER BY id LIMIT $2"
+
+	lRows, lErr := pStore.db.Query(lQuery, lArgsArr...)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lUsersArr := []User{}
+	for lRows.Next() {
+		lUser, lErr := scanUser(lRows)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lUser.Password = ""
+		lUsersArr = append(lUsersArr, *lUser)
+	}
+	return lUsersArr, lRows.Err()
+}
+
+func (pStore *postgresStore) SetUserRole(pUserID int, pRole string) error {
+	return pStore.execUser("UPDATE users SET role = $2 WHERE id = $1", pUserID, pRole)
+}
+
+func (pStore *postgresStore) SetUserDisabled(pUserID int, pDisabledAt *time.Time) error {
+	return pStore.execUser("UPDATE users SET disabled_at = $2 WHERE id = $1", pUserID, utcTimePtr(pDisabledAt))
+}
+
+// execUser runs pQuery, which changes at most one user, and returns
+// ErrUserNotFound when it changed none.
+func (pStore *postgresStore) execUser(pQuery string, pArgs ...interface{}) error {
+	lChanged, lErr := execCount(pStore.db, pQuery, pArgs...)
+	if lErr != nil {
+		return lErr
+	}
+	if lChanged == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (pStore *postgresStore) CreateUserToken(pUserID int, pPurpose string, pTokenHash string, pExpiresAt time.Time) error {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return lErr
+	}
+	defer lTx.Rollback()
+
+	_, lErr = lTx.Exec("DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL", pUserID, pPurpose)
+	if lErr != nil {
+		return lErr
+	}
+
+	lQuery := "INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)"
+	_, lErr = lTx.Exec(lQuery, pUserID, pPurpose, pTokenHash, pExpiresAt.UTC())
+	if lErr != nil {
+		return lErr
+	}
+	return lTx.Commit()
+}
+
+func (pStore *postgresStore) ConsumeUserToken(pPurpose string, pTokenHash string, pNow time.Time) (int, error) {
+	lQuery := `UPDATE user_tokens SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id`
+
+	var lUserID int
+	lErr := pStore.db.QueryRow(lQuery, pTokenHash, pPurpose, pNow.UTC()).Scan(&lUserID)
+	if lErr == sql.ErrNoRows {
+		return 0, ErrUserTokenNotFound
+	}
+	return lUserID, lErr
+}
+
+func (pStore *postgresStore) ResetPasswordWithToken(pTokenHash string, pPasswordHash string, pNow time.Time) (int, error) {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return 0, lErr
+	}
+	defer lTx.Rollback()
+
+	lQuery := `UPDATE user_tokens SET used_at = $3
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING user_id`
+
+	var lUserID int
+	lErr = lTx.QueryRow(lQuery, pTokenHash, UserTokenPasswordReset, pNow.UTC()).Scan(&lUserID)
+	if lErr == sql.ErrNoRows {
+		return 0, ErrUserTokenNotFound
+	}
+	if lErr != nil {
+		return 0, lErr
+	}
+
+	_, lErr = lTx.Exec("UPDATE users SET password = $2 WHERE id = $1", lUserID, pPasswordHash)
+	if lErr != nil {
+		return 0, lErr
+	}
+	return lUserID, lTx.Commit()
+}
+
+func (pStore *postgresStore) GetUserToken(pPurpose string, pTokenHash string, pNow time.Time) (int, error) {
+	lQuery := "SELECT user_id FROM user_tokens WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3"
+
+	var lUserID int
+	lErr := pStore.db.QueryRow(lQuery, pTokenHash, pPurpose, pNow.UTC()).Scan(&lUserID)
+	if lErr == sql.ErrNoRows {
+		return 0, ErrUserTokenNotFound
+	}
+	return lUserID, lErr
+}
+
+func (pStore *postgresStore) SetPendingTOTPSecret(pUserID int, pSecret string) error {
+	lQuery := "UPDATE users SET totp_secret = $2, totp_last_step = NULL WHERE id = $1 AND totp_enabled_at IS NULL"
+
+	lUpdated, lErr := execCount(pStore.db, lQuery, pUserID, pSecret)
+	if lErr != nil {
+		return lErr
+	}
+	if lUpdated == 0 {
+		return ErrTwoFactorAlreadyEnabled
+	}
+	return nil
+}
+
+func (pStore *postgresStore) EnableTOTP(pUserID int, pSecret string, pStep int64, pRecoveryHashesArr []string, pEnabledAt time.Time) error {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return lErr
+	}
+	defer lTx.Rollback()
+
+	lQuery := `UPDATE users SET totp_enabled_at = $3, totp_last_step = $4
+		WHERE id = $1 AND totp_secret = $2 AND totp_enabled_at IS NULL`
+	lResult, lErr := lTx.Exec(lQuery, pUserID, pSecret, pEnabledAt.UTC(), pStep)
+	if lErr != nil {
+		return lErr
+	}
+	lUpdated, lErr := lResult.RowsAffected()
+	if lErr != nil {
+		return lErr
+	}
+	if lUpdated == 0 {
+		return ErrTwoFactorNotPending
+	}
+
+	_, lErr = lTx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", pUserID)
+	if lErr != nil {
+		return lErr
+	}
+	for _, lHash := range pRecoveryHashesArr {
+		_, lErr = lTx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", pUserID, lHash)
+		if lErr != nil {
+			return lErr
+		}
+	}
+	return lTx.Commit()
+}
+
+func (pStore *postgresStore) DisableTOTP(pUserID int) error {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return lErr
+	}
+	defer lTx.Rollback()
+
+	lQuery := "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL WHERE id = $1"
+	_, lErr = lTx.Exec(lQuery, pUserID)
+	if lErr != nil {
+		return lErr
+	}
+
+	_, lErr = lTx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", pUserID)
+	if lErr != nil {
+		return lErr
+	}
+	return lTx.Commit()
+}
+
+func (pStore *postgresStore) UseTOTPStep(pUserID int, pStep int64) (bool, error) {
+	lQuery := `UPDATE users SET totp_last_step = $2
+		WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)`
+
+	lUpdated, lErr := execCount(pStore.db, lQuery, pUserID, pStep)
+	return lUpdated > 0, lErr
+}
+
+func (pStore *postgresStore) ConsumeRecoveryCode(pUserID int, pCodeHash string, pNow time.Time) error {
+	lQuery := "UPDATE recovery_codes SET used_at = $3 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL"
+
+	lUpdated, lErr := execCount(pStore.db, lQuery, pUserID, pCodeHash, pNow.UTC())
+	if lErr != nil {
+		return lErr
+	}
+	if lUpdated == 0 {
+		return ErrRecoveryCodeNotFound
+	}
+	return nil
+}
+
+func (pStore *postgresStore) GetUserIdentity(pProvider string, pSubject string) (int, error) {
+	var lUserID int
+	lErr := pStore.db.QueryRow("SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2", pProvider, pSubject).Scan(&lUserID)
+	if lErr == sql.ErrNoRows {
+		return 0, ErrIdentityNotFound
+	}
+	return lUserID, lErr
+}
+
+func (pStore *postgresStore) CreateUserIdentity(pUserID int, pProvider string, pSubject string, pEmail string) error {
+	lQuery := "INSERT INTO user_identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)"
+
+	_, lErr := pStore.db.Exec(lQuery, pUserID, pProvider, pSubject, pEmail)
+	return lErr
+}
+
+func (pStore *postgresStore) CreateOIDCState(pStateHash string, pState OIDCState, pExpiresAt time.Time) error {
+	_, lErr := pStore.db.Exec("DELETE FROM oidc_states WHERE expires_at <= $1", time.Now().UTC())
+	if lErr != nil {
+		return lErr
+	}
+
+	lQuery := "INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, expires_at) VALUES ($1, $2, $3, $4, $5)"
+	_, lErr = pStore.db.Exec(lQuery, pStateHash, pState.Provider, pState.Nonce, pState.CodeVerifier, pExpiresAt.UTC())
+	return lErr
+}
+
+func (pStore *postgresStore) ConsumeOIDCState(pStateHash string, pNow time.Time) (*OIDCState, error) {
+	lQuery := `DELETE FROM oidc_states WHERE state_hash = $1 AND expires_at > $2
+		RETURNING provider, nonce, code_verifier`
+
+	var lState OIDCState
+	lErr := pStore.db.QueryRow(lQuery, pStateHash, pNow.UTC()).Scan(&lState.Provider, &lState.Nonce, &lState.CodeVerifier)
+	if lErr == sql.ErrNoRows {
+		return nil, ErrOIDCStateNotFound
+	}
+	if lErr != nil {
+		return nil, lErr
+	}
+	return &lState, nil
+}
+
+const apiKeyColumns = "id, name, prefix, scopes, created_at, expires_at, last_used_at"
+
+func scanAPIKey(pRow rowScanner) (*APIKey, error) {
+	var lKey APIKey
+	var lExpiresAt, lLastUsedAt sql.NullTime
+
+	lErr := pRow.Scan(&lKey.ID, &lKey.Name, &lKey.Prefix, pq.Array(&lKey.ScopesArr), &lKey.CreatedAt, &lExpiresAt, &lLastUsedAt)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lKey.ExpiresAt = nullTimePtr(lExpiresAt)
+	lKey.LastUsedAt = nullTimePtr(lLastUsedAt)
+	return &lKey, nil
+}
+
+func (pStore *postgresStore) CreateAPIKey(pUserID int, pKey APIKey, pKeyHash string) (*APIKey, error) {
+	lQuery := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + apiKeyColumns
+
+	return scanAPIKey(pStore.db.QueryRow(lQuery, pUserID, pKey.Name, pKey.Prefix, pKeyHash, pq.Array(pKey.ScopesArr), utcTimePtr(pKey.ExpiresAt)))
+}
+
+func (pStore *postgresStore) ListAPIKeys(pUserID int) ([]APIKey, error) {
+	lRows, lErr := pStore.db.Query("SELECT "+apiKeyColumns+" FROM api_keys WHERE user_id = $1 ORDER BY id DESC", pUserID)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lKeysArr := []APIKey{}
+	for lRows.Next() {
+		lKey, lErr := scanAPIKey(lRows)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lKeysArr = append(lKeysArr, *lKey)
+	}
+	return lKeysArr, lRows.Err()
+}
+
+func (pStore *postgresStore) DeleteAPIKey(pUserID int, pKeyID int) error {
+	lDeleted, lErr := execCount(pStore.db, "DELETE FROM api_keys WHERE id = $1 AND user_id = $2", pKeyID, pUserID)
+	if lErr != nil {
+		return lErr
+	}
+	if lDeleted == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (pStore *postgresStore) TouchAPIKey(pKeyHash string, pNow time.Time) (*User, *APIKey, error) {
+	lQuery := `WITH k AS (
+			UPDATE api_keys SET last_used_at = $2
+			WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > $2)
+			RETURNING id AS key_id, user_id AS key_user_id, name AS key_name, prefix AS key_prefix,
+				scopes AS key_scopes, created_at AS key_created_at, expires_at AS key_expires_at
+		)
+		SELECT ` + userColumns + `, k.key_id, k.key_name, k.key_prefix, k.key_scopes, k.key_created_at, k.key_expires_at
+		FROM k JOIN users ON users.id = k.key_user_id`
+
+	var lKey APIKey
+	var lExpiresAt sql.NullTime
+	lUser, lErr := scanUser(pStore.db.QueryRow(lQuery, pKeyHash, pNow.UTC()),
+		&lKey.ID, &lKey.Name, &lKey.Prefix, pq.Array(&lKey.ScopesArr), &lKey.CreatedAt, &lExpiresAt)
+	if lErr == sql.ErrNoRows {
+		return nil, nil, ErrAPIKeyNotFound
+	}
+	if lErr != nil {
+		return nil, nil, lErr
+	}
+
+	lKey.ExpiresAt = nullTimePtr(lExpiresAt)
+	lLastUsedAt := pNow.UTC()
+	lKey.LastUsedAt = &lLastUsedAt
+	lUser.Password = ""
+	return lUser, &lKey, nil
+}
+
+func (pStore *postgresStore) CreateSession(pUserID int, pFamilyID string, pTokenHash string, pExpiresAt time.Time, pClient SessionClient) error {
+	lQuery := `INSERT INTO sessions (user_id, family_id, token_hash, expires_at, ip, user_agent, created_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+		ON CONFLICT (family_id) DO UPDATE SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at,
+			ip = EXCLUDED.ip, user_agent = EXCLUDED.user_agent, last_seen_at = EXCLUDED.last_seen_at`
+
+	_, lErr := pStore.db.Exec(lQuery, pUserID, pFamilyID, pTokenHash, pExpiresAt.UTC(), pClient.IP, pClient.UserAgent, time.Now().UTC())
+	return lErr
+}
+
+func (pStore *postgresStore) TouchSession(pTokenHash string, pClient SessionClient) (*User, int, error) {
+	// The UPDATE runs even though the SELECT does not read it.
+	lQuery := `WITH s AS (
+			SELECT id AS session_id, user_id AS session_user_id FROM sessions
+			WHERE token_hash = $1 AND expires_at > $2
+		), touched AS (
+			UPDATE sessions SET last_seen_at = $2, ip = $3, user_agent = $4
+			WHERE token_hash = $1 AND expires_at > $2
+				AND (last_seen_at IS NULL OR last_seen_at < $5 OR ip IS DISTINCT FROM $3 OR user_agent IS DISTINCT FROM $4)
+		)
+		SELECT ` + userColumns + `, s.session_id FROM s JOIN users ON users.id = s.session_user_id`
+
+	lNow := time.Now().UTC()
+	var lSessionID int
+	lUser, lErr := scanUser(pStore.db.QueryRow(lQuery, pTokenHash, lNow, pClient.IP, pClient.UserAgent, lNow.Add(-SessionTouchInterval)), &lSessionID)
+	if lErr == sql.ErrNoRows {
+		return nil, 0, ErrSessionNotFound
+	}
+	if lErr != nil {
+		return nil, 0, lErr
+	}
+	lUser.Password = ""
+	return lUser, lSessionID, nil
+}
+
+func (pStore *postgresStore) ListSessions(pUserID int) ([]Session, error) {
+	// A session whose access token has lapsed is still listed while its
+	// family holds a live refresh token, and stays until that one expires.
+	lQuery := `SELECT s.id, s.ip, s.user_agent, s.created_at, s.last_seen_at, GREATEST(s.expires_at, r.expires_at)
+		FROM sessions s
+		LEFT JOIN refresh_tokens r ON r.family_id = s.family_id AND r.used_at IS NULL AND r.revoked_at IS NULL AND r.expires_at > $2
+		WHERE s.user_id = $1 AND (s.expires_at > $2 OR r.id IS NOT NULL)
+		ORDER BY s.last_seen_at DESC NULLS LAST, s.id DESC`
+
+	lRows, lErr := pStore.db.Query(lQuery, pUserID, time.Now().UTC())
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lSessionsArr := []Session{}
+	for lRows.Next() {
+		var lSession Session
+		var lIP, lUserAgent sql.NullString
+		var lLastSeenAt sql.NullTime
+		lErr = lRows.Scan(&lSession.ID, &lIP, &lUserAgent, &lSession.CreatedAt, &lLastSeenAt, &lSession.ExpiresAt)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lSession.IP = lIP.String
+		lSession.UserAgent = lUserAgent.String
+		lSession.LastSeenAt = nullTimePtr(lLastSeenAt)
+		lSessionsArr = append(lSessionsArr, lSession)
+	}
+	return lSessionsArr, lRows.Err()
+}
+
+func (pStore *postgresStore) DeleteSession(pTokenHash string) error {
+	lErr := pStore.deleteSessionWhere("token_hash = $1", pTokenHash)
+	if lErr == ErrSessionNotFound {
+		return nil
+	}
+	return lErr
+}
+
+func (pStore *postgresStore) DeleteSessionByID(pUserID int, pSessionID int) error {
+	return pStore.deleteSessionWhere("id = $1 AND user_id = $2", pSessionID, pUserID)
+}
+
+// deleteSessionWhere deletes the session matching pCondition and revokes the
+// rest of its family.
+func (pStore *postgresStore) deleteSessionWhere(pCondition string, pArgs ...interface{}) error {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return lErr
+	}
+	defer lTx.Rollback()
+
+	var lFamilyID sql.NullString
+	lErr = lTx.QueryRow("DELETE FROM sessions WHERE "+pCondition+" RETURNING family_id", pArgs...).Scan(&lFamilyID)
+	if lErr == sql.ErrNoRows {
+		return ErrSessionNotFound
+	}
+	if lErr != nil {
+		return lErr
+	}
+
+	if lFamilyID.Valid {
+		lErr = revokeFamily(lTx, lFamilyID.String)
+		if lErr != nil {
+			return lErr
+		}
+	}
+	return lTx.Commit()
+}
+
+func (pStore *postgresStore) DeleteOtherSessions(pUserID int, pKeepSessionID int) (int, error) {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return 0, lErr
+	}
+	defer lTx.Rollback()
+
+	lQuery := `UPDATE refresh_tokens SET revoked_at = $3
+		WHERE user_id = $1 AND revoked_at IS NULL
+		AND family_id IS DISTINCT FROM (SELECT family_id FROM sessions WHERE id = $2 AND user_id = $1)`
+	_, lErr = lTx.Exec(lQuery, pUserID, pKeepSessionID, time.Now().UTC())
+	if lErr != nil {
+		return 0, lErr
+	}
+
+	lResult, lErr := lTx.Exec("DELETE FROM sessions WHERE user_id = $1 AND id <> $2", pUserID, pKeepSessionID)
+	if lErr != nil {
+		return 0, lErr
+	}
+	lDeleted, lErr := lResult.RowsAffected()
+	if lErr != nil {
+		return 0, lErr
+	}
+
+	lErr = lTx.Commit()
+	if lErr != nil {
+		return 0, lErr
+	}
+	return int(lDeleted), nil
+}
+
+// revokeFamily revokes every refresh token of a login family and deletes its
+// access sessions.
+func revokeFamily(pTx *sql.Tx, pFamilyID string) error {
+	_, lErr := pTx.Exec("UPDATE refresh_tokens SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL", time.Now().UTC(), pFamilyID)
+	if lErr != nil {
+		return lErr
+	}
+
+	_, lErr = pTx.Exec("DELETE FROM sessions WHERE family_id = $1", pFamilyID)
+	return lErr
+}
+
+func (pStore *postgresStore) CreateRefreshToken(pUserID int, pFamilyID string, pTokenHash string, pExpiresAt time.Time) error {
+	lQuery := "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)"
+
+	_, lErr := pStore.db.Exec(lQuery, pUserID, pFamilyID, pTokenHash, pExpiresAt.UTC())
+	return lErr
+}
+
+func (pStore *postgresStore) RotateRefreshToken(pTokenHash string, pNewHash string, pNewExpiresAt time.Time) (*RefreshToken, error) {
+	lTx, lErr := pStore.db.Begin()
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lTx.Rollback()
+
+	var lToken RefreshToken
+	var lUsedAt, lRevokedAt sql.NullTime
+	lQuery := "SELECT id, user_id, family_id, expires_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE"
+	lErr = lTx.QueryRow(lQuery, pTokenHash).Scan(&lToken.ID, &lToken.UserID, &lToken.FamilyID, &lToken.ExpiresAt, &lUsedAt, &lRevokedAt)
+	if lErr == sql.ErrNoRows {
+		return nil, ErrRefreshTokenNotFound
+	}
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	if lUsedAt.Valid || lRevokedAt.Valid {
+		lErr = revokeFamily(lTx, lToken.FamilyID)
+		if lErr == nil {
+			lErr = lTx.Commit()
+		}
+		if lErr != nil {
+			return nil, lErr
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	lNow := time.Now().UTC()
+	if !lToken.ExpiresAt.After(lNow) {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	_, lErr = lTx.Exec("UPDATE refresh_tokens SET used_at = $1 WHERE id = $2", lNow, lToken.ID)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lQuery = "INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)"
+	_, lErr = lTx.Exec(lQuery, lToken.UserID, lToken.FamilyID, pNewHash, pNewExpiresAt.UTC())
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	lErr = lTx.Commit()
+	if lErr != nil {
+		return nil, lErr
+	}
+	return &lToken, nil
+}
+
+func (pStore *postgresStore) DeleteExpiredSessions(pNow time.Time, pLimit int) (int, error) {
+	lQuery := `DELETE FROM sessions WHERE id IN (
+			SELECT s.id FROM sessions s
+			WHERE s.expires_at <= $1
+			AND NOT EXISTS (
+				SELECT 1 FROM refresh_tokens r
+				WHERE r.family_id = s.family_id AND r.used_at IS NULL AND r.revoked_at IS NULL AND r.expires_at > $1
+			)
+			LIMIT $2
+		)`
+
+	return execCount(pStore.db, lQuery, pNow.UTC(), pLimit)
+}
+
+func (pStore *postgresStore) DeleteExpiredRefreshTokens(pNow time.Time, pLimit int) (int, error) {
+	lQuery := "DELETE FROM refresh_tokens WHERE id IN (SELECT id FROM refresh_tokens WHERE expires_at <= $1 LIMIT $2)"
+
+	return execCount(pStore.db, lQuery, pNow.UTC(), pLimit)
+}
+
+// execCount runs a write and returns the number of rows it affected.
+func execCount(pDB *sql.DB, pQuery string, pArgs ...interface{}) (int, error) {
+	lResult, lErr := pDB.Exec(pQuery, pArgs...)
+	if lErr != nil {
+		return 0, lErr
+	}
+	lCount, lErr := lResult.RowsAffected()
+	return int(lCount), lErr
+}
+
+// WithTryLock takes a session-level advisory lock, which belongs to the
+// connection that took it, so the lock is held on a dedicated connection
+// for as long as pFn runs.
+func (pStore *postgresStore) WithTryLock(pKey int64, pFn func() error) (bool, error) {
+	lCtx := context.Background()
+
+	lConn, lErr := pStore.db.Conn(lCtx)
+	if lErr != nil {
+		return false, lErr
+	}
+	defer lConn.Close()
+
+	var lLocked bool
+	lErr = lConn.QueryRowContext(lCtx, "SELECT pg_try_advisory_lock($1)", pKey).Scan(&lLocked)
+	if lErr != nil || !lLocked {
+		return false, lErr
+	}
+	defer func() {
+		_, lUnlockErr := lConn.ExecContext(lCtx, "SELECT pg_advisory_unlock($1)", pKey)
+		if lUnlockErr != nil {
+			log.Println("WithTryLock unlock error:", lUnlockErr)
+		}
+	}()
+
+	return true, pFn()
+}
+
+func (pStore *postgresStore) RecordLoginFailure(pUsername string, pIP string, pAttemptedAt time.Time) error {
+	lQuery := "INSERT INTO login_attempts (username, ip, attempted_at) VALUES ($1, $2, $3)"
+
+	_, lErr := pStore.db.Exec(lQuery, pUsername, pIP, pAttemptedAt.UTC())
+	return lErr
+}
+
+func (pStore *postgresStore) AddLoginFailure(pKey string, pNow time.Time, pWindow time.Duration) (int, error) {
+	lQuery := `INSERT INTO login_throttles (key, failures, last_failure_at) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_throttles.last_failure_at > $3 THEN login_throttles.failures + 1 ELSE 1 END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures`
+
+	var lFailures int
+	lErr := pStore.db.QueryRow(lQuery, pKey, pNow.UTC(), pNow.Add(-pWindow).UTC()).Scan(&lFailures)
+	return lFailures, lErr
+}
+
+func (pStore *postgresStore) BlockLogin(pKey string, pUntil time.Time) error {
+	_, lErr := pStore.db.Exec("UPDATE login_throttles SET blocked_until = $2 WHERE key = $1", pKey, pUntil.UTC())
+	return lErr
+}
+
+func (pStore *postgresStore) LoginBlockedUntil(pKeysArr []string, pNow time.Time) (time.Time, error) {
+	lQuery := "SELECT MAX(blocked_until) FROM login_throttles WHERE key = ANY($1) AND blocked_until > $2"
+
+	var lUntil sql.NullTime
+	lErr := pStore.db.QueryRow(lQuery, pq.Array(pKeysArr), pNow.UTC()).Scan(&lUntil)
+	if lErr != nil {
+		return time.Time{}, lErr
+	}
+	return lUntil.Time, nil
+}
+
+func (pStore *postgresStore) ClearLoginFailures(pKey string) error {
+	_, lErr := pStore.db.Exec("DELETE FROM login_throttles WHERE key = $1", pKey)
+	return lErr
+}
+
+func (pStore *postgresStore) CreateTodo(pUserID int, pFields TodoFields) (*Todo, error) {
+	lQuery := "INSERT INTO todos (user_id, title, content, completed, due_at, remind_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING " + todoColumns
+
+	return scanTodo(pStore.db.QueryRow(lQuery, pUserID, pFields.Title, pFields.Content, pFields.Completed, utcTimePtr(pFields.DueAt), utcTimePtr(pFields.RemindAt)))
+}
+
+func (pStore *postgresStore) ListTodos(pUserID int, pQuery TodoQuery) ([]Todo, error) {
+	lArgsArr := []interface{}{pUserID}
+	lWhereArr := []string{"user_id = $1"}
+	lArg := func(pValue interface{}) string {
+		lArgsArr = append(lArgsArr, pValue)
+		return "$" + strconv.Itoa(len(lArgsArr))
+	}
+
+	if pQuery.Completed != nil {
+		lWhereArr = append(lWhereArr, "completed = "+lArg(*pQuery.Completed))
+	}
+	if pQuery.CreatedAfter != nil {
+		lWhereArr = append(lWhereArr, "created_at >= "+lArg(pQuery.CreatedAfter.UTC()))
+	}
+	if pQuery.CreatedBefore != nil {
+		lWhereArr = append(lWhereArr, "created_at < "+lArg(pQuery.CreatedBefore.UTC()))
+	}
+	if pQuery.TitleContains != "" {
+		lWhereArr = append(lWhereArr, "title ILIKE "+lArg("%"+likeEscaper.Replace(pQuery.TitleContains)+"%"))
+	}
+	if pQuery.DueAfter != nil {
+		lWhereArr = append(lWhereArr, "due_at >= "+lArg(pQuery.DueAfter.UTC()))
+	}
+	if pQuery.DueBefore != nil {
+		lWhereArr = append(lWhereArr, "due_at < "+lArg(pQuery.DueBefore.UTC()))
+	}
+
+	lColumn, lCast := "created_at", "::timestamp"
+	switch pQuery.SortBy {
+	case TodoSortTitle:
+		// Byte order, as in the memory store, rather than the column collation.
+		lColumn, lCast = `title COLLATE "C"`, `::text COLLATE "C"`
+	case TodoSortCompleted:
+		lColumn, lCast = "completed", "::boolean"
+	}
+	lDirection, lComparison := "ASC", ">"
+	if pQuery.Descending {
+		lDirection, lComparison = "DESC", "<"
+	}
+
+	if pQuery.After != nil {
+		lWhereArr = append(lWhereArr, fmt.Sprintf("(%s, id) %s (%s%s, %s)", lColumn, lComparison, lArg(pQuery.After.Value), lCast, lArg(pQuery.After.ID)))
+	}
+
+	lQuery := fmt.Sprintf("SELECT %s FROM todos WHERE %s ORDER BY %s %s, id %s LIMIT %s",
+		todoColumns, strings.Join(lWhereArr, " AND "), lColumn, lDirection, lDirection, lArg(pQuery.Limit))
+
+	lRows, lErr := pStore.db.Query(lQuery, lArgsArr...)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lTodosArr := []Todo{}
+	for lRows.Next() {
+		lTodo, lErr := scanTodo(lRows)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lTodosArr = append(lTodosArr, *lTodo)
+	}
+	return lTodosArr, lRows.Err()
+}
+
+func (pStore *postgresStore) GetTodo(pUserID int, pTodoID int) (*Todo, error) {
+	lQuery := "SELECT " + todoColumns + " FROM todos WHERE id = $1 AND user_id = $2"
+
+	lTodo, lErr := scanTodo(pStore.db.QueryRow(lQuery, pTodoID, pUserID))
+	if lErr == sql.ErrNoRows {
+		return nil, ErrTodoNotFound
+	}
+	return lTodo, lErr
+}
+
+func (pStore *postgresStore) UpdateTodo(pUserID int, pTodoID int, pFields TodoFields, pIfMatch []int) (*Todo, error) {
+	lQuery := `
+	UPDATE todos SET title = $1, content = $2, completed = $3, due_at = $4,
+		reminder_fired_at = CASE WHEN remind_at IS DISTINCT FROM $5 THEN NULL ELSE reminder_fired_at END,
+		remind_at = $5,
+		version = version + 1
+	WHERE id = $6 AND user_id = $7 AND ($8::int[] IS NULL OR version = ANY($8))
+	RETURNING ` + todoColumns
+
+	lTodo, lErr := scanTodo(pStore.db.QueryRow(lQuery, pFields.Title, pFields.Content, pFields.Completed, utcTimePtr(pFields.DueAt), utcTimePtr(pFields.RemindAt), pTodoID, pUserID, versionsArg(pIfMatch)))
+	if lErr == sql.ErrNoRows {
+		return nil, pStore.todoWriteMiss(pUserID, pTodoID)
+	}
+	return lTodo, lErr
+}
+
+// versionsArg passes an If-Match version list to Postgres, keeping nil as SQL
+// NULL so that "$n::int[] IS NULL" means "no precondition".
+func versionsArg(pIfMatch []int) interface{} {
+	if pIfMatch == nil {
+		return nil
+	}
+	lVersionsArr := make([]int64, len(pIfMatch))
+	for lIdx, lVersion := range pIfMatch {
+		lVersionsArr[lIdx] = int64(lVersion)
+	}
+	return pq.Array(lVersionsArr)
+}
+
+// todoWriteMiss explains why a conditional write matched no row: either the
+// todo does not exist for this user or its version has moved on.
+func (pStore *postgresStore) todoWriteMiss(pUserID int, pTodoID int) error {
+	var lExists bool
+	lErr := pStore.db.QueryRow("SELECT EXISTS (SELECT 1 FROM todos WHERE id = $1 AND user_id = $2)", pTodoID, pUserID).Scan(&lExists)
+	if lErr != nil {
+		return lErr
+	}
+	if lExists {
+		return ErrPreconditionFailed
+	}
+	return ErrTodoNotFound
+}
+
+func (pStore *postgresStore) PatchTodo(pUserID int, pTodoID int, pPatch TodoPatch, pIfMatch []int) (*Todo, error) {
+	lArgsArr := []interface{}{}
+	lArg := func(pValue interface{}) string {
+		lArgsArr = append(lArgsArr, pValue)
+		return "$" + strconv.Itoa(len(lArgsArr))
+	}
+
+	lSetArr := []string{}
+	if pPatch.Title != nil {
+		lSetArr = append(lSetArr, "title = "+lArg(*pPatch.Title))
+	}
+	if pPatch.Content != nil {
+		lSetArr = append(lSetArr, "content = "+lArg(*pPatch.Content))
+	}
+	if pPatch.Completed != nil {
+		lSetArr = append(lSetArr, "completed = "+lArg(*pPatch.Completed))
+	}
+	if pPatch.DueAt.Set {
+		lSetArr = append(lSetArr, "due_at = "+lArg(utcTimePtr(pPatch.DueAt.Value)))
+	}
+	if pPatch.RemindAt.Set {
+		lRemindAt := lArg(utcTimePtr(pPatch.RemindAt.Value))
+		lSetArr = append(lSetArr,
+			"reminder_fired_at = CASE WHEN remind_at IS DISTINCT FROM "+lRemindAt+" THEN NULL ELSE reminder_fired_at END",
+			"remind_at = "+lRemindAt)
+	}
+
+	if len(lSetArr) == 0 {
+		lTodo, lErr := pStore.GetTodo(pUserID, pTodoID)
+		if lErr == nil && !versionMatches(lTodo.Version, pIfMatch) {
+			return nil, ErrPreconditionFailed
+		}
+		return lTodo, lErr
+	}
+	lSetArr = append(lSetArr, "version = version + 1")
+
+	lIfMatch := lArg(versionsArg(pIfMatch))
+	lQuery := fmt.Sprintf("UPDATE todos SET %s WHERE id = %s AND user_id = %s AND (%s::int[] IS NULL OR version = ANY(%s)) RETURNING %s",
+		strings.Join(lSetArr, ", "), lArg(pTodoID), lArg(pUserID), lIfMatch, lIfMatch, todoColumns)
+
+	lTodo, lErr := scanTodo(pStore.db.QueryRow(lQuery, lArgsArr...))
+	if lErr == sql.ErrNoRows {
+		return nil, pStore.todoWriteMiss(pUserID, pTodoID)
+	}
+	return lTodo, lErr
+}
+
+func (pStore *postgresStore) DeleteTodo(pUserID int, pTodoID int, pIfMatch []int) error {
+	lQuery := "DELETE FROM todos WHERE id = $1 AND user_id = $2 AND ($3::int[] IS NULL OR version = ANY($3))"
+
+	lResult, lErr := pStore.db.Exec(lQuery, pTodoID, pUserID, versionsArg(pIfMatch))
+	if lErr != nil {
+		return lErr
+	}
+
+	lRowsAffected, lErr := lResult.RowsAffected()
+	if lErr != nil {
+		return lErr
+	}
+	if lRowsAffected == 0 {
+		return pStore.todoWriteMiss(pUserID, pTodoID)
+	}
+	return nil
+}
+
+const todoHeadlineOptions = "StartSel=<mark>, StopSel=</mark>"
+
+// sqlHTMLEscape wraps the SQL text expression pExpr so that it escapes the
+// same characters as html.EscapeString. The parser behind ts_headline keeps
+// entities such as &amp; as single tokens, so escaping can happen before the
+// markers are added.
+func sqlHTMLEscape(pExpr string) string {
+	return `replace(replace(replace(replace(replace(` + pExpr +
+		`, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+}
+
+func (pStore *postgresStore) SearchTodos(pUserID int, pQuery string, pLimit int) ([]TodoSearchResult, error) {
+	lQuery := `
+	SELECT ` + todoColumns + `,
+		ts_rank(search_vector, q) AS rank,
+		ts_headline('english', ` + sqlHTMLEscape("title") + `, q, '` + todoHeadlineOptions + `, HighlightAll=true'),
+		ts_headline('english', ` + sqlHTMLEscape("coalesce(content, '')") + `, q, '` + todoHeadlineOptions + `, MaxFragments=2, MaxWords=20, MinWords=5')
+	FROM todos, websearch_to_tsquery('english', $2) q
+	WHERE user_id = $1 AND search_vector @@ q
+	ORDER BY rank DESC, id DESC
+	LIMIT $3`
+
+	lRows, lErr := pStore.db.Query(lQuery, pUserID, pQuery, pLimit)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lResultsArr := []TodoSearchResult{}
+	for lRows.Next() {
+		var lResult TodoSearchResult
+		lTodo, lErr := scanTodo(lRows, &lResult.Rank, &lResult.TitleSnippet, &lResult.ContentSnippet)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lResult.Todo = *lTodo
+		lResultsArr = append(lResultsArr, lResult)
+	}
+	return lResultsArr, lRows.Err()
+}
+
+func (pStore *postgresStore) ClaimDueReminders(pNow time.Time, pLimit int) ([]Todo, error) {
+	// SKIP LOCKED lets concurrent schedulers claim disjoint batches.
+	lQuery := `
+	UPDATE todos SET reminder_fired_at = $1
+	WHERE id IN (
+		SELECT id FROM todos
+		WHERE remind_at <= $1 AND reminder_fired_at IS NULL AND completed = FALSE
+		ORDER BY remind_at
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING ` + todoColumns
+
+	lRows, lErr := pStore.db.Query(lQuery, pNow.UTC(), pLimit)
+	if lErr != nil {
+		return nil, lErr
+	}
+	defer lRows.Close()
+
+	lTodosArr := []Todo{}
+	for lRows.Next() {
+		lTodo, lErr := scanTodo(lRows)
+		if lErr != nil {
+			return nil, lErr
+		}
+		lTodosArr = append(lTodosArr, *lTodo)
+	}
+	return lTodosArr, lRows.Err()
+}
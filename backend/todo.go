@@ -1,8 +1,6 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"log"
 	"net/http"
 	"strconv"
@@ -12,37 +10,24 @@ import (
 func CreateTodoAPI(w http.ResponseWriter, r *http.Request) {
 	log.Println("CreateTodoAPI(+)")
 	
-	if r.Method != http.MethodPost {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("CreateTodoAPI(-)")
-		return
-	}
-	
-	lToken := r.Header.Get("Authorization")
-	if lToken == "" {
-		SendErrorResponse(w, "Missing authorization token", http.StatusUnauthorized)
-		log.Println("CreateTodoAPI(-)")
-		return
-	}
-	
-	lUser, lErr := GetUserFromToken(lToken)
-	if lErr != nil {
-		SendErrorResponse(w, "Invalid token", http.StatusUnauthorized)
-		log.Println("CreateTodoAPI(-) error:", lErr)
-		return
-	}
+	lUser := CurrentUser(r)
 	
 	var lReq CreateTodoRequest
-	lErr = json.Unmarshal([]byte(ReadBody(r)), &lReq)
+	lErr := ReadBody(w, r, &lReq)
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("CreateTodoAPI(-) error:", lErr)
 		return
 	}
 	
-	lTodo, lErr := CreateTodo(lUser.ID, lReq.Title, lReq.Content)
+	lTodo, lErr := CreateTodo(lUser.ID, TodoFields{
+		Title:    lReq.Title,
+		Content:  lReq.Content,
+		DueAt:    lReq.DueAt,
+		RemindAt: lReq.RemindAt,
+	})
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("CreateTodoAPI(-) error:", lErr)
 		return
 	}
@@ -53,6 +38,7 @@ func CreateTodoAPI(w http.ResponseWriter, r *http.Request) {
 		Data:    lTodo,
 	}
 	
+	w.Header().Set("ETag", TodoETag(lTodo))
 	SendJSONResponse(w, lResponse, http.StatusOK)
 	log.Println("CreateTodoAPI(-)")
 }
@@ -60,29 +46,18 @@ func CreateTodoAPI(w http.ResponseWriter, r *http.Request) {
 func ListTodosAPI(w http.ResponseWriter, r *http.Request) {
 	log.Println("ListTodosAPI(+)")
 	
-	if r.Method != http.MethodGet {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("ListTodosAPI(-)")
-		return
-	}
-	
-	lToken := r.Header.Get("Authorization")
-	if lToken == "" {
-		SendErrorResponse(w, "Missing authorization token", http.StatusUnauthorized)
-		log.Println("ListTodosAPI(-)")
-		return
-	}
+	lUser := CurrentUser(r)
 	
-	lUser, lErr := GetUserFromToken(lToken)
+	lQuery, lErr := ParseTodoQuery(r.URL.Query())
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid token", http.StatusUnauthorized)
+		SendErrorResponse(w, lErr)
 		log.Println("ListTodosAPI(-) error:", lErr)
 		return
 	}
-	
-	lTodosArr, lErr := ListTodos(lUser.ID)
+
+	lPage, lErr := ListTodos(lUser.ID, lQuery)
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusInternalServerError)
+		SendErrorResponse(w, lErr)
 		log.Println("ListTodosAPI(-) error:", lErr)
 		return
 	}
@@ -90,61 +65,121 @@ func ListTodosAPI(w http.ResponseWriter, r *http.Request) {
 	lResponse := APIResponse{
 		Status:  "s",
 		Message: "Todos retrieved successfully",
-		Data:    lTodosArr,
+		Data:    lPage,
 	}
 	
 	SendJSONResponse(w, lResponse, http.StatusOK)
 	log.Println("ListTodosAPI(-)")
 }
 
-func UpdateTodoAPI(w http.ResponseWriter, r *http.Request) {
-	log.Println("UpdateTodoAPI(+)")
-	
-	if r.Method != http.MethodPut {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("UpdateTodoAPI(-)")
+// SearchTodosAPI serves GET /api/todos/search?q=<query>[&limit=<1..100>]. The
+// query accepts web-search syntax: quoted phrases, OR and -excluded words.
+func SearchTodosAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("SearchTodosAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lQuery := strings.TrimSpace(r.URL.Query().Get("q"))
+	if lQuery == "" {
+		SendErrorResponse(w, NewAPIError(http.StatusBadRequest, CodeMissingSearchQuery, "Query parameter \"q\" is required"))
+		log.Println("SearchTodosAPI(-)")
 		return
 	}
-	
-	lToken := r.Header.Get("Authorization")
-	if lToken == "" {
-		SendErrorResponse(w, "Missing authorization token", http.StatusUnauthorized)
-		log.Println("UpdateTodoAPI(-)")
+
+	lLimit := DefaultSearchLimit
+	if lRaw := r.URL.Query().Get("limit"); lRaw != "" {
+		lParsed, lErr := strconv.Atoi(lRaw)
+		if lErr != nil || lParsed < 1 || lParsed > MaxSearchLimit {
+			SendErrorResponse(w, invalidQueryParam("limit"))
+			log.Println("SearchTodosAPI(-)")
+			return
+		}
+		lLimit = lParsed
+	}
+
+	lResultsArr, lErr := SearchTodos(lUser.ID, lQuery, lLimit)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("SearchTodosAPI(-) error:", lErr)
 		return
 	}
-	
-	lUser, lErr := GetUserFromToken(lToken)
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Todos searched successfully",
+		Data:    lResultsArr,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("SearchTodosAPI(-)")
+}
+
+func GetTodoAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("GetTodoAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lTodoID, lErr := PathParamInt(r, "id")
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid token", http.StatusUnauthorized)
-		log.Println("UpdateTodoAPI(-) error:", lErr)
+		SendErrorResponse(w, ErrInvalidTodoID)
+		log.Println("GetTodoAPI(-) error:", lErr)
 		return
 	}
-	
-	lPathParts := strings.Split(r.URL.Path, "/")
-	if len(lPathParts) < 3 {
-		SendErrorResponse(w, "Invalid todo ID", http.StatusBadRequest)
-		log.Println("UpdateTodoAPI(-)")
+
+	lTodo, lErr := GetTodo(lUser.ID, lTodoID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("GetTodoAPI(-) error:", lErr)
+		return
+	}
+
+	lETag := TodoETag(lTodo)
+	w.Header().Set("ETag", lETag)
+	if IfNoneMatchHit(r, lETag) {
+		w.WriteHeader(http.StatusNotModified)
+		log.Println("GetTodoAPI(-) not modified")
 		return
 	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Todo retrieved successfully",
+		Data:    lTodo,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("GetTodoAPI(-)")
+}
+
+func UpdateTodoAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("UpdateTodoAPI(+)")
+	
+	lUser := CurrentUser(r)
 	
-	lTodoID, lErr := strconv.Atoi(lPathParts[len(lPathParts)-1])
+	lTodoID, lErr := PathParamInt(r, "id")
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid todo ID", http.StatusBadRequest)
+		SendErrorResponse(w, ErrInvalidTodoID)
 		log.Println("UpdateTodoAPI(-) error:", lErr)
 		return
 	}
 	
 	var lReq UpdateTodoRequest
-	lErr = json.Unmarshal([]byte(ReadBody(r)), &lReq)
+	lErr = ReadBody(w, r, &lReq)
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid request body", http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("UpdateTodoAPI(-) error:", lErr)
 		return
 	}
 	
-	lTodo, lErr := UpdateTodo(lUser.ID, lTodoID, lReq.Title, lReq.Content, lReq.Completed)
+	lTodo, lErr := UpdateTodo(lUser.ID, lTodoID, TodoFields{
+		Title:     lReq.Title,
+		Content:   lReq.Content,
+		Completed: lReq.Completed,
+		DueAt:     lReq.DueAt,
+		RemindAt:  lReq.RemindAt,
+	}, ParseIfMatch(r))
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("UpdateTodoAPI(-) error:", lErr)
 		return
 	}
@@ -155,50 +190,72 @@ func UpdateTodoAPI(w http.ResponseWriter, r *http.Request) {
 		Data:    lTodo,
 	}
 	
+	w.Header().Set("ETag", TodoETag(lTodo))
 	SendJSONResponse(w, lResponse, http.StatusOK)
 	log.Println("UpdateTodoAPI(-)")
 }
 
-func DeleteTodoAPI(w http.ResponseWriter, r *http.Request) {
-	log.Println("DeleteTodoAPI(+)")
+// PatchTodoAPI serves PATCH /api/todos/{id}: unlike UpdateTodoAPI (PUT), which
+// replaces the whole todo, it only changes the fields present in the body.
+func PatchTodoAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("PatchTodoAPI(+)")
 	
-	if r.Method != http.MethodDelete {
-		SendErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
-		log.Println("DeleteTodoAPI(-)")
+	lUser := CurrentUser(r)
+	
+	lTodoID, lErr := PathParamInt(r, "id")
+	if lErr != nil {
+		SendErrorResponse(w, ErrInvalidTodoID)
+		log.Println("PatchTodoAPI(-) error:", lErr)
 		return
 	}
 	
-	lToken := r.Header.Get("Authorization")
-	if lToken == "" {
-		SendErrorResponse(w, "Missing authorization token", http.StatusUnauthorized)
-		log.Println("DeleteTodoAPI(-)")
+	var lReq PatchTodoRequest
+	lErr = ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("PatchTodoAPI(-) error:", lErr)
 		return
 	}
 	
-	lUser, lErr := GetUserFromToken(lToken)
+	lTodo, lErr := PatchTodo(lUser.ID, lTodoID, TodoPatch{
+		Title:     lReq.Title,
+		Content:   lReq.Content,
+		Completed: lReq.Completed,
+		DueAt:     lReq.DueAt,
+		RemindAt:  lReq.RemindAt,
+	}, ParseIfMatch(r))
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid token", http.StatusUnauthorized)
-		log.Println("DeleteTodoAPI(-) error:", lErr)
+		SendErrorResponse(w, lErr)
+		log.Println("PatchTodoAPI(-) error:", lErr)
 		return
 	}
 	
-	lPathParts := strings.Split(r.URL.Path, "/")
-	if len(lPathParts) < 3 {
-		SendErrorResponse(w, "Invalid todo ID", http.StatusBadRequest)
-		log.Println("DeleteTodoAPI(-)")
-		return
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Todo updated successfully",
+		Data:    lTodo,
 	}
 	
-	lTodoID, lErr := strconv.Atoi(lPathParts[len(lPathParts)-1])
+	w.Header().Set("ETag", TodoETag(lTodo))
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("PatchTodoAPI(-)")
+}
+
+func DeleteTodoAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("DeleteTodoAPI(+)")
+	
+	lUser := CurrentUser(r)
+	
+	lTodoID, lErr := PathParamInt(r, "id")
 	if lErr != nil {
-		SendErrorResponse(w, "Invalid todo ID", http.StatusBadRequest)
+		SendErrorResponse(w, ErrInvalidTodoID)
 		log.Println("DeleteTodoAPI(-) error:", lErr)
 		return
 	}
 	
-	lErr = DeleteTodo(lUser.ID, lTodoID)
+	lErr = DeleteTodo(lUser.ID, lTodoID, ParseIfMatch(r))
 	if lErr != nil {
-		SendErrorResponse(w, lErr.Error(), http.StatusBadRequest)
+		SendErrorResponse(w, lErr)
 		log.Println("DeleteTodoAPI(-) error:", lErr)
 		return
 	}
@@ -213,92 +270,110 @@ func DeleteTodoAPI(w http.ResponseWriter, r *http.Request) {
 	log.Println("DeleteTodoAPI(-)")
 }
 
-func CreateTodo(pUserID int, pTitle string, pContent string) (*Todo, error) {
+func CreateTodo(pUserID int, pFields TodoFields) (*Todo, error) {
 	log.Println("CreateTodo(+)")
-	
-	lQuery := "INSERT INTO todos (user_id, title, content) VALUES ($1, $2, $3) RETURNING id, user_id, title, content, completed, created_at"
-	lDB := GetDB()
-	
-	var lTodo Todo
-	lErr := lDB.QueryRow(lQuery, pUserID, pTitle, pContent).Scan(&lTodo.ID, &lTodo.UserID, &lTodo.Title, &lTodo.Content, &lTodo.Completed, &lTodo.CreatedAt)
+
+	lTodo, lErr := GetStore().Todos.CreateTodo(pUserID, pFields)
 	if lErr != nil {
 		log.Println("CreateTodo(-) error:", lErr)
 		return nil, lErr
 	}
-	
+
 	log.Println("CreateTodo(-)")
-	return &lTodo, nil
+	return lTodo, nil
 }
 
-func ListTodos(pUserID int) ([]Todo, error) {
+// ListTodos returns one page of pUserID's todos matching pQuery. NextCursor
+// is set when more todos follow the page.
+func ListTodos(pUserID int, pQuery TodoQuery) (*TodoPage, error) {
 	log.Println("ListTodos(+)")
-	
-	lQuery := "SELECT id, user_id, title, content, completed, created_at FROM todos WHERE user_id = $1 ORDER BY created_at DESC"
-	lDB := GetDB()
-	
-	lRows, lErr := lDB.Query(lQuery, pUserID)
+
+	lLimit := pQuery.Limit
+	pQuery.Limit = lLimit + 1
+
+	lTodosArr, lErr := GetStore().Todos.ListTodos(pUserID, pQuery)
 	if lErr != nil {
 		log.Println("ListTodos(-) error:", lErr)
 		return nil, lErr
 	}
-	defer lRows.Close()
-	
-	var lTodosArr []Todo
-	for lRows.Next() {
-		var lTodo Todo
-		lErr := lRows.Scan(&lTodo.ID, &lTodo.UserID, &lTodo.Title, &lTodo.Content, &lTodo.Completed, &lTodo.CreatedAt)
-		if lErr != nil {
-			log.Println("ListTodos(-) error:", lErr)
-			continue
-		}
-		lTodosArr = append(lTodosArr, lTodo)
+
+	lPage := &TodoPage{Todos: lTodosArr}
+	if len(lTodosArr) > lLimit {
+		lPage.Todos = lTodosArr[:lLimit]
+		lLast := lPage.Todos[lLimit-1]
+		lPage.NextCursor = EncodeTodoCursor(TodoCursor{
+			SortBy:     pQuery.SortBy,
+			Descending: pQuery.Descending,
+			Value:      TodoSortValue(lLast, pQuery.SortBy),
+			ID:         lLast.ID,
+		})
 	}
-	
+
 	log.Println("ListTodos(-)")
-	return lTodosArr, nil
+	return lPage, nil
 }
 
-func UpdateTodo(pUserID int, pTodoID int, pTitle string, pContent string, pCompleted bool) (*Todo, error) {
+func SearchTodos(pUserID int, pQuery string, pLimit int) ([]TodoSearchResult, error) {
+	log.Println("SearchTodos(+)")
+
+	lResultsArr, lErr := GetStore().Todos.SearchTodos(pUserID, pQuery, pLimit)
+	if lErr != nil {
+		log.Println("SearchTodos(-) error:", lErr)
+		return nil, lErr
+	}
+
+	log.Println("SearchTodos(-)")
+	return lResultsArr, nil
+}
+
+func GetTodo(pUserID int, pTodoID int) (*Todo, error) {
+	log.Println("GetTodo(+)")
+
+	lTodo, lErr := GetStore().Todos.GetTodo(pUserID, pTodoID)
+	if lErr != nil {
+		log.Println("GetTodo(-) error:", lErr)
+		return nil, lErr
+	}
+
+	log.Println("GetTodo(-)")
+	return lTodo, nil
+}
+
+func UpdateTodo(pUserID int, pTodoID int, pFields TodoFields, pIfMatch []int) (*Todo, error) {
 	log.Println("UpdateTodo(+)")
-	
-	lQuery := "UPDATE todos SET title = $1, content = $2, completed = $3 WHERE id = $4 AND user_id = $5 RETURNING id, user_id, title, content, completed, created_at"
-	lDB := GetDB()
-	
-	var lTodo Todo
-	lErr := lDB.QueryRow(lQuery, pTitle, pContent, pCompleted, pTodoID, pUserID).Scan(&lTodo.ID, &lTodo.UserID, &lTodo.Title, &lTodo.Content, &lTodo.Completed, &lTodo.CreatedAt)
+
+	lTodo, lErr := GetStore().Todos.UpdateTodo(pUserID, pTodoID, pFields, pIfMatch)
 	if lErr != nil {
 		log.Println("UpdateTodo(-) error:", lErr)
 		return nil, lErr
 	}
-	
+
 	log.Println("UpdateTodo(-)")
-	return &lTodo, nil
+	return lTodo, nil
 }
 
-func DeleteTodo(pUserID int, pTodoID int) error {
-	log.Println("DeleteTodo(+)")
-	
-	lQuery := "DELETE FROM todos WHERE id = $1 AND user_id = $2"
-	lDB := GetDB()
-	
-	lResult, lErr := lDB.Exec(lQuery, pTodoID, pUserID)
+func PatchTodo(pUserID int, pTodoID int, pPatch TodoPatch, pIfMatch []int) (*Todo, error) {
+	log.Println("PatchTodo(+)")
+
+	lTodo, lErr := GetStore().Todos.PatchTodo(pUserID, pTodoID, pPatch, pIfMatch)
 	if lErr != nil {
-		log.Println("DeleteTodo(-) error:", lErr)
-		return lErr
+		log.Println("PatchTodo(-) error:", lErr)
+		return nil, lErr
 	}
-	
-	lRowsAffected, lErr := lResult.RowsAffected()
+
+	log.Println("PatchTodo(-)")
+	return lTodo, nil
+}
+
+func DeleteTodo(pUserID int, pTodoID int, pIfMatch []int) error {
+	log.Println("DeleteTodo(+)")
+
+	lErr := GetStore().Todos.DeleteTodo(pUserID, pTodoID, pIfMatch)
 	if lErr != nil {
 		log.Println("DeleteTodo(-) error:", lErr)
 		return lErr
 	}
-	
-	if lRowsAffected == 0 {
-		log.Println("DeleteTodo(-) error: todo not found")
-		return errors.New("todo not found")
-	}
-	
+
 	log.Println("DeleteTodo(-)")
 	return nil
 }
-
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TodoSortCreatedAt = "created_at"
+	TodoSortTitle     = "title"
+	TodoSortCompleted = "completed"
+
+	DefaultTodoLimit = 50
+	MaxTodoLimit     = 200
+
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// ParseTodoQuery reads the ListTodosAPI query string:
+//
+//	completed=true|false            only completed or only open todos
+//	created_after, created_before   RFC 3339 timestamp or YYYY-MM-DD date
+//	title=<text>                    case-insensitive title substring
+//	due=overdue|today|week          open todos past due, or due today / in
+//	                                the next 7 days; tz=<IANA zone> sets
+//	                                where "today" starts (default UTC)
+//	sort=created_at|title|completed order=asc|desc (default created_at desc)
+//	limit=<1..200>                  page size (default 50)
+//	cursor=<next_cursor>            continue after a previous page
+func ParseTodoQuery(pValues url.Values) (TodoQuery, error) {
+	lQuery := TodoQuery{
+		SortBy:        TodoSortCreatedAt,
+		Descending:    true,
+		Limit:         DefaultTodoLimit,
+		TitleContains: strings.TrimSpace(pValues.Get("title")),
+	}
+
+	if lRaw := pValues.Get("completed"); lRaw != "" {
+		lCompleted, lErr := strconv.ParseBool(lRaw)
+		if lErr != nil {
+			return lQuery, invalidQueryParam("completed")
+		}
+		lQuery.Completed = &lCompleted
+	}
+
+	for _, lParam := range []string{"created_after", "created_before"} {
+		lRaw := pValues.Get(lParam)
+		if lRaw == "" {
+			continue
+		}
+		lTime, lErr := parseQueryTime(lRaw)
+		if lErr != nil {
+			return lQuery, invalidQueryParam(lParam)
+		}
+		if lParam == "created_after" {
+			lQuery.CreatedAfter = &lTime
+		} else {
+			lQuery.CreatedBefore = &lTime
+		}
+	}
+
+	if lRaw := pValues.Get("due"); lRaw != "" {
+		lErr := applyDueFilter(&lQuery, lRaw, pValues.Get("tz"), time.Now())
+		if lErr != nil {
+			return lQuery, lErr
+		}
+	}
+
+	if lRaw := pValues.Get("sort"); lRaw != "" {
+		switch lRaw {
+		case TodoSortCreatedAt, TodoSortTitle, TodoSortCompleted:
+			lQuery.SortBy = lRaw
+		default:
+			return lQuery, invalidQueryParam("sort")
+		}
+		// An explicit sort defaults to ascending unless order says otherwise.
+		lQuery.Descending = false
+	}
+
+	switch pValues.Get("order") {
+	case "":
+	case "asc":
+		lQuery.Descending = false
+	case "desc":
+		lQuery.Descending = true
+	default:
+		return lQuery, invalidQueryParam("order")
+	}
+
+	if lRaw := pValues.Get("limit"); lRaw != "" {
+		lLimit, lErr := strconv.Atoi(lRaw)
+		if lErr != nil || lLimit < 1 || lLimit > MaxTodoLimit {
+			return lQuery, invalidQueryParam("limit")
+		}
+		lQuery.Limit = lLimit
+	}
+
+	if lRaw := pValues.Get("cursor"); lRaw != "" {
+		lCursor, lErr := DecodeTodoCursor(lRaw)
+		if lErr != nil || lCursor.SortBy != lQuery.SortBy || lCursor.Descending != lQuery.Descending || !validCursorValue(lCursor) {
+			return lQuery, invalidQueryParam("cursor")
+		}
+		lQuery.After = lCursor
+	}
+
+	return lQuery, nil
+}
+
+// applyDueFilter turns a due=overdue|today|week view into due_at bounds.
+// Overdue only lists open todos unless completed= was given explicitly.
+func applyDueFilter(pQuery *TodoQuery, pDue string, pTimeZone string, pNow time.Time) error {
+	lLocation := time.UTC
+	if pTimeZone != "" {
+		lLoaded, lErr := time.LoadLocation(pTimeZone)
+		if lErr != nil {
+			return invalidQueryParam("tz")
+		}
+		lLocation = lLoaded
+	}
+
+	lLocalNow := pNow.In(lLocation)
+	lStartOfDay := time.Date(lLocalNow.Year(), lLocalNow.Month(), lLocalNow.Day(), 0, 0, 0, 0, lLocation)
+
+	switch pDue {
+	case "overdue":
+		lBefore := pNow
+		pQuery.DueBefore = &lBefore
+		if pQuery.Completed == nil {
+			lOpen := false
+			pQuery.Completed = &lOpen
+		}
+	case "today":
+		lEndOfDay := lStartOfDay.AddDate(0, 0, 1)
+		pQuery.DueAfter = &lStartOfDay
+		pQuery.DueBefore = &lEndOfDay
+	case "week":
+		lEndOfWeek := lStartOfDay.AddDate(0, 0, 7)
+		pQuery.DueAfter = &lStartOfDay
+		pQuery.DueBefore = &lEndOfWeek
+	default:
+		return invalidQueryParam("due")
+	}
+	return nil
+}
+
+func invalidQueryParam(pParam string) *APIError {
+	return NewAPIErrorf(http.StatusBadRequest, CodeInvalidQuery, "Invalid value for query parameter %q", pParam)
+}
+
+func parseQueryTime(pRaw string) (time.Time, error) {
+	lTime, lErr := time.Parse(time.RFC3339, pRaw)
+	if lErr == nil {
+		return lTime.UTC(), nil
+	}
+	return time.Parse("2006-01-02", pRaw)
+}
+
+// TodoSortValue returns pTodo's value for the pSortBy column in the form kept
+// inside a TodoCursor.
+func TodoSortValue(pTodo Todo, pSortBy string) string {
+	switch pSortBy {
+	case TodoSortTitle:
+		return pTodo.Title
+	case TodoSortCompleted:
+		return strconv.FormatBool(pTodo.Completed)
+	default:
+		return pTodo.CreatedAt
+	}
+}
+
+// validCursorValue guards the stores against cursors that were tampered with,
+// since the value ends up compared against a typed column.
+func validCursorValue(pCursor *TodoCursor) bool {
+	switch pCursor.SortBy {
+	case TodoSortCreatedAt:
+		_, lErr := time.Parse(time.RFC3339Nano, pCursor.Value)
+		return lErr == nil
+	case TodoSortCompleted:
+		_, lErr := strconv.ParseBool(pCursor.Value)
+		return lErr == nil
+	}
+	return true
+}
+
+func EncodeTodoCursor(pCursor TodoCursor) string {
+	lJSON, _ := json.Marshal(pCursor)
+	return base64.RawURLEncoding.EncodeToString(lJSON)
+}
+
+func DecodeTodoCursor(pRaw string) (*TodoCursor, error) {
+	lJSON, lErr := base64.RawURLEncoding.DecodeString(pRaw)
+	if lErr != nil {
+		return nil, lErr
+	}
+
+	var lCursor TodoCursor
+	lErr = json.Unmarshal(lJSON, &lCursor)
+	if lErr != nil {
+		return nil, lErr
+	}
+	return &lCursor, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseTodoQueryDefaults(t *testing.T) {
+	lQuery, lErr := ParseTodoQuery(url.Values{})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	if lQuery.SortBy != TodoSortCreatedAt || !lQuery.Descending || lQuery.Limit != DefaultTodoLimit || lQuery.After != nil {
+		t.Errorf("ParseTodoQuery defaults = %+v", lQuery)
+	}
+}
+
+func TestParseTodoQuery(t *testing.T) {
+	lQuery, lErr := ParseTodoQuery(url.Values{
+		"completed":     {"false"},
+		"created_after": {"2024-01-02"},
+		"title":         {"  milk "},
+		"sort":          {"title"},
+		"limit":         {"10"},
+	})
+	if lErr != nil {
+		t.Fatal(lErr)
+	}
+	if lQuery.Completed == nil || *lQuery.Completed {
+		t.Errorf("Completed = %v, want false", lQuery.Completed)
+	}
+	if lQuery.CreatedAfter == nil || lQuery.CreatedAfter.Format("2006-01-02") != "2024-01-02" {
+		t.Errorf("CreatedAfter = %v, want 2024-01-02", lQuery.CreatedAfter)
+	}
+	if lQuery.TitleContains != "milk" {
+		t.Errorf("TitleContains = %q, want %q", lQuery.TitleContains, "milk")
+	}
+	// An explicit sort defaults to ascending.
+	if lQuery.SortBy != TodoSortTitle || lQuery.Descending || lQuery.Limit != 10 {
+		t.Errorf("SortBy %s Descending %v Limit %d", lQuery.SortBy, lQuery.Descending, lQuery.Limit)
+	}
+}
+
+func TestParseTodoQueryInvalid(t *testing.T) {
+	lCasesArr := []url.Values{
+		{"completed": {"maybe"}},
+		{"created_before": {"yesterday"}},
+		{"due": {"someday"}},
+		{"due": {"today"}, "tz": {"Mars/Olympus"}},
+		{"sort": {"priority"}},
+		{"order": {"up"}},
+		{"limit": {"0"}},
+		{"limit": {"201"}},
+		{"cursor": {"not base64!"}},
+	}
+
+	for _, lValues := range lCasesArr {
+		_, lErr := ParseTodoQuery(lValues)
+		if lErr == nil {
+			t.Errorf("ParseTodoQuery(%v) accepted an invalid query", lValues)
+		}
+	}
+}
+
+func TestTodoCursorRoundTrip(t *testing.T) {
+	lTodo := Todo{ID: 7, Title: "Buy milk", CreatedAt: "2024-01-02T03:04:05.123456Z"}
+
+	for _, lSortBy := range []string{TodoSortCreatedAt, TodoSortTitle, TodoSortCompleted} {
+		lCursor := TodoCursor{SortBy: lSortBy, Descending: true, Value: TodoSortValue(lTodo, lSortBy), ID: lTodo.ID}
+
+		lQuery, lErr := ParseTodoQuery(url.Values{
+			"sort":   {lSortBy},
+			"order":  {"desc"},
+			"cursor": {EncodeTodoCursor(lCursor)},
+		})
+		if lErr != nil {
+			t.Fatalf("%s: %v", lSortBy, lErr)
+		}
+		if lQuery.After == nil || *lQuery.After != lCursor {
+			t.Errorf("%s: After = %+v, want %+v", lSortBy, lQuery.After, lCursor)
+		}
+	}
+}
+
+func TestTodoCursorMismatch(t *testing.T) {
+	lCursor := EncodeTodoCursor(TodoCursor{SortBy: TodoSortTitle, Value: "a", ID: 1})
+
+	// A cursor only continues the listing it came from.
+	_, lErr := ParseTodoQuery(url.Values{"sort": {"title"}, "order": {"desc"}, "cursor": {lCursor}})
+	if lErr == nil {
+		t.Error("cursor was accepted for a different order")
+	}
+
+	lTampered := EncodeTodoCursor(TodoCursor{SortBy: TodoSortCreatedAt, Descending: true, Value: "1; DROP TABLE todos", ID: 1})
+	_, lErr = ParseTodoQuery(url.Values{"cursor": {lTampered}})
+	if lErr == nil {
+		t.Error("cursor with a non-timestamp value was accepted")
+	}
+}
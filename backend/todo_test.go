@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSearchTodos(t *testing.T) {
+	lServer := newTestServer(t)
+	lAlice := signupTestUser(t, lServer, "alice")
+	lBob := signupTestUser(t, lServer, "bob")
+
+	callAPI(t, lServer, http.MethodPost, "/api/todos", lAlice.Token, nil, CreateTodoRequest{Title: "<b>Buy</b> milk & eggs", Content: `"milk" for <script>`}, nil)
+	callAPI(t, lServer, http.MethodPost, "/api/todos", lAlice.Token, nil, CreateTodoRequest{Title: "Walk the dog"}, nil)
+	callAPI(t, lServer, http.MethodPost, "/api/todos", lBob.Token, nil, CreateTodoRequest{Title: "Milk the cow"}, nil)
+
+	var lResultsArr []TodoSearchResult
+	lResponse, _ := callAPI(t, lServer, http.MethodGet, "/api/todos/search?q=milk", lAlice.Token, nil, nil, &lResultsArr)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("search: %d", lResponse.StatusCode)
+	}
+	// Only alice's matching todo; bob's stays invisible to her.
+	if len(lResultsArr) != 1 || lResultsArr[0].UserID != lAlice.User.ID {
+		t.Fatalf("search results = %+v, want alice's one match", lResultsArr)
+	}
+
+	// Snippets are safe to render as HTML: only the <mark> tags are markup.
+	lWantTitle := "&lt;b&gt;Buy&lt;/b&gt; <mark>milk</mark> &amp; eggs"
+	lWantContent := "&#34;<mark>milk</mark>&#34; for &lt;script&gt;"
+	if lResultsArr[0].TitleSnippet != lWantTitle || lResultsArr[0].ContentSnippet != lWantContent {
+		t.Errorf("snippets = %q, %q, want %q, %q", lResultsArr[0].TitleSnippet, lResultsArr[0].ContentSnippet, lWantTitle, lWantContent)
+	}
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodGet, "/api/todos/search?q=+", lAlice.Token, nil, nil, nil)
+	if lResponse.StatusCode != http.StatusBadRequest || lAPIResponse.Code != CodeMissingSearchQuery {
+		t.Errorf("blank query: %d %s, want 400 %s", lResponse.StatusCode, lAPIResponse.Code, CodeMissingSearchQuery)
+	}
+}
+
+func TestHighlightTerms(t *testing.T) {
+	lCasesArr := []struct {
+		text     string
+		termsArr []string
+		want     string
+	}{
+		{"Buy milk", []string{"milk"}, "Buy <mark>milk</mark>"},
+		{"MILK and Milk", []string{"milk"}, "<mark>MILK</mark> and <mark>Milk</mark>"},
+		{"milkshake", []string{"milk", "shake"}, "<mark>milkshake</mark>"},
+		{`<b>milk</b> & "eggs"`, []string{"milk"}, "&lt;b&gt;<mark>milk</mark>&lt;/b&gt; &amp; &#34;eggs&#34;"},
+		{"<script>", nil, "&lt;script&gt;"},
+	}
+
+	for _, lCase := range lCasesArr {
+		lGot := highlightTerms(lCase.text, lCase.termsArr)
+		if lGot != lCase.want {
+			t.Errorf("highlightTerms(%q) = %q, want %q", lCase.text, lGot, lCase.want)
+		}
+	}
+}
+
+func TestTodoPatchNullClears(t *testing.T) {
+	lServer := newTestServer(t)
+	lAuth := signupTestUser(t, lServer, "dave")
+
+	lDueAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	lRemindAt := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	var lTodo Todo
+	callAPI(t, lServer, http.MethodPost, "/api/todos", lAuth.Token, nil, CreateTodoRequest{Title: "Dentist", Content: "Bring card", DueAt: &lDueAt, RemindAt: &lRemindAt}, &lTodo)
+	lPath := "/api/todos/" + strconv.Itoa(lTodo.ID)
+
+	// Absent fields stay as they are.
+	var lPatched Todo
+	lResponse, _ := callAPI(t, lServer, http.MethodPatch, lPath, lAuth.Token, nil, map[string]interface{}{"completed": true}, &lPatched)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH completed: %d", lResponse.StatusCode)
+	}
+	if !lPatched.Completed || lPatched.Title != "Dentist" || lPatched.Content != "Bring card" || lPatched.DueAt == nil || !lPatched.DueAt.Equal(lDueAt) || lPatched.RemindAt == nil {
+		t.Errorf("PATCH completed changed other fields: %+v", lPatched)
+	}
+
+	// null clears just the named field.
+	lResponse, _ = callAPI(t, lServer, http.MethodPatch, lPath, lAuth.Token, nil, map[string]interface{}{"due_at": nil}, &lPatched)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("PATCH due_at null: %d", lResponse.StatusCode)
+	}
+	if lPatched.DueAt != nil || lPatched.RemindAt == nil || !lPatched.RemindAt.Equal(lRemindAt) {
+		t.Errorf("PATCH due_at null: due %v remind %v, want nil and %v", lPatched.DueAt, lPatched.RemindAt, lRemindAt)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPatch, lPath, lAuth.Token, nil, map[string]interface{}{"remind_at": nil}, &lPatched)
+	if lResponse.StatusCode != http.StatusOK || lPatched.RemindAt != nil {
+		t.Errorf("PATCH remind_at null: %d remind %v, want 200 nil", lResponse.StatusCode, lPatched.RemindAt)
+	}
+}
+
+func TestTodoIfMatch(t *testing.T) {
+	lServer := newTestServer(t)
+	lAuth := signupTestUser(t, lServer, "carol")
+
+	lDueAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	var lTodo Todo
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/todos", lAuth.Token, nil, CreateTodoRequest{Title: "Buy milk", DueAt: &lDueAt}, &lTodo)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("create: %d", lResponse.StatusCode)
+	}
+	lETag := lResponse.Header.Get("ETag")
+	lPath := "/api/todos/" + strconv.Itoa(lTodo.ID)
+
+	var lUpdated Todo
+	lResponse, _ = callAPI(t, lServer, http.MethodPut, lPath, lAuth.Token, map[string]string{"If-Match": lETag}, UpdateTodoRequest{Title: "Buy oat milk"}, &lUpdated)
+	if lResponse.StatusCode != http.StatusOK || lUpdated.Version != lTodo.Version+1 {
+		t.Fatalf("PUT with current ETag: %d, version %d", lResponse.StatusCode, lUpdated.Version)
+	}
+	if lResponse.Header.Get("ETag") == lETag {
+		t.Error("ETag did not change with the update")
+	}
+
+	// A second writer still holding the old ETag must not overwrite the change.
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPatch, lPath, lAuth.Token, map[string]string{"If-Match": lETag}, map[string]interface{}{"completed": true}, nil)
+	if lResponse.StatusCode != http.StatusPreconditionFailed || lAPIResponse.Code != CodePreconditionFailed {
+		t.Errorf("PATCH with stale ETag: %d %s, want 412 %s", lResponse.StatusCode, lAPIResponse.Code, CodePreconditionFailed)
+	}
+
+	var lCurrent Todo
+	callAPI(t, lServer, http.MethodGet, lPath, lAuth.Token, nil, nil, &lCurrent)
+	if lCurrent.Title != "Buy oat milk" || lCurrent.Completed {
+		t.Errorf("todo after refused PATCH = %q completed %v", lCurrent.Title, lCurrent.Completed)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPut, lPath, lAuth.Token, map[string]string{"If-Match": `W/"` + strconv.Itoa(lCurrent.Version) + `"`}, UpdateTodoRequest{Title: "x"}, nil)
+	if lResponse.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PUT with weak ETag: %d, want 412", lResponse.StatusCode)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238). They are the defaults every authenticator app
+// assumes, so the otpauth:// URI states them only for completeness.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is how many time steps before or after the current one a code
+	// is still accepted, to allow for clock drift on the user's device.
+	totpSkew = 1
+
+	totpSecretBytes = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpModulus is 10^totpDigits; an HOTP value modulo it is the code.
+var totpModulus = powerOfTen(totpDigits)
+
+func powerOfTen(pExponent int) uint32 {
+	lResult := uint32(1)
+	for lIdx := 0; lIdx < pExponent; lIdx++ {
+		lResult *= 10
+	}
+	return lResult
+}
+
+// NewTOTPSecret returns a random base32 secret for a new enrollment.
+func NewTOTPSecret() (string, error) {
+	lBytes := make([]byte, totpSecretBytes)
+	_, lErr := rand.Read(lBytes)
+	if lErr != nil {
+		return "", lErr
+	}
+	return totpEncoding.EncodeToString(lBytes), nil
+}
+
+// TOTPStep is the RFC 6238 time step pTime falls in.
+func TOTPStep(pTime time.Time) int64 {
+	return pTime.Unix() / totpPeriod
+}
+
+// TOTPCode computes the code of pSecret for time step pStep (RFC 4226
+// HOTP with HMAC-SHA1 and dynamic truncation).
+func TOTPCode(pSecret string, pStep int64) (string, error) {
+	lKey, lErr := totpEncoding.DecodeString(strings.ToUpper(pSecret))
+	if lErr != nil {
+		return "", lErr
+	}
+
+	lCounter := make([]byte, 8)
+	binary.BigEndian.PutUint64(lCounter, uint64(pStep))
+
+	lMac := hmac.New(sha1.New, lKey)
+	lMac.Write(lCounter)
+	lSum := lMac.Sum(nil)
+
+	lOffset := lSum[len(lSum)-1] & 0x0f
+	lValue := binary.BigEndian.Uint32(lSum[lOffset:lOffset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, lValue%totpModulus), nil
+}
+
+// MatchTOTPCode checks pCode against pSecret around pNow and returns the time
+// step it belongs to.
+func MatchTOTPCode(pSecret string, pCode string, pNow time.Time) (int64, bool) {
+	if len(pCode) != totpDigits {
+		return 0, false
+	}
+
+	lCurrent := TOTPStep(pNow)
+	for lStep := lCurrent - totpSkew; lStep <= lCurrent+totpSkew; lStep++ {
+		lExpected, lErr := TOTPCode(pSecret, lStep)
+		if lErr != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(lExpected), []byte(pCode)) == 1 {
+			return lStep, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI builds the otpauth:// URI authenticator apps import, usually from
+// a QR code.
+func TOTPURI(pIssuer string, pAccount string, pSecret string) string {
+	lQuery := url.Values{}
+	lQuery.Set("secret", pSecret)
+	lQuery.Set("issuer", pIssuer)
+	lQuery.Set("algorithm", "SHA1")
+	lQuery.Set("digits", fmt.Sprint(totpDigits))
+	lQuery.Set("period", fmt.Sprint(totpPeriod))
+
+	lURI := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + pIssuer + ":" + pAccount,
+		RawQuery: lQuery.Encode(),
+	}
+	return lURI.String()
+}
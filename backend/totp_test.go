@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890",
+// in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Appendix B lists 8-digit codes; a 6-digit code is their last six digits.
+	lVectorsArr := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, lVector := range lVectorsArr {
+		lCode, lErr := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(lVector.unix, 0)))
+		if lErr != nil {
+			t.Fatalf("TOTPCode at %d: %v", lVector.unix, lErr)
+		}
+		if lCode != lVector.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", lVector.unix, lCode, lVector.code)
+		}
+	}
+}
+
+func TestMatchTOTPCodeSkew(t *testing.T) {
+	lNow := time.Unix(1111111111, 0)
+
+	lStep, lOk := MatchTOTPCode(rfc6238Secret, "050471", lNow)
+	if !lOk || lStep != TOTPStep(lNow) {
+		t.Errorf("current code: step %d ok %v, want step %d", lStep, lOk, TOTPStep(lNow))
+	}
+
+	lPrevious, _ := TOTPCode(rfc6238Secret, TOTPStep(lNow)-1)
+	_, lOk = MatchTOTPCode(rfc6238Secret, lPrevious, lNow)
+	if !lOk {
+		t.Error("code of the previous step was refused")
+	}
+
+	lStale, _ := TOTPCode(rfc6238Secret, TOTPStep(lNow)-totpSkew-1)
+	_, lOk = MatchTOTPCode(rfc6238Secret, lStale, lNow)
+	if lOk {
+		t.Error("code outside the skew window was accepted")
+	}
+
+	_, lOk = MatchTOTPCode(rfc6238Secret, "50471", lNow)
+	if lOk {
+		t.Error("short code was accepted")
+	}
+}
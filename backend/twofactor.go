@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/rand"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// UserTokenLoginChallenge is the user_tokens purpose of the challenge a
+	// login gets when the password was right but a 2FA code is still due.
+	UserTokenLoginChallenge = "login_challenge"
+
+	RecoveryCodeCount = 10
+
+	// recoveryCodeAlphabet leaves out characters that are easy to misread,
+	// such as 0/o and 1/l/i.
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	recoveryCodeLength   = 10
+)
+
+// EnrollTwoFactorAPI serves POST /api/auth/2fa/enroll. It starts (or restarts)
+// enrollment and returns the secret to add to an authenticator app; 2FA stays
+// off until ConfirmTwoFactorAPI receives a code generated from it.
+func EnrollTwoFactorAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("EnrollTwoFactorAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	lEnrollment, lErr := EnrollTwoFactor(lUser)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("EnrollTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Scan the code with your authenticator app, then confirm it with a code",
+		Data:    lEnrollment,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("EnrollTwoFactorAPI(-)")
+}
+
+// ConfirmTwoFactorAPI serves POST /api/auth/2fa/confirm. A valid code from the
+// pending secret turns 2FA on; the response carries the recovery codes, which
+// are not shown again.
+func ConfirmTwoFactorAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("ConfirmTwoFactorAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	var lReq TwoFactorCodeRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ConfirmTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lRecoveryCodesArr, lErr := ConfirmTwoFactor(lUser.ID, lReq.Code)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("ConfirmTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Two-factor authentication enabled",
+		Data:    map[string][]string{"recovery_codes": lRecoveryCodesArr},
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("ConfirmTwoFactorAPI(-)")
+}
+
+// DisableTwoFactorAPI serves POST /api/auth/2fa/disable. It takes a current
+// code or a recovery code, so a stolen session alone cannot turn 2FA off.
+func DisableTwoFactorAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("DisableTwoFactorAPI(+)")
+
+	lUser := CurrentUser(r)
+
+	var lReq TwoFactorCodeRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DisableTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr = GetStore().Users.GetUserByID(lUser.ID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DisableTwoFactorAPI(-) error:", lErr)
+		return
+	}
+	if lUser.TwoFactorEnabledAt == nil {
+		SendErrorResponse(w, ErrTwoFactorNotEnabled)
+		log.Println("DisableTwoFactorAPI(-) error:", ErrTwoFactorNotEnabled)
+		return
+	}
+
+	if !checkTwoFactorCode(w, r, lUser, lReq.Code) {
+		log.Println("DisableTwoFactorAPI(-) error: code rejected")
+		return
+	}
+
+	lErr = GetStore().TwoFactor.DisableTOTP(lUser.ID)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("DisableTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Two-factor authentication disabled",
+		Data:    nil,
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("DisableTwoFactorAPI(-)")
+}
+
+// LoginTwoFactorAPI serves POST /api/auth/login/2fa, the second step of a
+// login with 2FA on: the challenge token from LoginAPI plus a TOTP or recovery
+// code are exchanged for the session.
+func LoginTwoFactorAPI(w http.ResponseWriter, r *http.Request) {
+	log.Println("LoginTwoFactorAPI(+)")
+
+	var lReq LoginTwoFactorRequest
+	lErr := ReadBody(w, r, &lReq)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lChallengeHash := HashToken(lReq.ChallengeToken)
+	lUserID, lErr := GetStore().UserTokens.GetUserToken(UserTokenLoginChallenge, lChallengeHash, time.Now())
+	if lErr == ErrUserTokenNotFound {
+		lErr = ErrInvalidChallenge
+	}
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lUser, lErr := GetStore().Users.GetUserByID(lUserID)
+	if lErr == nil && lUser.DisabledAt != nil {
+		lErr = ErrAccountDisabled
+	}
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	if !checkTwoFactorCode(w, r, lUser, lReq.Code) {
+		log.Println("LoginTwoFactorAPI(-) error: code rejected")
+		return
+	}
+
+	// Consuming the challenge last means a mistyped code can be retried, and
+	// that a challenge cannot be exchanged twice.
+	_, lErr = GetStore().UserTokens.ConsumeUserToken(UserTokenLoginChallenge, lChallengeHash, time.Now())
+	if lErr == ErrUserTokenNotFound {
+		lErr = ErrInvalidChallenge
+	}
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lTokens, lErr := CreateSession(lUser.ID, RequestClient(r))
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		log.Println("LoginTwoFactorAPI(-) error:", lErr)
+		return
+	}
+
+	lUser.Password = ""
+	lResponse := APIResponse{
+		Status:  "s",
+		Message: "Login successful",
+		Data:    authData(lUser, lTokens),
+	}
+
+	SendJSONResponse(w, lResponse, http.StatusOK)
+	log.Println("LoginTwoFactorAPI(-)")
+}
+
+// checkTwoFactorCode verifies pCode for pUser under the login rate limiter:
+// wrong codes count as failed logins of pUser's account. When the code is
+// refused, the error response has already been sent.
+func checkTwoFactorCode(w http.ResponseWriter, r *http.Request, pUser *User, pCode string) bool {
+	lIP := ClientIP(r)
+
+	lThrottleKey := UserThrottleKey(pUser.ID)
+	lRetryAfter, lErr := LoginRetryAfter(lThrottleKey, lIP)
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		return false
+	}
+	if lRetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lRetryAfter.Seconds()))))
+		SendErrorResponse(w, ErrTooManyLoginAttempts)
+		return false
+	}
+
+	lErr = VerifyTwoFactorCode(pUser, pCode)
+	if lErr == ErrInvalidTwoFactorCode {
+		lRecordErr := RecordLoginFailure(lThrottleKey, pUser.Username, lIP)
+		if lRecordErr != nil {
+			log.Println("checkTwoFactorCode record failure error:", lRecordErr)
+		}
+	}
+	if lErr != nil {
+		SendErrorResponse(w, lErr)
+		return false
+	}
+
+	lErr = ClearLoginFailures(lThrottleKey)
+	if lErr != nil {
+		log.Println("checkTwoFactorCode clear failures error:", lErr)
+	}
+	return true
+}
+
+// StartLoginChallenge issues the challenge token a 2FA login is finished
+// with, valid for LOGIN_CHALLENGE_TTL (default 5m).
+func StartLoginChallenge(pUserID int) (*LoginChallenge, error) {
+	log.Println("StartLoginChallenge(+)")
+
+	lToken, lErr := NewToken()
+	if lErr != nil {
+		log.Println("StartLoginChallenge(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lExpiresAt := time.Now().Add(GetEnvDuration("LOGIN_CHALLENGE_TTL", 5*time.Minute))
+	lErr = GetStore().UserTokens.CreateUserToken(pUserID, UserTokenLoginChallenge, HashToken(lToken), lExpiresAt)
+	if lErr != nil {
+		log.Println("StartLoginChallenge(-) error:", lErr)
+		return nil, lErr
+	}
+
+	log.Println("StartLoginChallenge(-)")
+	return &LoginChallenge{TwoFactorRequired: true, ChallengeToken: lToken, ExpiresAt: lExpiresAt}, nil
+}
+
+func EnrollTwoFactor(pUser *User) (*TwoFactorEnrollment, error) {
+	log.Println("EnrollTwoFactor(+)")
+
+	lSecret, lErr := NewTOTPSecret()
+	if lErr != nil {
+		log.Println("EnrollTwoFactor(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lErr = GetStore().TwoFactor.SetPendingTOTPSecret(pUser.ID, lSecret)
+	if lErr != nil {
+		log.Println("EnrollTwoFactor(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lIssuer := GetEnvString("TOTP_ISSUER", "Todo SaaS")
+	log.Println("EnrollTwoFactor(-)")
+	return &TwoFactorEnrollment{Secret: lSecret, OTPAuthURI: TOTPURI(lIssuer, pUser.Username, lSecret)}, nil
+}
+
+// ConfirmTwoFactor enables 2FA once pCode matches the pending secret and
+// returns a fresh set of recovery codes.
+func ConfirmTwoFactor(pUserID int, pCode string) ([]string, error) {
+	log.Println("ConfirmTwoFactor(+)")
+
+	lUser, lErr := GetStore().Users.GetUserByID(pUserID)
+	if lErr != nil {
+		log.Println("ConfirmTwoFactor(-) error:", lErr)
+		return nil, lErr
+	}
+	if lUser.TwoFactorEnabledAt != nil {
+		log.Println("ConfirmTwoFactor(-) error:", ErrTwoFactorAlreadyEnabled)
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if lUser.TOTPSecret == "" {
+		log.Println("ConfirmTwoFactor(-) error:", ErrTwoFactorNotPending)
+		return nil, ErrTwoFactorNotPending
+	}
+
+	lStep, lOk := MatchTOTPCode(lUser.TOTPSecret, normalizeTwoFactorCode(pCode), time.Now())
+	if !lOk {
+		log.Println("ConfirmTwoFactor(-) error:", ErrInvalidTwoFactorCode)
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	lCodesArr, lHashesArr, lErr := newRecoveryCodes(RecoveryCodeCount)
+	if lErr != nil {
+		log.Println("ConfirmTwoFactor(-) error:", lErr)
+		return nil, lErr
+	}
+
+	lErr = GetStore().TwoFactor.EnableTOTP(pUserID, lUser.TOTPSecret, lStep, lHashesArr, time.Now())
+	if lErr != nil {
+		log.Println("ConfirmTwoFactor(-) error:", lErr)
+		return nil, lErr
+	}
+
+	log.Println("ConfirmTwoFactor(-)")
+	return lCodesArr, nil
+}
+
+// VerifyTwoFactorCode accepts either a TOTP code that has not been used
+// before or an unused recovery code, which is then used up.
+func VerifyTwoFactorCode(pUser *User, pCode string) error {
+	log.Println("VerifyTwoFactorCode(+)")
+
+	if pUser.TwoFactorEnabledAt == nil {
+		log.Println("VerifyTwoFactorCode(-) error:", ErrTwoFactorNotEnabled)
+		return ErrTwoFactorNotEnabled
+	}
+
+	lCode := normalizeTwoFactorCode(pCode)
+	if len(lCode) == totpDigits {
+		lStep, lOk := MatchTOTPCode(pUser.TOTPSecret, lCode, time.Now())
+		if !lOk {
+			log.Println("VerifyTwoFactorCode(-) error:", ErrInvalidTwoFactorCode)
+			return ErrInvalidTwoFactorCode
+		}
+
+		lFresh, lErr := GetStore().TwoFactor.UseTOTPStep(pUser.ID, lStep)
+		if lErr != nil {
+			log.Println("VerifyTwoFactorCode(-) error:", lErr)
+			return lErr
+		}
+		if !lFresh {
+			log.Println("VerifyTwoFactorCode(-) error: code already used")
+			return ErrInvalidTwoFactorCode
+		}
+
+		log.Println("VerifyTwoFactorCode(-)")
+		return nil
+	}
+
+	lErr := GetStore().TwoFactor.ConsumeRecoveryCode(pUser.ID, HashToken(lCode), time.Now())
+	if lErr == ErrRecoveryCodeNotFound {
+		log.Println("VerifyTwoFactorCode(-) error:", lErr)
+		return ErrInvalidTwoFactorCode
+	}
+	if lErr != nil {
+		log.Println("VerifyTwoFactorCode(-) error:", lErr)
+		return lErr
+	}
+
+	log.Println("VerifyTwoFactorCode(-) recovery code used")
+	return nil
+}
+
+// normalizeTwoFactorCode drops the spaces and dashes people type or paste
+// into codes, and lower-cases recovery codes.
+func normalizeTwoFactorCode(pCode string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(pCode)))
+}
+
+// newRecoveryCodes returns pCount random recovery codes, formatted
+// "xxxxx-xxxxx" for the user, together with the hashes to store.
+func newRecoveryCodes(pCount int) ([]string, []string, error) {
+	lCodesArr := make([]string, 0, pCount)
+	lHashesArr := make([]string, 0, pCount)
+
+	// Bytes at or above the largest multiple of the alphabet size are
+	// skipped so that every character is equally likely.
+	lLimit := 256 - 256%len(recoveryCodeAlphabet)
+	lByte := make([]byte, 1)
+
+	for len(lCodesArr) < pCount {
+		lCode := make([]byte, 0, recoveryCodeLength)
+		for len(lCode) < recoveryCodeLength {
+			_, lErr := rand.Read(lByte)
+			if lErr != nil {
+				return nil, nil, lErr
+			}
+			if int(lByte[0]) < lLimit {
+				lCode = append(lCode, recoveryCodeAlphabet[int(lByte[0])%len(recoveryCodeAlphabet)])
+			}
+		}
+
+		lHalf := recoveryCodeLength / 2
+		lCodesArr = append(lCodesArr, string(lCode[:lHalf])+"-"+string(lCode[lHalf:]))
+		lHashesArr = append(lHashesArr, HashToken(string(lCode)))
+	}
+	return lCodesArr, lHashesArr, nil
+}
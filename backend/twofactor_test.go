@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// wrongTOTPCode returns a well-formed code that no step around now accepts.
+func wrongTOTPCode(t *testing.T, pSecret string) string {
+	t.Helper()
+
+	for _, lCode := range []string{"000000", "111111", "222222", "333333"} {
+		_, lOk := MatchTOTPCode(pSecret, lCode, time.Now())
+		if !lOk {
+			return lCode
+		}
+	}
+	t.Fatal("no wrong code found")
+	return ""
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	lServer := newTestServer(t)
+	lAuth := signupTestUser(t, lServer, "erin")
+
+	var lEnrollment TwoFactorEnrollment
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/2fa/enroll", lAuth.Token, nil, nil, &lEnrollment)
+	if lResponse.StatusCode != http.StatusOK || lEnrollment.Secret == "" {
+		t.Fatalf("enroll: %d, secret %q", lResponse.StatusCode, lEnrollment.Secret)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/2fa/confirm", lAuth.Token, nil, TwoFactorCodeRequest{Code: wrongTOTPCode(t, lEnrollment.Secret)}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("confirm with a wrong code: %d, want 401", lResponse.StatusCode)
+	}
+
+	lConfirmCode, _ := TOTPCode(lEnrollment.Secret, TOTPStep(time.Now()))
+	var lConfirmed struct {
+		RecoveryCodesArr []string `json:"recovery_codes"`
+	}
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/2fa/confirm", lAuth.Token, nil, TwoFactorCodeRequest{Code: lConfirmCode}, &lConfirmed)
+	if lResponse.StatusCode != http.StatusOK || len(lConfirmed.RecoveryCodesArr) == 0 {
+		t.Fatalf("confirm: %d, %d recovery codes", lResponse.StatusCode, len(lConfirmed.RecoveryCodesArr))
+	}
+
+	// The password alone now only earns a challenge.
+	lChallenge := startTestChallenge(t, lServer, "erin")
+
+	lCasesArr := []struct {
+		name string
+		code string
+	}{
+		{"wrong code", wrongTOTPCode(t, lEnrollment.Secret)},
+		{"replayed code", lConfirmCode},
+	}
+	for _, lCase := range lCasesArr {
+		lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/login/2fa", "", nil, LoginTwoFactorRequest{ChallengeToken: lChallenge, Code: lCase.code}, nil)
+		if lResponse.StatusCode != http.StatusUnauthorized || lAPIResponse.Code != CodeInvalidTwoFactorCode {
+			t.Errorf("%s: %d %s, want 401 %s", lCase.name, lResponse.StatusCode, lAPIResponse.Code, CodeInvalidTwoFactorCode)
+		}
+	}
+
+	// A failed code leaves the challenge usable; a fresh step completes it.
+	lNextCode, _ := TOTPCode(lEnrollment.Secret, TOTPStep(time.Now())+1)
+	var lLoggedIn testAuth
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login/2fa", "", nil, LoginTwoFactorRequest{ChallengeToken: lChallenge, Code: lNextCode}, &lLoggedIn)
+	if lResponse.StatusCode != http.StatusOK || lLoggedIn.Token == "" {
+		t.Fatalf("login with a fresh code: %d", lResponse.StatusCode)
+	}
+
+	lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/login/2fa", "", nil, LoginTwoFactorRequest{ChallengeToken: lChallenge, Code: lConfirmed.RecoveryCodesArr[0]}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized || lAPIResponse.Code != CodeInvalidChallenge {
+		t.Errorf("reused challenge: %d %s, want 401 %s", lResponse.StatusCode, lAPIResponse.Code, CodeInvalidChallenge)
+	}
+}
+
+func TestTwoFactorRecoveryCode(t *testing.T) {
+	lServer := newTestServer(t)
+	lAuth := signupTestUser(t, lServer, "frank")
+
+	var lEnrollment TwoFactorEnrollment
+	callAPI(t, lServer, http.MethodPost, "/api/auth/2fa/enroll", lAuth.Token, nil, nil, &lEnrollment)
+	lCode, _ := TOTPCode(lEnrollment.Secret, TOTPStep(time.Now()))
+	var lConfirmed struct {
+		RecoveryCodesArr []string `json:"recovery_codes"`
+	}
+	callAPI(t, lServer, http.MethodPost, "/api/auth/2fa/confirm", lAuth.Token, nil, TwoFactorCodeRequest{Code: lCode}, &lConfirmed)
+	if len(lConfirmed.RecoveryCodesArr) == 0 {
+		t.Fatal("no recovery codes")
+	}
+	lRecoveryCode := lConfirmed.RecoveryCodesArr[0]
+
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/login/2fa", "", nil, LoginTwoFactorRequest{ChallengeToken: startTestChallenge(t, lServer, "frank"), Code: lRecoveryCode}, nil)
+	if lResponse.StatusCode != http.StatusOK {
+		t.Fatalf("login with a recovery code: %d", lResponse.StatusCode)
+	}
+
+	lResponse, _ = callAPI(t, lServer, http.MethodPost, "/api/auth/login/2fa", "", nil, LoginTwoFactorRequest{ChallengeToken: startTestChallenge(t, lServer, "frank"), Code: lRecoveryCode}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with a spent recovery code: %d, want 401", lResponse.StatusCode)
+	}
+}
+
+// startTestChallenge logs in as pUsername, which has 2FA on, and returns the
+// challenge token.
+func startTestChallenge(t *testing.T, pServer *httptest.Server, pUsername string) string {
+	t.Helper()
+
+	var lChallenge LoginChallenge
+	lResponse, _ := callAPI(t, pServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Identifier: pUsername, Password: testPassword}, &lChallenge)
+	if lResponse.StatusCode != http.StatusOK || !lChallenge.TwoFactorRequired || lChallenge.ChallengeToken == "" {
+		t.Fatalf("login of %s: %d, challenge %+v", pUsername, lResponse.StatusCode, lChallenge)
+	}
+	return lChallenge.ChallengeToken
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"net/mail"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	MinUsernameLength = 3
+	// MaxUsernameLength and MaxEmailLength match the users table columns.
+	MaxUsernameLength = 50
+	MaxEmailLength    = 100
+
+	DefaultPasswordMinLength = 8
+	// MaxPasswordBytes is bcrypt's input limit; anything longer would be
+	// silently ignored when hashing.
+	MaxPasswordBytes = 72
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// commonPasswordsArr is checked even when no BREACHED_PASSWORDS_FILE is
+// configured.
+var commonPasswordsArr = []string{
+	"password", "password1", "password123", "passw0rd", "12345678", "123456789",
+	"1234567890", "87654321", "11111111", "00000000", "qwerty123", "qwertyuiop",
+	"1q2w3e4r", "1qaz2wsx", "abc12345", "abcd1234", "iloveyou", "letmein1",
+	"sunshine", "princess", "football", "baseball", "superman", "trustno1",
+	"welcome1", "admin123", "changeme", "monkey123", "dragon123", "starwars",
+}
+
+var (
+	lBreachedPasswordsOnce sync.Once
+	lBreachedPasswordsMap  map[string]bool
+)
+
+// ValidateSignup checks a signup request before anything is hashed or stored
+// and reports every invalid field at once as a validation error.
+func ValidateSignup(pReq SignupRequest) error {
+	lFieldsMap := map[string]string{}
+
+	if lMessage := validateUsername(pReq.Username); lMessage != "" {
+		lFieldsMap["username"] = lMessage
+	}
+	if lMessage := validateEmail(pReq.Email); lMessage != "" {
+		lFieldsMap["email"] = lMessage
+	}
+	if lMessage := ValidatePassword(pReq.Password, pReq.Username); lMessage != "" {
+		lFieldsMap["password"] = lMessage
+	}
+
+	if len(lFieldsMap) > 0 {
+		return NewValidationError(lFieldsMap)
+	}
+	return nil
+}
+
+func validateUsername(pUsername string) string {
+	lLength := utf8.RuneCountInString(pUsername)
+	switch {
+	case pUsername == "":
+		return "Username is required"
+	case lLength < MinUsernameLength || lLength > MaxUsernameLength:
+		return "Username must be " + strconv.Itoa(MinUsernameLength) + " to " + strconv.Itoa(MaxUsernameLength) + " characters"
+	case !usernamePattern.MatchString(pUsername):
+		return "Username may only contain letters, digits, '.', '_' and '-'"
+	}
+	return ""
+}
+
+func validateEmail(pEmail string) string {
+	if pEmail == "" {
+		return "Email is required"
+	}
+	if len(pEmail) > MaxEmailLength {
+		return "Email must be at most " + strconv.Itoa(MaxEmailLength) + " characters"
+	}
+
+	// ParseAddress also accepts forms such as "Name <a@b.c>"; only a bare
+	// address is wanted here.
+	lAddress, lErr := mail.ParseAddress(pEmail)
+	if lErr != nil || lAddress.Address != pEmail || !strings.Contains(pEmail[strings.LastIndex(pEmail, "@"):], ".") {
+		return "Email is not a valid address"
+	}
+	return ""
+}
+
+// ValidatePassword applies the password policy: at least PASSWORD_MIN_LENGTH
+// characters (default 8), at most MaxPasswordBytes bytes, not the username,
+// and not a known breached or common password. It returns a message for the
+// client, or "" when pPassword is acceptable.
+func ValidatePassword(pPassword string, pUsername string) string {
+	lMinLength := GetEnvInt("PASSWORD_MIN_LENGTH", DefaultPasswordMinLength)
+
+	switch {
+	case pPassword == "":
+		return "Password is required"
+	case utf8.RuneCountInString(pPassword) < lMinLength:
+		return "Password must be at least " + strconv.Itoa(lMinLength) + " characters"
+	case len(pPassword) > MaxPasswordBytes:
+		return "Password must be at most " + strconv.Itoa(MaxPasswordBytes) + " bytes"
+	case pUsername != "" && strings.EqualFold(pPassword, pUsername):
+		return "Password must not be the same as the username"
+	case isBreachedPassword(pPassword):
+		return "Password is too common or has appeared in a data breach"
+	}
+	return ""
+}
+
+func isBreachedPassword(pPassword string) bool {
+	lBreachedPasswordsOnce.Do(loadBreachedPasswords)
+	return lBreachedPasswordsMap[strings.ToLower(pPassword)]
+}
+
+// loadBreachedPasswords builds the breached-password set from
+// commonPasswordsArr and, when BREACHED_PASSWORDS_FILE is set, that file's
+// lines (one password per line).
+func loadBreachedPasswords() {
+	lBreachedPasswordsMap = map[string]bool{}
+	for _, lPassword := range commonPasswordsArr {
+		lBreachedPasswordsMap[lPassword] = true
+	}
+
+	lPath := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if lPath == "" {
+		return
+	}
+
+	lFile, lErr := os.Open(lPath)
+	if lErr != nil {
+		log.Println("loadBreachedPasswords error:", lErr)
+		return
+	}
+	defer lFile.Close()
+
+	lScanner := bufio.NewScanner(lFile)
+	for lScanner.Scan() {
+		lPassword := strings.TrimSpace(lScanner.Text())
+		if lPassword != "" {
+			lBreachedPasswordsMap[strings.ToLower(lPassword)] = true
+		}
+	}
+	if lErr = lScanner.Err(); lErr != nil {
+		log.Println("loadBreachedPasswords error:", lErr)
+	}
+	log.Printf("loadBreachedPasswords: %d passwords loaded", len(lBreachedPasswordsMap))
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSignupValidationErrors(t *testing.T) {
+	lServer := newTestServer(t)
+
+	lCasesArr := []struct {
+		name   string
+		req    SignupRequest
+		fields []string
+	}{
+		{"empty", SignupRequest{}, []string{"email", "password", "username"}},
+		{"short username", SignupRequest{Username: "al", Email: "al@example.com", Password: testPassword}, []string{"username"}},
+		{"username characters", SignupRequest{Username: "al ice", Email: "alice@example.com", Password: testPassword}, []string{"username"}},
+		{"display-name email", SignupRequest{Username: "alice", Email: "Alice <alice@example.com>", Password: testPassword}, []string{"email"}},
+		{"email without a dot", SignupRequest{Username: "alice", Email: "alice@localhost", Password: testPassword}, []string{"email"}},
+		{"short password", SignupRequest{Username: "alice", Email: "alice@example.com", Password: "Ab1-"}, []string{"password"}},
+		{"password over 72 bytes", SignupRequest{Username: "alice", Email: "alice@example.com", Password: strings.Repeat("Zebra-", 13)}, []string{"password"}},
+		{"password is the username", SignupRequest{Username: "Zebra-horse", Email: "z@example.com", Password: "zebra-HORSE"}, []string{"password"}},
+		{"common password", SignupRequest{Username: "alice", Email: "alice@example.com", Password: "Password123"}, []string{"password"}},
+		{"everything at once", SignupRequest{Username: "a!", Email: "nope", Password: "letmein1"}, []string{"email", "password", "username"}},
+	}
+
+	for _, lCase := range lCasesArr {
+		lResponse, lAPIResponse := callAPI(t, lServer, http.MethodPost, "/api/auth/signup", "", nil, lCase.req, nil)
+		if lResponse.StatusCode != http.StatusUnprocessableEntity || lAPIResponse.Code != CodeValidationFailed {
+			t.Errorf("%s: %d %s, want 422 %s", lCase.name, lResponse.StatusCode, lAPIResponse.Code, CodeValidationFailed)
+			continue
+		}
+
+		lFieldsArr := []string{}
+		for lField, lMessage := range lAPIResponse.Errors {
+			if lMessage == "" {
+				t.Errorf("%s: %s has no message", lCase.name, lField)
+			}
+			lFieldsArr = append(lFieldsArr, lField)
+		}
+		sort.Strings(lFieldsArr)
+		if !reflect.DeepEqual(lFieldsArr, lCase.fields) {
+			t.Errorf("%s: errors on %v, want %v", lCase.name, lFieldsArr, lCase.fields)
+		}
+	}
+
+	// Nothing invalid was stored.
+	lResponse, _ := callAPI(t, lServer, http.MethodPost, "/api/auth/login", "", nil, LoginRequest{Username: "alice", Password: testPassword}, nil)
+	if lResponse.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login after rejected signups: %d, want 401", lResponse.StatusCode)
+	}
+}
@@ -3,6 +3,11 @@ import VueRouter from 'vue-router'
 import LoginView from '../views/LoginView.vue'
 import SignupView from '../views/SignupView.vue'
 import TodoView from '../views/TodoView.vue'
+import VerifyEmailView from '../views/VerifyEmailView.vue'
+import ForgotPasswordView from '../views/ForgotPasswordView.vue'
+import ResetPasswordView from '../views/ResetPasswordView.vue'
+import OIDCCallbackView from '../views/OIDCCallbackView.vue'
+import AdminView from '../views/AdminView.vue'
 
 Vue.use(VueRouter)
 
@@ -21,6 +26,26 @@ const routes = [
     name: 'Signup',
     component: SignupView
   },
+  {
+    path: '/verify-email',
+    name: 'VerifyEmail',
+    component: VerifyEmailView
+  },
+  {
+    path: '/forgot-password',
+    name: 'ForgotPassword',
+    component: ForgotPasswordView
+  },
+  {
+    path: '/reset-password',
+    name: 'ResetPassword',
+    component: ResetPasswordView
+  },
+  {
+    path: '/oidc/callback',
+    name: 'OIDCCallback',
+    component: OIDCCallbackView
+  },
   {
     path: '/todos',
     name: 'Todos',
@@ -28,6 +53,14 @@ const routes = [
     meta: {
       requiresAuth: true
     }
+  },
+  {
+    path: '/admin',
+    name: 'Admin',
+    component: AdminView,
+    meta: {
+      requiresAuth: true
+    }
   }
 ]
 
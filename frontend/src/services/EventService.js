@@ -10,6 +10,47 @@ const lAxiosInstance = axios.create({
   }
 })
 
+// Access tokens are short-lived. When a request that carried one comes back
+// 401, trade the stored refresh token for a new pair once and replay the
+// request. Concurrent failures share a single refresh, since each refresh
+// token may only be used once.
+let lRefreshPromise = null
+
+function refreshSession() {
+  if (!lRefreshPromise) {
+    const lRefreshToken = localStorage.getItem('refreshToken')
+    if (!lRefreshToken) {
+      return Promise.reject(new Error('No refresh token'))
+    }
+
+    lRefreshPromise = EventService.refresh(lRefreshToken)
+      .then((lRes) => {
+        localStorage.setItem('token', lRes.data.data.token)
+        localStorage.setItem('refreshToken', lRes.data.data.refresh_token)
+        localStorage.setItem('user', JSON.stringify(lRes.data.data.user))
+        return lRes.data.data.token
+      })
+      .finally(() => {
+        lRefreshPromise = null
+      })
+  }
+  return lRefreshPromise
+}
+
+lAxiosInstance.interceptors.response.use(null, (lErr) => {
+  const lConfig = lErr.config
+  if (!lErr.response || lErr.response.status !== 401 || !lConfig || lConfig.retried ||
+      lConfig.url === '/auth/refresh' || !lConfig.headers || !lConfig.headers['Authorization']) {
+    return Promise.reject(lErr)
+  }
+
+  lConfig.retried = true
+  return refreshSession().then((lToken) => {
+    lConfig.headers['Authorization'] = lToken
+    return lAxiosInstance(lConfig)
+  }, () => Promise.reject(lErr))
+})
+
 const EventService = {
   signup: function(pData) {
     return lAxiosInstance.post('/auth/signup', pData)
@@ -19,6 +60,26 @@ const EventService = {
     return lAxiosInstance.post('/auth/login', pData)
   },
 
+  loginTwoFactor: function(pChallengeToken, pCode) {
+    return lAxiosInstance.post('/auth/login/2fa', { challenge_token: pChallengeToken, code: pCode })
+  },
+
+  listOIDCProviders: function() {
+    return lAxiosInstance.get('/auth/oidc/providers')
+  },
+
+  startOIDCLogin: function(pProvider) {
+    return lAxiosInstance.post('/auth/oidc/' + encodeURIComponent(pProvider) + '/start')
+  },
+
+  completeOIDCLogin: function(pState, pCode) {
+    return lAxiosInstance.post('/auth/oidc/callback', { state: pState, code: pCode })
+  },
+
+  refresh: function(pRefreshToken) {
+    return lAxiosInstance.post('/auth/refresh', { refresh_token: pRefreshToken })
+  },
+
   logout: function(pToken) {
     return lAxiosInstance.post('/auth/logout', null, {
       headers: { 'Authorization': pToken }
@@ -31,15 +92,144 @@ const EventService = {
     })
   },
 
+  verifyEmail: function(pVerificationToken) {
+    return lAxiosInstance.post('/auth/verify-email', { token: pVerificationToken })
+  },
+
+  resendVerificationEmail: function(pToken) {
+    return lAxiosInstance.post('/auth/verify-email/resend', null, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  forgotPassword: function(pEmail) {
+    return lAxiosInstance.post('/auth/forgot-password', { email: pEmail })
+  },
+
+  resetPassword: function(pResetToken, pPassword) {
+    return lAxiosInstance.post('/auth/reset-password', { token: pResetToken, password: pPassword })
+  },
+
+  enrollTwoFactor: function(pToken) {
+    return lAxiosInstance.post('/auth/2fa/enroll', null, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  confirmTwoFactor: function(pCode, pToken) {
+    return lAxiosInstance.post('/auth/2fa/confirm', { code: pCode }, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  disableTwoFactor: function(pCode, pToken) {
+    return lAxiosInstance.post('/auth/2fa/disable', { code: pCode }, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  listSessions: function(pToken) {
+    return lAxiosInstance.get('/auth/sessions', {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  revokeSession: function(pSessionID, pToken) {
+    return lAxiosInstance.delete('/auth/sessions/' + pSessionID, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  revokeOtherSessions: function(pToken) {
+    return lAxiosInstance.delete('/auth/sessions', {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  getMe: function(pToken) {
+    return lAxiosInstance.get('/me', {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  updateMe: function(pData, pToken) {
+    return lAxiosInstance.patch('/me', pData, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  changePassword: function(pCurrentPassword, pNewPassword, pToken) {
+    return lAxiosInstance.post('/me/password', { current_password: pCurrentPassword, new_password: pNewPassword }, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  deleteMe: function(pPassword, pToken) {
+    return lAxiosInstance.delete('/me', {
+      headers: { 'Authorization': pToken },
+      data: { password: pPassword }
+    })
+  },
+
+  listAPIKeys: function(pToken) {
+    return lAxiosInstance.get('/auth/api-keys', {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  createAPIKey: function(pData, pToken) {
+    return lAxiosInstance.post('/auth/api-keys', pData, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  deleteAPIKey: function(pKeyID, pToken) {
+    return lAxiosInstance.delete('/auth/api-keys/' + pKeyID, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  listUsers: function(pToken, pParams) {
+    return lAxiosInstance.get('/admin/users', {
+      headers: { 'Authorization': pToken },
+      params: pParams
+    })
+  },
+
+  disableUser: function(pUserID, pToken) {
+    return lAxiosInstance.post('/admin/users/' + pUserID + '/disable', null, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  enableUser: function(pUserID, pToken) {
+    return lAxiosInstance.post('/admin/users/' + pUserID + '/enable', null, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  forcePasswordReset: function(pUserID, pToken) {
+    return lAxiosInstance.post('/admin/users/' + pUserID + '/password-reset', null, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
+  revokeUserSessions: function(pUserID, pToken) {
+    return lAxiosInstance.delete('/admin/users/' + pUserID + '/sessions', {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
   createTodo: function(pData, pToken) {
     return lAxiosInstance.post('/todos', pData, {
       headers: { 'Authorization': pToken }
     })
   },
 
-  listTodos: function(pToken) {
+  listTodos: function(pToken, pParams) {
     return lAxiosInstance.get('/todos', {
-      headers: { 'Authorization': pToken }
+      headers: { 'Authorization': pToken },
+      params: pParams
     })
   },
 
@@ -49,6 +239,12 @@ const EventService = {
     })
   },
 
+  patchTodo: function(pTodoID, pData, pToken) {
+    return lAxiosInstance.patch('/todos/' + pTodoID, pData, {
+      headers: { 'Authorization': pToken }
+    })
+  },
+
   deleteTodo: function(pTodoID, pToken) {
     return lAxiosInstance.delete('/todos/' + pTodoID, {
       headers: { 'Authorization': pToken }